@@ -1,5 +1,5 @@
 from django.contrib import admin
-from .models import Queue, QueueEntry
+from .models import Appointment, Queue, QueueEntry
 
 
 @admin.register(Queue)
@@ -23,4 +23,12 @@ class QueueEntryAdmin(admin.ModelAdmin):
     )
     list_filter = ("queue", "status", "left")
     search_fields = ("msisdn", "full_name")
-    readonly_fields = ("joined_at", "started_at", "served_at")  # auto timestamps
\ No newline at end of file
+    readonly_fields = ("joined_at", "started_at", "served_at")  # auto timestamps
+
+
+@admin.register(Appointment)
+class AppointmentAdmin(admin.ModelAdmin):
+    list_display = ("msisdn", "full_name", "queue", "scheduled_at", "status", "entry")
+    list_filter = ("queue", "status")
+    search_fields = ("msisdn", "full_name")
+    ordering = ("scheduled_at",)
@@ -0,0 +1,50 @@
+from django.db import migrations
+
+
+# Lets the Go queue-scheduler LISTEN for changes instead of waiting for its
+# next poll. Only installed on PostgreSQL; other backends are left untouched.
+CREATE_TRIGGER = """
+CREATE OR REPLACE FUNCTION minaturn_notify_queue_change() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('minaturn_queue_changed', TG_TABLE_NAME);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS minaturn_queue_notify ON minaturn_queue;
+CREATE TRIGGER minaturn_queue_notify
+    AFTER INSERT OR UPDATE OR DELETE ON minaturn_queue
+    FOR EACH STATEMENT EXECUTE FUNCTION minaturn_notify_queue_change();
+
+DROP TRIGGER IF EXISTS minaturn_queueentry_notify ON minaturn_queueentry;
+CREATE TRIGGER minaturn_queueentry_notify
+    AFTER INSERT OR UPDATE OR DELETE ON minaturn_queueentry
+    FOR EACH STATEMENT EXECUTE FUNCTION minaturn_notify_queue_change();
+"""
+
+DROP_TRIGGER = """
+DROP TRIGGER IF EXISTS minaturn_queueentry_notify ON minaturn_queueentry;
+DROP TRIGGER IF EXISTS minaturn_queue_notify ON minaturn_queue;
+DROP FUNCTION IF EXISTS minaturn_notify_queue_change();
+"""
+
+
+def create_trigger(apps, schema_editor):
+    if schema_editor.connection.vendor == "postgresql":
+        schema_editor.execute(CREATE_TRIGGER)
+
+
+def drop_trigger(apps, schema_editor):
+    if schema_editor.connection.vendor == "postgresql":
+        schema_editor.execute(DROP_TRIGGER)
+
+
+class Migration(migrations.Migration):
+
+    dependencies = [
+        ('minaturn', '0006_queueentry_served_at_queueentry_started_at'),
+    ]
+
+    operations = [
+        migrations.RunPython(create_trigger, drop_trigger),
+    ]
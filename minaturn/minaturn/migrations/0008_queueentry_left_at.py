@@ -0,0 +1,16 @@
+from django.db import migrations, models
+
+
+class Migration(migrations.Migration):
+
+    dependencies = [
+        ('minaturn', '0007_queue_change_notify'),
+    ]
+
+    operations = [
+        migrations.AddField(
+            model_name='queueentry',
+            name='left_at',
+            field=models.DateTimeField(blank=True, null=True),
+        ),
+    ]
@@ -0,0 +1,16 @@
+from django.db import migrations, models
+
+
+class Migration(migrations.Migration):
+
+    dependencies = [
+        ('minaturn', '0008_queueentry_left_at'),
+    ]
+
+    operations = [
+        migrations.AddField(
+            model_name='queueentry',
+            name='priority',
+            field=models.CharField(choices=[('standard', 'Standard'), ('assisted', 'Elderly or disabled'), ('appointment', 'Appointment')], default='standard', max_length=20),
+        ),
+    ]
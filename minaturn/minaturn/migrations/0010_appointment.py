@@ -0,0 +1,26 @@
+import django.db.models.deletion
+import shortuuid.django_fields
+from django.db import migrations, models
+
+
+class Migration(migrations.Migration):
+
+    dependencies = [
+        ('minaturn', '0009_queueentry_priority'),
+    ]
+
+    operations = [
+        migrations.CreateModel(
+            name='Appointment',
+            fields=[
+                ('id', shortuuid.django_fields.ShortUUIDField(alphabet='BCNTVWXZ1234567890', length=8, max_length=8, prefix='', primary_key=True, serialize=False)),
+                ('msisdn', models.CharField(max_length=15)),
+                ('full_name', models.CharField(blank=True, max_length=255, null=True)),
+                ('scheduled_at', models.DateTimeField()),
+                ('status', models.CharField(choices=[('booked', 'Booked'), ('checked_in', 'Checked in'), ('no_show', 'No-show'), ('cancelled', 'Cancelled')], default='booked', max_length=20)),
+                ('created_at', models.DateTimeField(auto_now_add=True)),
+                ('entry', models.OneToOneField(blank=True, null=True, on_delete=django.db.models.deletion.SET_NULL, related_name='appointment', to='minaturn.queueentry')),
+                ('queue', models.ForeignKey(on_delete=django.db.models.deletion.CASCADE, related_name='appointments', to='minaturn.queue')),
+            ],
+        ),
+    ]
@@ -0,0 +1,38 @@
+from django.db import migrations
+
+
+# Bookings, cancellations and check-ins change the slots the queue-scheduler
+# reserves and the reminders it sends, so appointments raise the same NOTIFY
+# as queues and entries (see 0007_queue_change_notify). Only installed on
+# PostgreSQL; other backends are left untouched.
+CREATE_TRIGGER = """
+DROP TRIGGER IF EXISTS minaturn_appointment_notify ON minaturn_appointment;
+CREATE TRIGGER minaturn_appointment_notify
+    AFTER INSERT OR UPDATE OR DELETE ON minaturn_appointment
+    FOR EACH STATEMENT EXECUTE FUNCTION minaturn_notify_queue_change();
+"""
+
+DROP_TRIGGER = """
+DROP TRIGGER IF EXISTS minaturn_appointment_notify ON minaturn_appointment;
+"""
+
+
+def create_trigger(apps, schema_editor):
+    if schema_editor.connection.vendor == "postgresql":
+        schema_editor.execute(CREATE_TRIGGER)
+
+
+def drop_trigger(apps, schema_editor):
+    if schema_editor.connection.vendor == "postgresql":
+        schema_editor.execute(DROP_TRIGGER)
+
+
+class Migration(migrations.Migration):
+
+    dependencies = [
+        ('minaturn', '0010_appointment'),
+    ]
+
+    operations = [
+        migrations.RunPython(create_trigger, drop_trigger),
+    ]
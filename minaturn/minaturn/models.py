@@ -18,6 +18,11 @@ class QueueEntry(models.Model):
         IN_PROGRESS = "in_progress", "In Progress"
         SERVED = "served", "Served"
 
+    class Priority(models.TextChoices):
+        STANDARD = "standard", "Standard"
+        ASSISTED = "assisted", "Elderly or disabled"
+        APPOINTMENT = "appointment", "Appointment"
+
     id = ShortUUIDField(primary_key=True, length=8, alphabet="ADFPHSMR1234567890")
     msisdn = models.CharField(max_length=15)  # phone number
     full_name = models.CharField(max_length=255, blank=True, null=True)
@@ -28,11 +33,17 @@ class QueueEntry(models.Model):
         choices=Status.choices,
         default=Status.WAITING,
     )
+    priority = models.CharField(
+        max_length=20,
+        choices=Priority.choices,
+        default=Priority.STANDARD,
+    )
     queue = models.ForeignKey('Queue', on_delete=models.CASCADE, related_name='items')
 
     # Timestamps for status changes
     started_at = models.DateTimeField(blank=True, null=True)  # when status -> IN_PROGRESS
     served_at = models.DateTimeField(blank=True, null=True)   # when status -> SERVED
+    left_at = models.DateTimeField(blank=True, null=True)     # when left -> True
 
     def save(self, *args, **kwargs):
         # Trigger timestamps based on status change
@@ -40,7 +51,37 @@ class QueueEntry(models.Model):
             self.started_at = timezone.now()
         if self.status == self.Status.SERVED and not self.served_at:
             self.served_at = timezone.now()
+        if self.left and not self.left_at:
+            self.left_at = timezone.now()
         super().save(*args, **kwargs)
 
     def __str__(self):
-        return f"{self.msisdn} in {self.queue.name}"
\ No newline at end of file
+        return f"{self.msisdn} in {self.queue.name}"
+
+
+class Appointment(models.Model):
+    class Status(models.TextChoices):
+        BOOKED = "booked", "Booked"
+        CHECKED_IN = "checked_in", "Checked in"
+        NO_SHOW = "no_show", "No-show"
+        CANCELLED = "cancelled", "Cancelled"
+
+    id = ShortUUIDField(primary_key=True, length=8, alphabet="BCNTVWXZ1234567890")
+    queue = models.ForeignKey('Queue', on_delete=models.CASCADE, related_name='appointments')
+    msisdn = models.CharField(max_length=15)
+    full_name = models.CharField(max_length=255, blank=True, null=True)
+    scheduled_at = models.DateTimeField()
+    status = models.CharField(
+        max_length=20,
+        choices=Status.choices,
+        default=Status.BOOKED,
+    )
+    created_at = models.DateTimeField(auto_now_add=True)
+
+    # Walk-in entry created when the customer checks in
+    entry = models.OneToOneField(
+        'QueueEntry', on_delete=models.SET_NULL, blank=True, null=True, related_name='appointment'
+    )
+
+    def __str__(self):
+        return f"{self.msisdn} at {self.scheduled_at:%Y-%m-%d %H:%M} in {self.queue.name}"
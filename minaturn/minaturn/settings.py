@@ -10,8 +10,13 @@ For the full list of settings and their values, see
 https://docs.djangoproject.com/en/5.2/ref/settings/
 """
 
+import os
+import re
+from datetime import timedelta
 from pathlib import Path
 
+from django.core.exceptions import ImproperlyConfigured
+
 # Build paths inside the project like this: BASE_DIR / 'subdir'.
 BASE_DIR = Path(__file__).resolve().parent.parent
 
@@ -126,3 +131,18 @@ STATIC_URL = 'static/'
 # https://docs.djangoproject.com/en/5.2/ref/settings/#default-auto-field
 
 DEFAULT_AUTO_FIELD = 'django.db.models.BigAutoField'
+
+# How late a customer may check in for an appointment before its slot is
+# released. Read from the same APPOINTMENT_GRACE variable as the queue
+# scheduler, in its duration format (e.g. "10m", "1h30m"), so both agree on
+# when a slot is released. Set it in the environment of both services.
+
+def _parse_duration(value):
+    match = re.fullmatch(r"(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s)?", value.strip())
+    if not value.strip() or match is None:
+        raise ImproperlyConfigured(f"APPOINTMENT_GRACE {value!r} is not a duration like 10m")
+    hours, minutes, seconds = (int(part or 0) for part in match.groups())
+    return timedelta(hours=hours, minutes=minutes, seconds=seconds)
+
+
+APPOINTMENT_GRACE = _parse_duration(os.environ.get("APPOINTMENT_GRACE", "10m"))
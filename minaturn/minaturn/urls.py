@@ -30,4 +30,7 @@ urlpatterns = [
     path("queue/<str:queue_id>/status/<str:msisdn>/update/", views.update_status, name="update_status"),
     path("queue/<str:queue_id>/position/<str:msisdn>/", views.queue_position, name="queue-position"),
     path("queues/all/", views.all_queues_with_entries, name="all_queues_with_entries"),
+    path("appointment/book/", views.book_appointment, name="book_appointment"),
+    path("appointment/<str:appointment_id>/check-in/", views.check_in_appointment, name="check_in_appointment"),
+    path("appointment/<str:appointment_id>/status/", views.update_appointment_status, name="update_appointment_status"),
 ]
\ No newline at end of file
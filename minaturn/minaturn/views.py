@@ -1,9 +1,14 @@
 import json
+from datetime import timedelta
+from django.conf import settings
+from django.db import transaction
 from django.http import JsonResponse
 from django.views.decorators.csrf import csrf_exempt
 from django.views.decorators.http import require_http_methods
 from django.shortcuts import get_object_or_404
-from .models import Queue, QueueEntry
+from django.utils import timezone
+from django.utils.dateparse import parse_datetime
+from .models import Appointment, Queue, QueueEntry
 
 
 @csrf_exempt
@@ -13,10 +18,15 @@ def join_queue(request):
             data = json.loads(request.body.decode("utf-8"))
             queue = get_object_or_404(Queue, id=data["queue_id"])
 
+            priority = data.get("priority", QueueEntry.Priority.STANDARD)
+            if priority not in QueueEntry.Priority.values:
+                return JsonResponse({"error": "Invalid priority"}, status=400)
+
             entry = QueueEntry.objects.create(
                 msisdn=data["msisdn"],
                 queue=queue,
-                left=False
+                left=False,
+                priority=priority
             )
             return JsonResponse({"id": str(entry.id), "status": "joined"})
         except (KeyError, json.JSONDecodeError):
@@ -78,7 +88,9 @@ def get_status(request, queue_id, msisdn):
             "left": entry.left,
             "joined_at": entry.joined_at.isoformat(),
             "started_at": entry.started_at.isoformat() if entry.started_at else None,
-            "served_at": entry.served_at.isoformat() if entry.served_at else None
+            "served_at": entry.served_at.isoformat() if entry.served_at else None,
+            "left_at": entry.left_at.isoformat() if entry.left_at else None,
+            "priority": entry.priority
         })
     except QueueEntry.DoesNotExist:
         return JsonResponse({"error": "Entry not found"}, status=404)
@@ -156,6 +168,9 @@ def all_queues_with_entries(request):
 
     for queue in queues:
         entries = queue.items.all().order_by("joined_at")  # related_name='items'
+        appointments = queue.appointments.filter(
+            scheduled_at__gte=timezone.now() - timedelta(days=1)
+        ).order_by("scheduled_at")
         result.append({
             "queue_id": str(queue.id),
             "name": queue.name,
@@ -163,20 +178,113 @@ def all_queues_with_entries(request):
             "created_at": queue.created_at.isoformat(),
             "entries": [
                 {
+                    "id": str(entry.id),
                     "msisdn": entry.msisdn,
                     "full_name": entry.full_name,
                     "joined_at": entry.joined_at.isoformat(),
                     "left": entry.left,
                     "status": entry.status,
                     "started_at": entry.started_at.isoformat() if entry.started_at else None,
-                    "served_at": entry.served_at.isoformat() if entry.served_at else None
+                    "served_at": entry.served_at.isoformat() if entry.served_at else None,
+                    "left_at": entry.left_at.isoformat() if entry.left_at else None,
+                    "priority": entry.priority
                 } for entry in entries
+            ],
+            "appointments": [
+                {
+                    "id": str(appointment.id),
+                    "msisdn": appointment.msisdn,
+                    "full_name": appointment.full_name,
+                    "scheduled_at": appointment.scheduled_at.isoformat(),
+                    "status": appointment.status,
+                    "entry_id": str(appointment.entry_id) if appointment.entry_id else None
+                } for appointment in appointments
             ]
         })
 
     return JsonResponse({"queues": result})
 
 
+@csrf_exempt
+@require_http_methods(["POST"])
+def book_appointment(request):
+    try:
+        data = json.loads(request.body.decode("utf-8"))
+        queue = get_object_or_404(Queue, id=data["queue_id"])
+        scheduled_at = parse_datetime(data["scheduled_at"])
+        if scheduled_at is None:
+            return JsonResponse({"error": "Invalid scheduled_at"}, status=400)
+
+        appointment = Appointment.objects.create(
+            queue=queue,
+            msisdn=data["msisdn"],
+            full_name=data.get("full_name"),
+            scheduled_at=scheduled_at
+        )
+        return JsonResponse({
+            "id": str(appointment.id),
+            "queue_id": str(queue.id),
+            "scheduled_at": appointment.scheduled_at.isoformat(),
+            "status": appointment.status
+        }, status=201)
+    except (KeyError, ValueError):
+        return JsonResponse({"error": "Invalid request data"}, status=400)
+
+
+@csrf_exempt
+@require_http_methods(["POST"])
+def check_in_appointment(request, appointment_id):
+    # Lock the appointment so concurrent check-ins create only one entry
+    with transaction.atomic():
+        appointment = get_object_or_404(Appointment.objects.select_for_update(), id=appointment_id)
+        if appointment.status != Appointment.Status.BOOKED:
+            return JsonResponse({"error": f"Appointment is {appointment.status}"}, status=409)
+
+        # Past the grace period the scheduler has released the slot and told
+        # the customer so, even if it hasn't marked the no-show yet
+        if timezone.now() > appointment.scheduled_at + settings.APPOINTMENT_GRACE:
+            return JsonResponse({"error": "Appointment slot was released, please join the queue"}, status=409)
+
+        # The customer joins the queue in the appointment lane
+        entry = QueueEntry.objects.create(
+            msisdn=appointment.msisdn,
+            full_name=appointment.full_name,
+            queue=appointment.queue,
+            left=False,
+            priority=QueueEntry.Priority.APPOINTMENT
+        )
+        appointment.entry = entry
+        appointment.status = Appointment.Status.CHECKED_IN
+        appointment.save()
+
+    return JsonResponse({"id": str(appointment.id), "entry_id": str(entry.id), "status": appointment.status})
+
+
+@csrf_exempt
+@require_http_methods(["PUT"])
+def update_appointment_status(request, appointment_id):
+    try:
+        data = json.loads(request.body)
+    except json.JSONDecodeError:
+        return JsonResponse({"error": "Invalid JSON body"}, status=400)
+
+    status = data.get("status")
+    if status not in (Appointment.Status.NO_SHOW, Appointment.Status.CANCELLED):
+        return JsonResponse({"error": "Invalid status, use no_show or cancelled; check in through check-in"}, status=400)
+
+    # Locked like check-in, so a no-show marked from a stale snapshot can't
+    # overwrite a check-in that just happened. Only a booked appointment can
+    # be released; anything else has already been resolved.
+    with transaction.atomic():
+        appointment = get_object_or_404(Appointment.objects.select_for_update(), id=appointment_id)
+        if appointment.status != Appointment.Status.BOOKED:
+            return JsonResponse({"error": f"Appointment is {appointment.status}"}, status=409)
+        appointment.status = status
+        appointment.save()
+
+    return JsonResponse({"id": str(appointment.id), "status": appointment.status})
+
+
 """
 For a given queue, we need the time to AVG_TIME_TO_PROCESS. 
 
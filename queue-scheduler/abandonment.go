@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// abandonmentBucket is the width of the wait-time buckets abandonment is
+	// learned in. The last bucket is open-ended.
+	abandonmentBucket  = 5 * time.Minute
+	abandonmentBuckets = 12
+
+	// Below abandonmentMinObservations finished waits a queue is assumed to
+	// lose nobody. Beyond abandonmentMaxObservations counts are halved so the
+	// curve keeps following recent behaviour.
+	abandonmentMinObservations = 20
+	abandonmentMaxObservations = 2000
+)
+
+// AbandonmentCurve is a life table of waiting customers for one queue: per
+// wait-time bucket, how many customers were still waiting during it
+// (Exposed) and how many of them left (Abandoned). Customers who reached a
+// counter part-way through a bucket count as half exposed to it.
+type AbandonmentCurve struct {
+	Observed  float64   `json:"observed"`
+	Exposed   []float64 `json:"exposed"`
+	Abandoned []float64 `json:"abandoned"`
+}
+
+func newAbandonmentCurve() *AbandonmentCurve {
+	return &AbandonmentCurve{
+		Exposed:   make([]float64, abandonmentBuckets),
+		Abandoned: make([]float64, abandonmentBuckets),
+	}
+}
+
+// observe records a customer who stopped waiting after wait, either by
+// leaving the queue or by reaching a counter
+func (c *AbandonmentCurve) observe(wait time.Duration, abandoned bool) {
+	last := abandonmentBucketOf(wait)
+	for b := 0; b < last; b++ {
+		c.Exposed[b]++
+	}
+	if abandoned {
+		c.Exposed[last]++
+		c.Abandoned[last]++
+	} else {
+		c.Exposed[last] += 0.5
+	}
+	c.Observed++
+
+	if c.Observed > abandonmentMaxObservations {
+		c.Observed /= 2
+		for b := range c.Exposed {
+			c.Exposed[b] /= 2
+			c.Abandoned[b] /= 2
+		}
+	}
+}
+
+// hazard returns the chance of leaving during bucket b for someone still
+// waiting at its start. Buckets nobody has waited into borrow the hazard of
+// the closest earlier bucket with data.
+func (c *AbandonmentCurve) hazard(b int) float64 {
+	for ; b >= 0; b-- {
+		if c.Exposed[b] > 0 {
+			return c.Abandoned[b] / c.Exposed[b]
+		}
+	}
+	return 0
+}
+
+// leaveProbability returns the chance that someone who has waited from
+// leaves before they have waited to
+func (c *AbandonmentCurve) leaveProbability(from, to time.Duration) float64 {
+	if c == nil || c.Observed < abandonmentMinObservations || to <= from {
+		return 0
+	}
+
+	survival := 1.0
+	for at := from; at < to; {
+		b := abandonmentBucketOf(at)
+		end := time.Duration(b+1) * abandonmentBucket
+		if b == abandonmentBuckets-1 {
+			end = to // open-ended: same hazard per bucket width from here on
+		}
+		if end > to {
+			end = to
+		}
+
+		fraction := float64(end-at) / float64(abandonmentBucket)
+		survival *= math.Pow(1-c.hazard(b), fraction)
+		at = end
+	}
+
+	return 1 - survival
+}
+
+// clone returns a deep copy
+func (c *AbandonmentCurve) clone() *AbandonmentCurve {
+	return &AbandonmentCurve{
+		Observed:  c.Observed,
+		Exposed:   append([]float64(nil), c.Exposed...),
+		Abandoned: append([]float64(nil), c.Abandoned...),
+	}
+}
+
+// valid reports whether a restored curve has the expected bucket layout
+func (c *AbandonmentCurve) valid() bool {
+	return c != nil && len(c.Exposed) == abandonmentBuckets && len(c.Abandoned) == abandonmentBuckets
+}
+
+// abandonmentBucketOf returns the bucket a wait falls into
+func abandonmentBucketOf(wait time.Duration) int {
+	if wait < 0 {
+		return 0
+	}
+	b := int(wait / abandonmentBucket)
+	if b >= abandonmentBuckets {
+		return abandonmentBuckets - 1
+	}
+	return b
+}
+
+// observeAbandonment learns from a customer who was waiting in the previous
+// snapshot: now marked left they abandoned, now at a counter (or already
+// served) they did not
+func (calc *QueueCalculator) observeAbandonment(queueID string, after QueueEntry, now time.Time) {
+	var end time.Time
+	var abandoned bool
+	switch {
+	case after.Left && after.Status == "waiting":
+		end, abandoned = now, true
+		if after.LeftAt != nil {
+			end = *after.LeftAt
+		}
+	case after.Status == "in_progress" || after.Status == "served":
+		end = now
+		if after.StartedAt != nil {
+			end = *after.StartedAt
+		}
+	default:
+		return // still waiting
+	}
+
+	curve, ok := calc.abandonment[queueID]
+	if !ok {
+		curve = newAbandonmentCurve()
+		calc.abandonment[queueID] = curve
+	}
+	curve.observe(end.Sub(after.JoinedAt), abandoned)
+}
+
+// AbandonmentCurves returns a copy of the learned curves by queue ID
+func (calc *QueueCalculator) AbandonmentCurves() map[string]*AbandonmentCurve {
+	curves := make(map[string]*AbandonmentCurve, len(calc.abandonment))
+	for queueID, curve := range calc.abandonment {
+		curves[queueID] = curve.clone()
+	}
+	return curves
+}
+
+// RestoreAbandonmentCurves replaces the learned curves, e.g. from persisted
+// state. Curves with an unexpected layout are dropped.
+func (calc *QueueCalculator) RestoreAbandonmentCurves(curves map[string]*AbandonmentCurve) {
+	calc.abandonment = make(map[string]*AbandonmentCurve, len(curves))
+	for queueID, curve := range curves {
+		if curve.valid() {
+			calc.abandonment[queueID] = curve.clone()
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// halfLeaveCurve is learned from 100 customers: half left 7 minutes in, the
+// other half were called 12 minutes in
+func halfLeaveCurve() *AbandonmentCurve {
+	curve := newAbandonmentCurve()
+	for i := 0; i < 50; i++ {
+		curve.observe(7*time.Minute, true)
+		curve.observe(12*time.Minute, false)
+	}
+	return curve
+}
+
+func TestAbandonmentCurveLeaveProbability(t *testing.T) {
+	curve := halfLeaveCurve()
+
+	tests := []struct {
+		name     string
+		from, to time.Duration
+		want     float64
+	}{
+		{"nobody leaves early on", 0, 5 * time.Minute, 0},
+		{"half leave in the second bucket", 5 * time.Minute, 10 * time.Minute, 0.5},
+		{"partial bucket", 5 * time.Minute, 7*time.Minute + 30*time.Second, 1 - math.Sqrt(0.5)},
+		{"nobody seen leaving later", 10 * time.Minute, 15 * time.Minute, 0},
+		{"beyond observed waits", 30 * time.Minute, 40 * time.Minute, 0},
+		{"empty span", 8 * time.Minute, 8 * time.Minute, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := curve.leaveProbability(tt.from, tt.to); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("leaveProbability(%v, %v) = %.4f, want %.4f", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+
+	sparse := newAbandonmentCurve()
+	for i := 0; i < abandonmentMinObservations-1; i++ {
+		sparse.observe(7*time.Minute, true)
+	}
+	if got := sparse.leaveProbability(5*time.Minute, 10*time.Minute); got != 0 {
+		t.Errorf("sparse curve predicts %.2f, want 0 until enough observations", got)
+	}
+
+	var missing *AbandonmentCurve
+	if got := missing.leaveProbability(0, time.Hour); got != 0 {
+		t.Errorf("queue without curve predicts %.2f, want 0", got)
+	}
+}
+
+func TestAbandonmentCurveDecays(t *testing.T) {
+	curve := newAbandonmentCurve()
+	for i := 0; i < abandonmentMaxObservations; i++ {
+		curve.observe(time.Minute, false)
+	}
+	curve.observe(time.Minute, false)
+
+	if curve.Observed > abandonmentMaxObservations {
+		t.Errorf("Observed = %v, want halved below %d", curve.Observed, abandonmentMaxObservations)
+	}
+}
+
+func TestObserveTransitions(t *testing.T) {
+	calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+
+	left := waitingEntry("LEFT", 20*time.Minute)
+	called := waitingEntry("CALLED", 9*time.Minute)
+	still := waitingEntry("STILL", 5*time.Minute)
+	gone := waitingEntry("GONE", 5*time.Minute)
+	previous := Queue{QueueID: "Q1", Entries: []QueueEntry{left, called, still, gone}}
+
+	left.Left = true
+	left.LeftAt = timePtr(testNow.Add(-3 * time.Minute)) // left after 17 minutes
+	called.Status = "in_progress"
+	called.StartedAt = timePtr(testNow.Add(-time.Minute)) // called after 8 minutes
+	current := Queue{QueueID: "Q1", Entries: []QueueEntry{left, called, still}}
+
+	calc.ObserveTransitions(previous, current)
+
+	curve := calc.AbandonmentCurves()["Q1"]
+	if curve == nil {
+		t.Fatal("no curve learned for Q1")
+	}
+	if curve.Observed != 2 {
+		t.Errorf("Observed = %v, want 2 finished waits", curve.Observed)
+	}
+	if curve.Abandoned[3] != 1 {
+		t.Errorf("Abandoned = %v, want one in the 15-20 minute bucket", curve.Abandoned)
+	}
+	if curve.Exposed[1] != 1.5 {
+		t.Errorf("Exposed[1] = %v, want 1.5 (one passing through, one called mid-bucket)", curve.Exposed[1])
+	}
+}
+
+func TestEstimateQueueWithAbandonment(t *testing.T) {
+	// One counter, 4 minute services, three waiting who joined 4 minutes ago
+	queue := Queue{QueueID: "Q1", Entries: []QueueEntry{
+		servedEntry("S1", 5*time.Minute, 4*time.Minute),
+		waitingEntry("W1", 4*time.Minute),
+		waitingEntry("W2", 4*time.Minute),
+		waitingEntry("W3", 4*time.Minute),
+	}}
+
+	calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+	calc.SetServerCount("Q1", 1)
+	before := calc.EstimateQueue(queue)
+
+	calc.RestoreAbandonmentCurves(map[string]*AbandonmentCurve{"Q1": halfLeaveCurve()})
+	after := calc.EstimateQueue(queue)
+
+	// W1 is served now, W2 after W1 (who might leave in the 5-10 minute
+	// bucket, but is served before), W3 after W2 who may give up while
+	// waiting between 4 and 8 minutes
+	if after.EffectivePositions["W1"] != 1 || after.EffectivePositions["W2"] != 2 {
+		t.Errorf("effective positions = %v, want W1 1 and W2 2", after.EffectivePositions)
+	}
+	if got := after.EffectivePositions["W3"]; got >= 3 || got <= 2 {
+		t.Errorf("W3 effective position = %.2f, want between 2 and 3", got)
+	}
+	if after.Positions["W3"] != 3 {
+		t.Errorf("W3 raw position = %d, want 3", after.Positions["W3"])
+	}
+	if after.Waits["W3"] >= before.Waits["W3"] {
+		t.Errorf("W3 wait %v not shorter than without abandonment %v", after.Waits["W3"], before.Waits["W3"])
+	}
+	if after.Stats.ExpectedAbandonments <= 0 || after.Stats.EffectiveLength >= 3 {
+		t.Errorf("stats = %+v, want some expected abandonment", after.Stats)
+	}
+	if r := after.Ranges["W3"]; r.P50 > before.Ranges["W3"].P50 {
+		t.Errorf("W3 range %v longer than without abandonment %v", r, before.Ranges["W3"])
+	}
+}
+
+func TestAbandonmentCurvesPersist(t *testing.T) {
+	store := NewStateStore(filepath.Join(t.TempDir(), "state.json"))
+	waiting := Queue{QueueID: "Q1", Name: "Clinic", Entries: []QueueEntry{waitingEntry("E1", 6*time.Minute)}}
+	left := waiting
+	left.Entries = []QueueEntry{waitingEntry("E1", 6*time.Minute)}
+	left.Entries[0].Left = true
+
+	django := newFakeDjango(t, waiting)
+	scheduler, clock := newTestScheduler(t, django, newFakeGraphAPI(t))
+	if err := scheduler.RestoreState(store); err != nil {
+		t.Fatalf("RestoreState: %v", err)
+	}
+	scheduler.processQueues()
+	django.setQueues(left)
+	clock.Advance(defaultPollInterval)
+	scheduler.processQueues()
+
+	restarted, _ := newTestScheduler(t, django, newFakeGraphAPI(t))
+	if err := restarted.RestoreState(store); err != nil {
+		t.Fatalf("RestoreState after restart: %v", err)
+	}
+	curve := restarted.calculator.AbandonmentCurves()["Q1"]
+	if curve == nil || curve.Abandoned[1] != 1 {
+		t.Errorf("restored curve = %+v, want the abandonment after 6 minutes", curve)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Prediction kinds scored by the AccuracyTracker
+const (
+	predictionJoin  = "join"  // first poll after the customer joined
+	predictionAlert = "alert" // each wait estimate sent to the customer
+)
+
+// AccuracyStats summarises how far predicted waits were from actual waits
+// (StartedAt - JoinedAt) for one queue, estimator and prediction kind
+type AccuracyStats struct {
+	QueueID   string
+	Estimator string
+	Kind      string
+	Count     int
+	MAE       time.Duration
+	Bias      time.Duration // mean of predicted - actual; positive overestimates
+}
+
+// accuracyKey identifies one series of predictions
+type accuracyKey struct {
+	queueID, estimator, kind string
+}
+
+// accuracyTotals accumulates errors in seconds
+type accuracyTotals struct {
+	count     int
+	absErrors float64
+	errors    float64
+}
+
+// AccuracyTotals are the accumulated errors of one series of predictions,
+// as persisted in the state file
+type AccuracyTotals struct {
+	QueueID   string  `json:"queue_id"`
+	Estimator string  `json:"estimator"`
+	Kind      string  `json:"kind"`
+	Count     int     `json:"count"`
+	AbsErrors float64 `json:"abs_error_seconds"`
+	Errors    float64 `json:"error_seconds"`
+}
+
+// alertPrediction is the start time implied by one alert's wait estimate
+type alertPrediction struct {
+	estimator string
+	start     time.Time
+}
+
+// pendingPrediction holds the predicted start times for a customer still
+// waiting
+type pendingPrediction struct {
+	atJoin   map[string]time.Time // by estimator
+	atAlerts []alertPrediction
+}
+
+// AccuracyTracker compares predicted waits with actual waits. It keeps the
+// start time each prediction implied for every waiting customer, and once
+// the customer reaches a counter scores each prediction against StartedAt.
+// Customers who leave or disappear are dropped unscored.
+//
+// Besides the calculator's own estimator, shadow estimators can be scored on
+// the same customers to compare them before switching.
+type AccuracyTracker struct {
+	calc    *QueueCalculator
+	shadows []ServiceTimeEstimator
+
+	mu      sync.Mutex
+	pending map[string]map[string]*pendingPrediction // by queue ID, entry key
+	totals  map[accuracyKey]*accuracyTotals
+}
+
+// NewAccuracyTracker creates a tracker for calc's predictions, also scoring
+// the given shadow estimators
+func NewAccuracyTracker(calc *QueueCalculator, shadows ...ServiceTimeEstimator) *AccuracyTracker {
+	return &AccuracyTracker{
+		calc:    calc,
+		shadows: shadows,
+		pending: make(map[string]map[string]*pendingPrediction),
+		totals:  make(map[accuracyKey]*accuracyTotals),
+	}
+}
+
+// Observe takes a queue snapshot with the calculator's estimate of it:
+// customers seen waiting for the first time get their predictions recorded,
+// and customers who have since been called are scored.
+//
+// A join prediction is only recorded for customers who joined within the
+// queue's poll interval. Those already waiting when the tracker first sees
+// them, after a restart or when a queue opens, get a mid-wait estimate that
+// would flatter the join score, so they are only scored on their alerts.
+func (t *AccuracyTracker) Observe(queue Queue, estimate QueueEstimate) {
+	now := t.calc.clock.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pending, ok := t.pending[queue.QueueID]
+	if !ok {
+		pending = make(map[string]*pendingPrediction)
+		t.pending[queue.QueueID] = pending
+	}
+
+	var shadowWaits []map[string]time.Duration
+	seen := make(map[string]bool, len(queue.Entries))
+
+	for _, entry := range queue.Entries {
+		key := entry.Key()
+		seen[key] = true
+		p, tracked := pending[key]
+
+		switch {
+		case entry.Left:
+			delete(pending, key)
+
+		case entry.Status == "waiting" && !tracked:
+			p = &pendingPrediction{}
+			if now.Sub(entry.JoinedAt) <= t.joinWindow(queue.QueueID) {
+				p.atJoin = map[string]time.Time{
+					t.calc.estimator.Name(): now.Add(estimate.Waits[key]),
+				}
+				if shadowWaits == nil {
+					shadowWaits = t.shadowWaits(queue)
+				}
+				for i, shadow := range t.shadows {
+					p.atJoin[shadow.Name()] = now.Add(shadowWaits[i][key])
+				}
+			}
+			pending[key] = p
+
+		case entry.Status != "waiting" && tracked:
+			if entry.StartedAt != nil {
+				t.score(queue.QueueID, p, *entry.StartedAt)
+			}
+			delete(pending, key)
+		}
+	}
+
+	for key := range pending {
+		if !seen[key] {
+			delete(pending, key)
+		}
+	}
+}
+
+// joinWindow is the longest a queue can go between polls, so the longest
+// after joining that a customer is first seen
+func (t *AccuracyTracker) joinWindow(queueID string) time.Duration {
+	window := t.calc.config.Queue(queueID).PollInterval
+	if t.calc.config.AdaptivePolling.Enabled() {
+		window = max(window, t.calc.config.AdaptivePolling.MaxInterval)
+	}
+	return window
+}
+
+// shadowWaits estimates the queue once per shadow estimator
+func (t *AccuracyTracker) shadowWaits(queue Queue) []map[string]time.Duration {
+	waits := make([]map[string]time.Duration, len(t.shadows))
+	for i, shadow := range t.shadows {
+		waits[i] = t.calc.estimateQueue(queue, shadow).Waits
+	}
+	return waits
+}
+
+// RecordAlert notes the wait estimate a waiting customer was just sent
+func (t *AccuracyTracker) RecordAlert(queueID, entryKey string, wait time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.pending[queueID][entryKey]
+	if !ok {
+		return
+	}
+	p.atAlerts = append(p.atAlerts, alertPrediction{
+		estimator: t.calc.estimator.Name(),
+		start:     t.calc.clock.Now().Add(wait),
+	})
+}
+
+// score adds the errors of a customer's predictions given their actual
+// start. Every prediction implies a start time, so predicted - actual start
+// equals predicted - actual wait.
+func (t *AccuracyTracker) score(queueID string, p *pendingPrediction, started time.Time) {
+	for estimator, start := range p.atJoin {
+		t.add(accuracyKey{queueID, estimator, predictionJoin}, start.Sub(started))
+	}
+	for _, alert := range p.atAlerts {
+		t.add(accuracyKey{queueID, alert.estimator, predictionAlert}, alert.start.Sub(started))
+	}
+}
+
+func (t *AccuracyTracker) add(key accuracyKey, err time.Duration) {
+	totals, ok := t.totals[key]
+	if !ok {
+		totals = &accuracyTotals{}
+		t.totals[key] = totals
+	}
+	totals.count++
+	totals.absErrors += math.Abs(err.Seconds())
+	totals.errors += err.Seconds()
+}
+
+// Totals returns the accumulated errors for persistence
+func (t *AccuracyTracker) Totals() []AccuracyTotals {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	totals := make([]AccuracyTotals, 0, len(t.totals))
+	for key, series := range t.totals {
+		totals = append(totals, AccuracyTotals{
+			QueueID:   key.queueID,
+			Estimator: key.estimator,
+			Kind:      key.kind,
+			Count:     series.count,
+			AbsErrors: series.absErrors,
+			Errors:    series.errors,
+		})
+	}
+	return totals
+}
+
+// RestoreTotals replaces the accumulated errors with persisted ones
+func (t *AccuracyTracker) RestoreTotals(totals []AccuracyTotals) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.totals = make(map[accuracyKey]*accuracyTotals, len(totals))
+	for _, series := range totals {
+		t.totals[accuracyKey{series.QueueID, series.Estimator, series.Kind}] = &accuracyTotals{
+			count:     series.Count,
+			absErrors: series.AbsErrors,
+			errors:    series.Errors,
+		}
+	}
+}
+
+// Report returns the accuracy of every queue, estimator and prediction kind
+// scored so far, ordered by queue, estimator and kind
+func (t *AccuracyTracker) Report() []AccuracyStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := make([]AccuracyStats, 0, len(t.totals))
+	for key, totals := range t.totals {
+		n := float64(totals.count)
+		report = append(report, AccuracyStats{
+			QueueID:   key.queueID,
+			Estimator: key.estimator,
+			Kind:      key.kind,
+			Count:     totals.count,
+			MAE:       time.Duration(totals.absErrors / n * float64(time.Second)),
+			Bias:      time.Duration(totals.errors / n * float64(time.Second)),
+		})
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		if a.QueueID != b.QueueID {
+			return a.QueueID < b.QueueID
+		}
+		if a.Estimator != b.Estimator {
+			return a.Estimator < b.Estimator
+		}
+		return a.Kind < b.Kind
+	})
+	return report
+}
+
+// WriteMetrics writes the report in the Prometheus text format
+func (t *AccuracyTracker) WriteMetrics(w io.Writer) {
+	report := t.Report()
+
+	metrics := []struct {
+		name, help, kind string
+		value            func(AccuracyStats) float64
+	}{
+		{"queue_wait_predictions_scored_total", "Wait predictions compared with the actual wait.", "counter",
+			func(s AccuracyStats) float64 { return float64(s.Count) }},
+		{"queue_wait_prediction_mae_seconds", "Mean absolute error of predicted waits.", "gauge",
+			func(s AccuracyStats) float64 { return s.MAE.Seconds() }},
+		{"queue_wait_prediction_bias_seconds", "Mean of predicted minus actual wait; positive means overestimating.", "gauge",
+			func(s AccuracyStats) float64 { return s.Bias.Seconds() }},
+	}
+
+	for _, metric := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+		for _, s := range report {
+			fmt.Fprintf(w, "%s{queue=%q,estimator=%q,kind=%q} %g\n",
+				metric.name, s.QueueID, s.Estimator, s.Kind, metric.value(s))
+		}
+	}
+}
+
+// WriteReport prints the report as a table
+func (t *AccuracyTracker) WriteReport(w io.Writer) {
+	out := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(out, "QUEUE\tESTIMATOR\tPREDICTION\tCOUNT\tMAE\tBIAS")
+	for _, s := range t.Report() {
+		sign := "+"
+		if s.Bias < 0 {
+			sign = "" // the duration carries its own minus
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\t%d\t%v\t%s%v\n", s.QueueID, s.Estimator, s.Kind, s.Count,
+			s.MAE.Round(time.Second), sign, s.Bias.Round(time.Second))
+	}
+	out.Flush()
+}
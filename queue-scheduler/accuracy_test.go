@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccuracyTracker(t *testing.T) {
+	clock := NewVirtualClock(testNow)
+	calc := NewQueueCalculator(clock, DefaultConfig())
+	calc.SetServerCount("Q1", 1)
+	tracker := NewAccuracyTracker(calc, MedianEstimator{})
+
+	// Services of 2, 2 and 8 minutes: mean 4m, median 2m. The counter is
+	// free, so W1 is predicted to start now and W2 after one service.
+	history := []QueueEntry{
+		servedEntry("S1", 20*time.Minute, 2*time.Minute),
+		servedEntry("S2", 15*time.Minute, 2*time.Minute),
+		servedEntry("S3", 5*time.Minute, 8*time.Minute),
+	}
+	snapshot := func(entries ...QueueEntry) Queue {
+		return Queue{QueueID: "Q1", Entries: append(append([]QueueEntry(nil), history...), entries...)}
+	}
+	observe := func(queue Queue) {
+		tracker.Observe(queue, calc.EstimateQueue(queue))
+	}
+
+	w1, w2, w3 := waitingEntry("W1", 0), waitingEntry("W2", 0), waitingEntry("W3", 0)
+	observe(snapshot(w1, w2, w3))
+	tracker.RecordAlert("Q1", "W2", 4*time.Minute)
+	tracker.RecordAlert("Q1", "NOBODY", time.Minute) // not waiting: ignored
+
+	// Three minutes later W1 finished and W2 has been called; W3 gave up
+	clock.Advance(3 * time.Minute)
+	w1.Status, w1.StartedAt, w1.ServedAt = "served", timePtr(testNow), timePtr(testNow.Add(3*time.Minute))
+	w2.Status, w2.StartedAt = "in_progress", timePtr(testNow.Add(3*time.Minute))
+	w3.Left = true
+	observe(snapshot(w1, w2, w3))
+
+	got := make(map[string]AccuracyStats)
+	for _, stats := range tracker.Report() {
+		got[stats.Estimator+"/"+stats.Kind] = stats
+	}
+
+	want := map[string]AccuracyStats{
+		// W1 predicted 0 (off by 0), W2 predicted 4m (1m over)
+		"mean/join": {Count: 2, MAE: 30 * time.Second, Bias: 30 * time.Second},
+		// W2 predicted 2m (1m under)
+		"median/join": {Count: 2, MAE: 30 * time.Second, Bias: -30 * time.Second},
+		"mean/alert":  {Count: 1, MAE: time.Minute, Bias: time.Minute},
+	}
+	if len(got) != len(want) {
+		t.Errorf("report = %+v, want %d series", got, len(want))
+	}
+	for key, w := range want {
+		g := got[key]
+		if g.QueueID != "Q1" || g.Count != w.Count || g.MAE != w.MAE || g.Bias != w.Bias {
+			t.Errorf("%s = %+v, want count %d, MAE %v, bias %v", key, g, w.Count, w.MAE, w.Bias)
+		}
+	}
+
+	if pending := len(tracker.pending["Q1"]); pending != 0 {
+		t.Errorf("%d customers still pending, want none", pending)
+	}
+}
+
+func TestAccuracyTrackerDropsVanishedEntries(t *testing.T) {
+	calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+	tracker := NewAccuracyTracker(calc)
+
+	queue := Queue{QueueID: "Q1", Entries: []QueueEntry{waitingEntry("W1", 0)}}
+	tracker.Observe(queue, calc.EstimateQueue(queue))
+	tracker.Observe(Queue{QueueID: "Q1"}, QueueEstimate{})
+
+	if pending := len(tracker.pending["Q1"]); pending != 0 {
+		t.Errorf("%d customers still pending, want none", pending)
+	}
+	if report := tracker.Report(); len(report) != 0 {
+		t.Errorf("report = %+v, want nothing scored", report)
+	}
+}
+
+func TestAccuracyTrackerJoinPredictionsOnlyAtJoin(t *testing.T) {
+	clock := NewVirtualClock(testNow)
+	calc := NewQueueCalculator(clock, DefaultConfig())
+	tracker := NewAccuracyTracker(calc)
+
+	// After a restart W1 has been waiting 20 minutes, W2 just joined
+	w1, w2 := waitingEntry("W1", 20*time.Minute), waitingEntry("W2", 30*time.Second)
+	queue := Queue{QueueID: "Q1", Entries: []QueueEntry{w1, w2}}
+	tracker.Observe(queue, calc.EstimateQueue(queue))
+	tracker.RecordAlert("Q1", "W1", time.Minute)
+
+	clock.Advance(time.Minute)
+	w1.Status, w1.StartedAt = "in_progress", timePtr(clock.Now())
+	w2.Status, w2.StartedAt = "in_progress", timePtr(clock.Now())
+	queue = Queue{QueueID: "Q1", Entries: []QueueEntry{w1, w2}}
+	tracker.Observe(queue, calc.EstimateQueue(queue))
+
+	counts := make(map[string]int)
+	for _, stats := range tracker.Report() {
+		counts[stats.Kind] += stats.Count
+	}
+	if counts[predictionJoin] != 1 || counts[predictionAlert] != 1 {
+		t.Errorf("scored %v, want one join prediction (W2) and W1's alert", counts)
+	}
+
+	// The totals survive a restart through the state file
+	store := NewStateStore(filepath.Join(t.TempDir(), "state.json"))
+	if err := store.Save(StateSnapshot{SavedAt: clock.Now(), Accuracy: tracker.Totals()}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	snapshot, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	restarted := NewAccuracyTracker(calc)
+	restarted.RestoreTotals(snapshot.Accuracy)
+	if got, want := restarted.Report(), tracker.Report(); !reflect.DeepEqual(got, want) {
+		t.Errorf("restored report = %+v, want %+v", got, want)
+	}
+}
+
+func TestAccuracyMetricsEndpoint(t *testing.T) {
+	waiting := Queue{QueueID: "Q1", Name: "Clinic", Entries: []QueueEntry{waitingEntry("W1", time.Minute)}}
+	django := newFakeDjango(t, waiting)
+	graph := newFakeGraphAPI(t)
+	scheduler, clock := newTestScheduler(t, django, graph)
+	scheduler.accuracy = NewAccuracyTracker(scheduler.calculator)
+
+	scheduler.processQueues()
+
+	called := waitingEntry("W1", time.Minute)
+	called.Status, called.StartedAt = "in_progress", timePtr(testNow.Add(2*time.Minute))
+	django.setQueues(Queue{QueueID: "Q1", Name: "Clinic", Entries: []QueueEntry{called}})
+	clock.Advance(2 * time.Minute)
+	scheduler.processQueues()
+
+	handler := NewStatusServer("", scheduler).server.Handler
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := recorder.Body.String()
+	for _, want := range []string{
+		"# TYPE queue_wait_predictions_scored_total counter",
+		`queue_wait_predictions_scored_total{queue="Q1",estimator="mean",kind="join"} 1`,
+		`queue_wait_predictions_scored_total{queue="Q1",estimator="mean",kind="alert"} 1`,
+		`queue_wait_prediction_bias_seconds{queue="Q1",estimator="mean",kind="join"} -120`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/accuracy", nil))
+	if !strings.Contains(recorder.Body.String(), `"mae_seconds":120`) {
+		t.Errorf("accuracy report = %s, want a 2 minute error", recorder.Body.String())
+	}
+}
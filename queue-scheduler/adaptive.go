@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"time"
+)
+
+// adaptPollInterval works out how long to wait before polling a queue again
+// from its current interval and what this poll saw:
+//
+//   - the shortest interval while a customer at position 1-2 may be called
+//     before the next regular poll, so "you're next" and "being served" go
+//     out promptly
+//   - half the current interval while customers join, get called or leave
+//   - double, up to the longest interval, while nobody is in the queue
+//   - otherwise back to the queue's configured interval
+//
+// The result is kept within the adaptive polling bounds.
+func (s *Scheduler) adaptPollInterval(queue Queue, estimate QueueEstimate) (time.Duration, string) {
+	bounds := s.config.AdaptivePolling
+	base := bounds.clamp(s.config.Queue(queue.QueueID).PollInterval)
+	current := s.pollInterval(queue.QueueID)
+
+	var changes int
+	if previous, ok := s.previousQueues[queue.QueueID]; ok {
+		changes = queueActivity(previous, queue)
+	}
+
+	switch {
+	case frontDueWithin(estimate, base):
+		return bounds.MinInterval, "customer at position 1-2 about to be called"
+	case changes > 0:
+		return bounds.clamp(current / 2), fmt.Sprintf("%d changes since the last poll", changes)
+	case estimate.Stats.ActiveEntries == 0 && estimate.Stats.ReservedSlots == 0:
+		return bounds.clamp(current * 2), "queue empty"
+	default:
+		return base, "queue quiet"
+	}
+}
+
+// clamp keeps an interval within the bounds
+func (a AdaptivePolling) clamp(interval time.Duration) time.Duration {
+	return min(max(interval, a.MinInterval), a.MaxInterval)
+}
+
+// frontDueWithin reports whether a waiting customer at position 1 or 2 is
+// expected to be called within d
+func frontDueWithin(estimate QueueEstimate, d time.Duration) bool {
+	for key, position := range estimate.Positions {
+		if position <= 2 && estimate.Waits[key] < d {
+			return true
+		}
+	}
+	return false
+}
+
+// queueActivity counts the customers who joined, changed status, left or
+// disappeared between two snapshots of a queue
+func queueActivity(previous, current Queue) int {
+	before := make(map[string]QueueEntry, len(previous.Entries))
+	for _, entry := range previous.Entries {
+		before[entry.Key()] = entry
+	}
+
+	changes := 0
+	for _, entry := range current.Entries {
+		old, ok := before[entry.Key()]
+		if !ok || old.Status != entry.Status || old.Left != entry.Left {
+			changes++
+		}
+		delete(before, entry.Key())
+	}
+	return changes + len(before)
+}
+
+// pollInterval returns how often a queue is polled: its adapted interval
+// while adaptive polling is on, otherwise the configured one
+func (s *Scheduler) pollInterval(queueID string) time.Duration {
+	bounds := s.config.AdaptivePolling
+	if !bounds.Enabled() {
+		return s.config.Queue(queueID).PollInterval
+	}
+
+	s.mu.RLock()
+	interval, ok := s.pollIntervals[queueID]
+	s.mu.RUnlock()
+	if !ok {
+		interval = s.config.Queue(queueID).PollInterval
+	}
+	return bounds.clamp(interval)
+}
+
+// updatePollInterval adapts a queue's poll interval after processing it,
+// logging changes
+func (s *Scheduler) updatePollInterval(queue Queue, estimate QueueEstimate) {
+	interval, reason := s.adaptPollInterval(queue, estimate)
+	if interval != s.pollInterval(queue.QueueID) {
+		log.Printf("⏱️  Queue %s: polling every %v (%s)", queue.QueueID, interval, reason)
+	}
+
+	s.mu.Lock()
+	s.pollIntervals[queue.QueueID] = interval
+	s.mu.Unlock()
+}
+
+// WritePollMetrics writes each queue's poll interval in the Prometheus text
+// format
+func (s *Scheduler) WritePollMetrics(w io.Writer) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	queueIDs := make([]string, 0, len(s.pollIntervals))
+	for queueID := range s.pollIntervals {
+		queueIDs = append(queueIDs, queueID)
+	}
+	sort.Strings(queueIDs)
+
+	fmt.Fprintln(w, "# HELP queue_poll_interval_seconds Current adaptive poll interval of the queue.")
+	fmt.Fprintln(w, "# TYPE queue_poll_interval_seconds gauge")
+	for _, queueID := range queueIDs {
+		fmt.Fprintf(w, "queue_poll_interval_seconds{queue=%q} %g\n", queueID, s.pollIntervals[queueID].Seconds())
+	}
+}
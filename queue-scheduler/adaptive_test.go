@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueueActivity(t *testing.T) {
+	previous := Queue{Entries: []QueueEntry{
+		waitingEntry("STAY", 5*time.Minute),
+		waitingEntry("CALLED", 4*time.Minute),
+		waitingEntry("LEAVES", 3*time.Minute),
+		waitingEntry("GONE", 2*time.Minute),
+	}}
+	current := Queue{Entries: []QueueEntry{
+		waitingEntry("STAY", 5*time.Minute),
+		inProgressEntry("CALLED", 0),
+		waitingEntry("LEAVES", 3*time.Minute),
+		waitingEntry("NEW", 0),
+	}}
+	current.Entries[2].Left = true
+
+	if got := queueActivity(previous, current); got != 4 {
+		t.Errorf("queueActivity = %d, want 4 (called, left, gone, joined)", got)
+	}
+	if got := queueActivity(current, current); got != 0 {
+		t.Errorf("queueActivity of an unchanged queue = %d, want 0", got)
+	}
+}
+
+func TestAdaptivePolling(t *testing.T) {
+	busy := sampleQueue()
+	busy.QueueID = "BUSY"
+	empty := Queue{QueueID: "EMPTY", Entries: []QueueEntry{servedEntry("DONE", 10*time.Minute, 4*time.Minute)}}
+	quiet := Queue{QueueID: "QUIET", Entries: []QueueEntry{inProgressEntry("AT", time.Minute)}}
+
+	config := DefaultConfig()
+	config.AdaptivePolling = AdaptivePolling{MinInterval: 10 * time.Second, MaxInterval: 3 * time.Minute}
+	django := newFakeDjango(t, busy, empty, quiet)
+	scheduler, clock := newConfiguredTestScheduler(t, django, newFakeGraphAPI(t), config)
+
+	assertIntervals := func(when string, want map[string]time.Duration) {
+		t.Helper()
+		for queueID, interval := range want {
+			if got := scheduler.pollInterval(queueID); got != interval {
+				t.Errorf("%s: %s poll interval = %v, want %v", when, queueID, got, interval)
+			}
+		}
+	}
+
+	// WAIT1 is about to be called; nobody is in EMPTY; QUIET has someone at
+	// the counter and nothing else going on
+	scheduler.processQueues()
+	assertIntervals("first poll", map[string]time.Duration{"BUSY": 10 * time.Second, "EMPTY": 2 * time.Minute, "QUIET": time.Minute})
+
+	clock.Advance(10 * time.Second)
+	scheduler.processQueues()
+	for queueID, want := range map[string]time.Time{"BUSY": clock.Now(), "EMPTY": testNow, "QUIET": testNow} {
+		if got := scheduler.lastPolled[queueID]; !got.Equal(want) {
+			t.Errorf("%s last polled at %v, want %v", queueID, got.Sub(testNow), want.Sub(testNow))
+		}
+	}
+
+	// Someone joins QUIET behind a long service: busier, but not urgent
+	quiet.Entries = append(quiet.Entries, waitingEntry("JOINED", 0))
+	django.setQueues(busy, empty, quiet)
+	clock.Set(testNow.Add(time.Minute))
+	scheduler.processQueues()
+	assertIntervals("after a join", map[string]time.Duration{"QUIET": 30 * time.Second})
+
+	// EMPTY backs off no further than the maximum
+	clock.Set(testNow.Add(2 * time.Minute))
+	scheduler.processQueues()
+	assertIntervals("EMPTY second poll", map[string]time.Duration{"EMPTY": 3 * time.Minute})
+
+	// Nothing changes in QUIET, so it goes back to its configured interval
+	clock.Set(testNow.Add(150 * time.Second))
+	scheduler.processQueues()
+	assertIntervals("quiet again", map[string]time.Duration{"QUIET": time.Minute})
+
+	recorder := httptest.NewRecorder()
+	NewStatusServer("", scheduler).server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`queue_poll_interval_seconds{queue="BUSY"} 10`,
+		`queue_poll_interval_seconds{queue="EMPTY"} 180`,
+		`queue_poll_interval_seconds{queue="QUIET"} 60`,
+	} {
+		if !strings.Contains(recorder.Body.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, recorder.Body.String())
+		}
+	}
+}
+
+func TestAdaptivePollingFetchesOnlyWhenDue(t *testing.T) {
+	empty := Queue{QueueID: "EMPTY"}
+	quiet := Queue{QueueID: "QUIET", Entries: []QueueEntry{inProgressEntry("AT", time.Minute)}}
+
+	config := DefaultConfig()
+	config.AdaptivePolling = AdaptivePolling{MinInterval: 10 * time.Second, MaxInterval: 3 * time.Minute}
+	django := newFakeDjango(t, empty, quiet)
+	scheduler, clock := newConfiguredTestScheduler(t, django, newFakeGraphAPI(t), config)
+
+	// QUIET is due every minute and EMPTY every two, so two minutes of
+	// ticks fetch at 0, 1m and 2m only
+	scheduler.processQueues()
+	for i := 0; i < 12; i++ {
+		clock.Advance(10 * time.Second)
+		scheduler.processQueues()
+	}
+	if got := django.fetches(); got != 3 {
+		t.Errorf("fetched %d times in 13 ticks, want 3", got)
+	}
+
+	// Change notifications still fetch straight away
+	scheduler.pollQueues(true)
+	if got := django.fetches(); got != 4 {
+		t.Errorf("fetched %d times after a change notification, want 4", got)
+	}
+}
+
+func TestAdaptivePollingConfig(t *testing.T) {
+	config := DefaultConfig()
+	if config.AdaptivePolling.Enabled() || config.MinPollInterval() != time.Minute {
+		t.Errorf("adaptive polling on by default: %+v", config.AdaptivePolling)
+	}
+
+	config.AdaptivePolling = AdaptivePolling{MinInterval: 15 * time.Second, MaxInterval: 10 * time.Minute}
+	if got := config.MinPollInterval(); got != 15*time.Second {
+		t.Errorf("MinPollInterval = %v, want the adaptive minimum", got)
+	}
+	if got := config.MaxPollInterval(); got != 10*time.Minute {
+		t.Errorf("MaxPollInterval = %v, want the adaptive maximum", got)
+	}
+
+	config.AdaptivePolling = AdaptivePolling{MinInterval: time.Minute, MaxInterval: 30 * time.Second}
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "max_interval 30s is below min_interval 1m0s") {
+		t.Errorf("Validate = %v, want the inverted bounds reported", err)
+	}
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -24,66 +26,158 @@ type WhatsAppTextBody struct {
 	Body       string `json:"body"`
 }
 
+// defaultWhatsAppURL is the Graph API messages endpoint for our business number
+const defaultWhatsAppURL = "https://graph.facebook.com/v23.0/228390687031431/messages"
+
+// WhatsAppConfig holds the WhatsApp Business API endpoint and credentials
+type WhatsAppConfig struct {
+	APIURL      string
+	AccessToken string
+}
+
 // AlertSystem handles sending notifications through various channels
 type AlertSystem struct {
-	// Rate limiting to prevent spam
-	sentAlerts map[string]time.Time
+	// Rate limiting to prevent spam: by the optional shared limiter, or
+	// the in-memory one, which also stands in while the shared one fails
+	limiter RateLimiter
+	local   *MemoryRateLimiter
 	
-	// Configuration
-	rateLimitWindow time.Duration
+	// Configuration: the rate limit window, per queue, and the daily cap
+	config Config
+
+	// dryRun logs alerts instead of delivering them (used by replays)
+	dryRun bool
+
+	// recorder, when set, captures every alert and its outcome
+	recorder *Recorder
+
+	whatsapp   WhatsAppConfig
+	httpClient *http.Client
+	clock      Clock
 }
 
-// NewAlertSystem creates a new alert system
-func NewAlertSystem() *AlertSystem {
+// NewAlertSystem creates a new alert system rate limiting by config's windows
+func NewAlertSystem(clock Clock, whatsapp WhatsAppConfig, config Config) *AlertSystem {
+	if whatsapp.APIURL == "" {
+		whatsapp.APIURL = defaultWhatsAppURL
+	}
+
 	return &AlertSystem{
-		sentAlerts:      make(map[string]time.Time),
-		rateLimitWindow: 5 * time.Minute, // Allow position updates every 5 minutes
+		local:      NewMemoryRateLimiter(),
+		config:     config,
+		whatsapp:   whatsapp,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		clock:      clock,
 	}
 }
 
-// SendAlert processes and sends an alert through appropriate channel
-func (a *AlertSystem) SendAlert(alert AlertRequest) {
-	// Create unique key for rate limiting
-	alertKey := fmt.Sprintf("%s:%s:%s", alert.MSISDN, alert.QueueID, alert.Channel)
-	
-	// Check rate limiting
-	if lastSent, exists := a.sentAlerts[alertKey]; exists {
-		if time.Since(lastSent) < a.rateLimitWindow {
-			log.Printf("Rate limited: skipping alert for %s", alert.MSISDN)
-			return
-		}
+// SendAlert processes and sends an alert through appropriate channel and
+// returns the outcome (one of the alertOutcome constants)
+func (a *AlertSystem) SendAlert(alert AlertRequest) string {
+	// An alert that can't be routed mustn't use up the recipient's limits
+	if !a.dryRun && !alertChannels[alert.Channel] {
+		log.Printf("Unknown alert channel: %s", alert.Channel)
+		a.record(alert, alertOutcomeUnknownChannel)
+		return alertOutcomeUnknownChannel
+	}
+
+	// Check rate limiting, counting the alert if it may go out
+	if !a.allow(alert) {
+		log.Printf("Rate limited: skipping alert for %s", alert.MSISDN)
+		a.record(alert, alertOutcomeRateLimited)
+		return alertOutcomeRateLimited
 	}
 
 	// Route to appropriate channel
-	switch alert.Channel {
-	case "whatsapp":
-		a.sendWhatsApp(alert)
-	case "ussd":
+	outcome := alertOutcomeSent
+	switch {
+	case a.dryRun:
+		log.Printf("🧪 [dry-run] %s alert to %s (%s): %s", alert.Channel, alert.MSISDN, alert.EntryID, alert.Message)
+		outcome = alertOutcomeDryRun
+	case alert.Channel == "whatsapp":
+		if !a.sendWhatsApp(alert) {
+			outcome = alertOutcomeFailed
+		}
+	case alert.Channel == "ussd":
 		a.sendUSSD(alert)
-	case "websocket":
+	case alert.Channel == "websocket":
 		a.sendWebSocket(alert)
-	default:
-		log.Printf("Unknown alert channel: %s", alert.Channel)
-		return
 	}
+	a.record(alert, outcome)
 
-	// Record sent alert for rate limiting
-	a.sentAlerts[alertKey] = alert.Timestamp
-	
-	// Cleanup old rate limit entries (prevent memory leak)
-	a.cleanupOldAlerts()
+	return outcome
+}
+
+// alertChannels are the channels SendAlert routes alerts to
+var alertChannels = map[string]bool{
+	"whatsapp":  true,
+	"ussd":      true,
+	"websocket": true,
+}
+
+// allow checks an alert against its rate limits and counts it if it may go
+// out. Sends the shared limiter allows are mirrored in memory, so if it fails
+// the in-memory one decides instead with everything this process sent.
+func (a *AlertSystem) allow(alert AlertRequest) bool {
+	limits := a.rateLimits(alert)
+	now := a.clock.Now()
+
+	if a.limiter != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), rateLimitTimeout)
+		defer cancel()
+		allowed, err := a.limiter.Allow(ctx, now, limits...)
+		if err == nil {
+			if allowed {
+				a.local.Record(now, limits...)
+			}
+			return allowed
+		}
+		log.Printf("⚠️  Rate limiter %s failed, limiting in memory: %v", a.limiter.Name(), err)
+	}
+
+	allowed, _ := a.local.Allow(context.Background(), now, limits...)
+	return allowed
+}
+
+// rateLimits are the limits an alert is checked against: one message per
+// window to each entry in a queue on a channel, and the recipient's daily
+// cap, if set. A serving notice goes out once per status change, however
+// recent the last update was, so the window doesn't apply to it. Urgent
+// messages are never retried once dropped, so they neither count against
+// nor are held by the daily cap.
+func (a *AlertSystem) rateLimits(alert AlertRequest) []RateLimit {
+	var limits []RateLimit
+	if window := a.config.Queue(alert.QueueID).RateLimitWindow; window > 0 && alert.Kind != messageServing {
+		limits = append(limits, RateLimit{Key: rateLimitKey(alert), Window: window, Max: 1})
+	}
+	if a.config.DailyMessageCap > 0 && alert.MSISDN != "" && !urgentMessages[alert.Kind] {
+		limits = append(limits, RateLimit{Key: dailyCapKey(alert.MSISDN), Window: dailyCapWindow, Max: a.config.DailyMessageCap})
+	}
+	return limits
 }
 
-// sendWhatsApp sends alert via WhatsApp Business API
-func (a *AlertSystem) sendWhatsApp(alert AlertRequest) {
+// record passes the alert to the recorder, if one is attached
+func (a *AlertSystem) record(alert AlertRequest, outcome string) {
+	if a.recorder != nil {
+		a.recorder.RecordAlert(alert, outcome)
+	}
+}
+
+// rateLimitKey identifies an alert stream per queue entry and channel. Alerts
+// without an entry (e.g. manual test messages) fall back to the MSISDN.
+func rateLimitKey(alert AlertRequest) string {
+	recipient := alert.EntryID
+	if recipient == "" {
+		recipient = "msisdn:" + alert.MSISDN
+	}
+	return fmt.Sprintf("%s:%s:%s", recipient, alert.QueueID, alert.Channel)
+}
+
+// sendWhatsApp sends alert via WhatsApp Business API, reporting whether the
+// API accepted the message
+func (a *AlertSystem) sendWhatsApp(alert AlertRequest) bool {
 	log.Printf("📱 WhatsApp Alert to %s: %s", alert.MSISDN, alert.Message)
 	
-	// WhatsApp Business API configuration
-	const (
-		whatsappURL = "https://graph.facebook.com/v23.0/228390687031431/messages"
-		accessToken = "EAALG7bsFLaEBO6jdfDo26teUHL7t2UzZChoglQOXlATZAEbJIFRmr9Xh7nRdKVkDZAwrKVZAvw0Pi2lnAeTJDwmZBxjALGU030PewqDwMkiwWL9rb9KzTPv7s3jTsQtUwLZBG4QGKmdMKvTgZBfTkAZBZCNfuvvjp00D8ZAezgYEBRB5VZCam2ZCuZC0A7pTqcnGIudhD9gZDZD"
-	)
-	
 	// Create WhatsApp message payload
 	message := WhatsAppMessage{
 		MessagingProduct: "whatsapp",
@@ -100,26 +194,25 @@ func (a *AlertSystem) sendWhatsApp(alert AlertRequest) {
 	jsonData, err := json.Marshal(message)
 	if err != nil {
 		log.Printf("Error marshaling WhatsApp message: %v", err)
-		return
+		return false
 	}
 	
 	// Create HTTP request
-	req, err := http.NewRequest("POST", whatsappURL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", a.whatsAppURL(), bytes.NewBuffer(jsonData))
 	if err != nil {
 		log.Printf("Error creating WhatsApp request: %v", err)
-		return
+		return false
 	}
 	
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Authorization", "Bearer "+a.whatsAppToken())
 	
 	// Send request
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := a.httpClient.Do(req)
 	if err != nil {
 		log.Printf("Error sending WhatsApp message: %v", err)
-		return
+		return false
 	}
 	defer resp.Body.Close()
 	
@@ -127,16 +220,18 @@ func (a *AlertSystem) sendWhatsApp(alert AlertRequest) {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("Error reading WhatsApp response: %v", err)
-		return
+		return false
 	}
 	
 	// Check response status
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 		log.Printf("✅ WhatsApp message sent successfully to %s", alert.MSISDN)
 		fmt.Printf("WHATSAPP SUCCESS: %s -> %s\n", alert.MSISDN, alert.Message)
+		return true
 	} else {
 		log.Printf("❌ WhatsApp API error (status %d): %s", resp.StatusCode, string(body))
 		fmt.Printf("WHATSAPP ERROR: %s -> %s (Status: %d)\n", alert.MSISDN, alert.Message, resp.StatusCode)
+		return false
 	}
 }
 
@@ -158,28 +253,79 @@ func (a *AlertSystem) sendWebSocket(alert AlertRequest) {
 	fmt.Printf("WEBSOCKET: %s -> %s\n", alert.MSISDN, alert.Message)
 }
 
-// cleanupOldAlerts removes rate limit entries older than the window
-func (a *AlertSystem) cleanupOldAlerts() {
-	cutoff := time.Now().Add(-a.rateLimitWindow)
-	
-	for key, timestamp := range a.sentAlerts {
-		if timestamp.Before(cutoff) {
-			delete(a.sentAlerts, key)
-		}
+// whatsAppURL returns the messages endpoint: the configured override, or the
+// one the alert system was created with
+func (a *AlertSystem) whatsAppURL() string {
+	if a.config.WhatsAppAPIURL != "" {
+		return a.config.WhatsAppAPIURL
 	}
+	return a.whatsapp.APIURL
+}
+
+// whatsAppToken returns the access token: the configured one, which a
+// reload can rotate, or the one the alert system was created with
+func (a *AlertSystem) whatsAppToken() string {
+	if a.config.WhatsAppAccessToken != "" {
+		return a.config.WhatsAppAccessToken
+	}
+	return a.whatsapp.AccessToken
 }
 
 // GetAlertStats returns statistics about sent alerts
 func (a *AlertSystem) GetAlertStats() map[string]int {
 	stats := make(map[string]int)
 	
-	cutoff := time.Now().Add(-24 * time.Hour) // Last 24 hours
+	cutoff := a.clock.Now().Add(-24 * time.Hour) // Last 24 hours
 	
-	for _, timestamp := range a.sentAlerts {
+	for _, timestamp := range a.SentAlerts() {
 		if timestamp.After(cutoff) {
 			stats["alerts_24h"]++
 		}
 	}
 	
 	return stats
-}
\ No newline at end of file
+}
+
+// SentAlerts returns when each entry was last messaged, from the in-memory
+// rate limit records, for persistence. They mirror what a shared limiter
+// allowed too.
+func (a *AlertSystem) SentAlerts() map[string]time.Time {
+	sent := make(map[string]time.Time)
+	for key, times := range a.local.Records() {
+		if !strings.HasPrefix(key, dailyCapKey("")) {
+			sent[key] = times[len(times)-1]
+		}
+	}
+	return sent
+}
+
+// DailyMessages returns when each recipient was messaged in the last day,
+// from the in-memory rate limit records, for persistence
+func (a *AlertSystem) DailyMessages() map[string][]time.Time {
+	daily := make(map[string][]time.Time)
+	for key, times := range a.local.Records() {
+		if msisdn, ok := strings.CutPrefix(key, dailyCapKey("")); ok {
+			daily[msisdn] = times
+		}
+	}
+	return daily
+}
+
+// RestoreSentAlerts replaces the in-memory rate limit records with
+// persisted ones
+func (a *AlertSystem) RestoreSentAlerts(sent map[string]time.Time) {
+	now := a.clock.Now()
+	a.local = NewMemoryRateLimiter()
+	for key, timestamp := range sent {
+		a.local.Restore(key, []time.Time{timestamp}, a.config.MaxRateLimitWindow(), now)
+	}
+}
+
+// RestoreDailyMessages adds persisted daily cap records to the in-memory
+// rate limit records
+func (a *AlertSystem) RestoreDailyMessages(daily map[string][]time.Time) {
+	now := a.clock.Now()
+	for msisdn, times := range daily {
+		a.local.Restore(dailyCapKey(msisdn), times, dailyCapWindow, now)
+	}
+}
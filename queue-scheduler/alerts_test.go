@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGraphAPI stands in for the WhatsApp Business API and records every
+// message it receives
+type fakeGraphAPI struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	messages []WhatsAppMessage
+	auth     []string
+	status   int
+}
+
+func newFakeGraphAPI(t *testing.T) *fakeGraphAPI {
+	t.Helper()
+
+	fake := &fakeGraphAPI{status: http.StatusOK}
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message WhatsAppMessage
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Errorf("fake Graph API: bad payload: %v", err)
+		}
+
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.messages = append(fake.messages, message)
+		fake.auth = append(fake.auth, r.Header.Get("Authorization"))
+
+		w.WriteHeader(fake.status)
+		w.Write([]byte(`{"messages":[{"id":"wamid.test"}]}`))
+	}))
+	t.Cleanup(fake.Close)
+
+	return fake
+}
+
+// sent returns the messages received so far
+func (f *fakeGraphAPI) sent() []WhatsAppMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]WhatsAppMessage(nil), f.messages...)
+}
+
+func (f *fakeGraphAPI) config() WhatsAppConfig {
+	return WhatsAppConfig{APIURL: f.URL, AccessToken: "test-token"}
+}
+
+func TestSendAlertWhatsAppPayload(t *testing.T) {
+	graph := newFakeGraphAPI(t)
+	alerts := NewAlertSystem(NewVirtualClock(testNow), graph.config(), DefaultConfig())
+
+	alerts.SendAlert(AlertRequest{
+		EntryID:   "E1",
+		MSISDN:    "27601234567",
+		Message:   "Position #2",
+		Channel:   "whatsapp",
+		QueueID:   "Q1",
+		Timestamp: testNow,
+	})
+
+	sent := graph.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent))
+	}
+	want := WhatsAppMessage{
+		MessagingProduct: "whatsapp",
+		RecipientType:    "individual",
+		To:               "27601234567",
+		Type:             "text",
+		Text:             WhatsAppTextBody{PreviewURL: true, Body: "Position #2"},
+	}
+	if sent[0] != want {
+		t.Errorf("payload = %+v, want %+v", sent[0], want)
+	}
+	if graph.auth[0] != "Bearer test-token" {
+		t.Errorf("Authorization = %q, want bearer token", graph.auth[0])
+	}
+}
+
+func TestSendAlertRateLimiting(t *testing.T) {
+	alert := AlertRequest{EntryID: "E1", MSISDN: "27601234567", Channel: "whatsapp", QueueID: "Q1"}
+
+	tests := []struct {
+		name     string
+		second   func(AlertRequest) AlertRequest
+		advance  time.Duration
+		wantSent int
+	}{
+		{
+			name:     "repeat within window is dropped",
+			second:   func(a AlertRequest) AlertRequest { return a },
+			advance:  4 * time.Minute,
+			wantSent: 1,
+		},
+		{
+			name:     "repeat after window is sent",
+			second:   func(a AlertRequest) AlertRequest { return a },
+			advance:  5 * time.Minute,
+			wantSent: 2,
+		},
+		{
+			name: "different entry with same MSISDN is not limited",
+			second: func(a AlertRequest) AlertRequest {
+				a.EntryID = "E2"
+				return a
+			},
+			advance:  time.Minute,
+			wantSent: 2,
+		},
+		{
+			name: "same entry in another queue is not limited",
+			second: func(a AlertRequest) AlertRequest {
+				a.QueueID = "Q2"
+				return a
+			},
+			advance:  time.Minute,
+			wantSent: 2,
+		},
+		{
+			name: "serving notice within window is sent",
+			second: func(a AlertRequest) AlertRequest {
+				a.Kind = messageServing
+				return a
+			},
+			advance:  time.Minute,
+			wantSent: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			graph := newFakeGraphAPI(t)
+			clock := NewVirtualClock(testNow)
+			alerts := NewAlertSystem(clock, graph.config(), DefaultConfig())
+
+			first := alert
+			first.Timestamp = clock.Now()
+			alerts.SendAlert(first)
+
+			clock.Advance(tt.advance)
+			second := tt.second(alert)
+			second.Timestamp = clock.Now()
+			alerts.SendAlert(second)
+
+			if got := len(graph.sent()); got != tt.wantSent {
+				t.Errorf("sent %d messages, want %d", got, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestSendAlertRecordsOutcome(t *testing.T) {
+	graph := newFakeGraphAPI(t)
+	graph.status = http.StatusBadRequest
+
+	dir := t.TempDir()
+	recorder, err := NewRecorder(NewVirtualClock(testNow), filepath.Join(dir, "alerts"), 0, 0)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+
+	clock := NewVirtualClock(testNow)
+	alerts := NewAlertSystem(clock, graph.config(), DefaultConfig())
+	alerts.recorder = recorder
+
+	alert := AlertRequest{EntryID: "E1", MSISDN: "27601234567", Channel: "whatsapp", QueueID: "Q1", Timestamp: testNow}
+	alerts.SendAlert(alert)
+	alerts.SendAlert(alert)
+	alerts.SendAlert(AlertRequest{EntryID: "E2", Channel: "pigeon", QueueID: "Q1", Timestamp: testNow})
+
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "alerts-*.jsonl.gz"))
+	if len(files) != 1 {
+		t.Fatalf("found %d recording files, want 1", len(files))
+	}
+	records, err := readRecordFile(files[0])
+	if err != nil {
+		t.Fatalf("readRecordFile: %v", err)
+	}
+
+	want := []string{alertOutcomeFailed, alertOutcomeRateLimited, alertOutcomeUnknownChannel}
+	if len(records) != len(want) {
+		t.Fatalf("recorded %d alerts, want %d", len(records), len(want))
+	}
+	for i, record := range records {
+		if record.Kind != recordKindAlert || record.Outcome != want[i] {
+			t.Errorf("record %d = %s/%s, want alert/%s", i, record.Kind, record.Outcome, want[i])
+		}
+	}
+}
+
+func TestDryRunDoesNotDeliver(t *testing.T) {
+	graph := newFakeGraphAPI(t)
+	alerts := NewAlertSystem(NewVirtualClock(testNow), graph.config(), DefaultConfig())
+	alerts.dryRun = true
+
+	alerts.SendAlert(AlertRequest{EntryID: "E1", Channel: "whatsapp", QueueID: "Q1", Timestamp: testNow})
+
+	if got := len(graph.sent()); got != 0 {
+		t.Errorf("dry run delivered %d messages", got)
+	}
+}
+
+func TestAlertStatsAndRestore(t *testing.T) {
+	clock := NewVirtualClock(testNow)
+	alerts := NewAlertSystem(clock, WhatsAppConfig{}, DefaultConfig())
+
+	alerts.RestoreSentAlerts(map[string]time.Time{
+		"E1:Q1:whatsapp": testNow.Add(-time.Minute),
+		"E2:Q1:whatsapp": testNow.Add(-10 * time.Minute), // outside the rate limit window
+	})
+
+	sent := alerts.SentAlerts()
+	if len(sent) != 1 {
+		t.Fatalf("restored %d records, want 1 after cleanup", len(sent))
+	}
+	if _, ok := sent["E1:Q1:whatsapp"]; !ok {
+		t.Errorf("recent record was dropped: %v", sent)
+	}
+	if got := alerts.GetAlertStats()["alerts_24h"]; got != 1 {
+		t.Errorf("alerts_24h = %d, want 1", got)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+)
+
+// Appointment statuses, matching the Django Appointment.Status choices
+const (
+	appointmentBooked    = "booked"
+	appointmentCheckedIn = "checked_in"
+	appointmentNoShow    = "no_show"
+	appointmentCancelled = "cancelled"
+)
+
+const (
+	// defaultAppointmentReminder is how long before an appointment the
+	// customer is reminded of it
+	defaultAppointmentReminder = 30 * time.Minute
+
+	// defaultAppointmentGrace is how late a customer may be before their
+	// appointment counts as a no-show and its slot is released
+	defaultAppointmentGrace = 10 * time.Minute
+
+	// appointmentNoticeWindow bounds how late a reminder or no-show message
+	// may go out, so a restart doesn't message yesterday's no-shows
+	appointmentNoticeWindow = time.Hour
+
+	// appointmentAlertRetention is how long sent appointment messages are
+	// remembered
+	appointmentAlertRetention = 48 * time.Hour
+)
+
+// AppointmentUpdater writes appointment status changes back to Django
+type AppointmentUpdater interface {
+	UpdateAppointmentStatus(appointmentID, status string) error
+}
+
+// Key returns the identity used for an appointment's messages
+func (a Appointment) Key() string {
+	return "appointment:" + a.ID
+}
+
+// awaited reports whether a booked appointment's customer hasn't checked in
+func (a Appointment) awaited() bool {
+	return a.Status == appointmentBooked && a.EntryID == nil
+}
+
+// holdsSlot reports whether the appointment still reserves a counter at now:
+// booked, not checked in and at most grace late
+func (a Appointment) holdsSlot(now time.Time, grace time.Duration) bool {
+	return a.awaited() && !now.After(a.ScheduledAt.Add(grace))
+}
+
+// isNoShow reports whether the customer is more than grace late without
+// checking in
+func (a Appointment) isNoShow(now time.Time, grace time.Duration) bool {
+	return a.awaited() && now.After(a.ScheduledAt.Add(grace))
+}
+
+// SetAppointmentGrace changes how late a customer may be before their
+// appointment slot is released
+func (calc *QueueCalculator) SetAppointmentGrace(grace time.Duration) {
+	calc.appointmentGrace = grace
+}
+
+// reservedSlots returns how long from now each appointment still holding a
+// slot is due, earliest first. Appointments already due count as due now.
+func (calc *QueueCalculator) reservedSlots(queue Queue, now time.Time) []time.Duration {
+	var reserved []time.Duration
+	for _, appointment := range queue.Appointments {
+		if appointment.holdsSlot(now, calc.appointmentGrace) {
+			reserved = append(reserved, max(appointment.ScheduledAt.Sub(now), 0))
+		}
+	}
+	sort.Slice(reserved, func(i, j int) bool { return reserved[i] < reserved[j] })
+	return reserved
+}
+
+// reservedSlot is a booked appointment in a counter simulation: how long
+// from now it is due, and the lane of its queue
+type reservedSlot struct {
+	due  time.Duration
+	lane int
+}
+
+// serveReserved lets reserved appointments that are due by the time the next
+// counter frees up take that counter ahead of walk-ins, each for one
+// service of their queue. It returns the reservations not yet due.
+func serveReserved(counters []time.Duration, reserved []reservedSlot, service func(lane int) time.Duration) []reservedSlot {
+	for len(reserved) > 0 {
+		next := earliestCounter(counters)
+		if reserved[0].due > counters[next] {
+			break
+		}
+		counters[next] += service(reserved[0].lane)
+		reserved = reserved[1:]
+	}
+	return reserved
+}
+
+// SetAppointmentReminder changes how long before an appointment the customer
+// is reminded. Zero disables reminders.
+func (s *Scheduler) SetAppointmentReminder(lead time.Duration) {
+	s.appointmentReminder = lead
+}
+
+// SetLocation sets the time zone appointment times are shown in
+func (s *Scheduler) SetLocation(location *time.Location) {
+	s.location = location
+}
+
+// checkAppointments reminds customers of upcoming appointments and tells
+// those who missed theirs that the slot was released. Each message goes out
+// once per appointment.
+func (s *Scheduler) checkAppointments(queue Queue, estimate QueueEstimate) {
+	now := s.clock.Now()
+	grace := s.calculator.appointmentGrace
+
+	for _, appointment := range queue.Appointments {
+		var kind string
+		switch {
+		case appointment.isNoShow(now, grace):
+			if !s.markNoShow(queue.QueueID, appointment) {
+				continue // checked in or cancelled since the snapshot
+			}
+			if now.Sub(appointment.ScheduledAt.Add(grace)) > appointmentNoticeWindow {
+				continue
+			}
+			kind = messageNoShow
+		case appointment.awaited() && s.appointmentReminder > 0 &&
+			!now.Before(appointment.ScheduledAt.Add(-s.appointmentReminder)) && now.Before(appointment.ScheduledAt):
+			kind = messageReminder
+		default:
+			continue
+		}
+
+		sentKey := appointment.Key() + ":" + kind
+		if _, sent := s.appointmentAlerts[sentKey]; sent {
+			continue
+		}
+		if s.holdForQuietHours(queue.QueueID, kind, appointment.Key()) {
+			continue
+		}
+		if kind == messageNoShow {
+			log.Printf("🚫 Appointment %s in queue %s at %s is a no-show, slot released",
+				appointment.ID, queue.QueueID, appointment.ScheduledAt.Format(time.RFC3339))
+		}
+
+		data := newMessageData(queue.Name, 0, estimate.Stats.EstimatedWaitTime, WaitRange{
+			P50: estimate.Stats.WaitP50,
+			P90: estimate.Stats.WaitP90,
+		})
+		data.AppointmentTime = appointment.ScheduledAt.In(s.location).Format("15:04")
+		message, err := s.templates.Render(kind, data)
+		if err != nil {
+			log.Printf("Error rendering message for %s: %v", appointment.Key(), err)
+			continue
+		}
+
+		if !s.stillLeading() {
+			log.Printf("🪑 Not sending %s message to %s: no longer the leader", kind, appointment.Key())
+			return
+		}
+		outcome := s.alerter.SendAlert(AlertRequest{
+			EntryID:   appointment.Key(),
+			MSISDN:    appointment.MSISDN,
+			Message:   message,
+			Channel:   "whatsapp",
+			QueueID:   queue.QueueID,
+			Kind:      kind,
+			Timestamp: now,
+		})
+		if outcome == alertOutcomeSent || outcome == alertOutcomeDryRun {
+			s.appointmentAlerts[sentKey] = now
+		}
+	}
+}
+
+// markNoShow records a no-show in Django, if an updater is set, so the slot
+// shows as released to everyone. It reports false if Django has already
+// resolved the appointment, e.g. a check-in that raced the snapshot, so the
+// customer isn't told their slot is gone. Other failures are retried at the
+// next poll, as the appointment is still booked then.
+func (s *Scheduler) markNoShow(queueID string, appointment Appointment) bool {
+	if s.appointments == nil {
+		return true
+	}
+	err := s.appointments.UpdateAppointmentStatus(appointment.ID, appointmentNoShow)
+	switch {
+	case errors.Is(err, errAppointmentResolved):
+		log.Printf("Appointment %s in queue %s was resolved in Django before it could be marked a no-show", appointment.ID, queueID)
+		return false
+	case err != nil:
+		log.Printf("Error marking appointment %s in queue %s as a no-show: %v", appointment.ID, queueID, err)
+	default:
+		log.Printf("🚫 Marked appointment %s in queue %s as a no-show", appointment.ID, queueID)
+	}
+	return true
+}
+
+// pruneAppointmentAlerts forgets appointment messages sent long ago
+func (s *Scheduler) pruneAppointmentAlerts() {
+	cutoff := s.clock.Now().Add(-appointmentAlertRetention)
+	for key, sentAt := range s.appointmentAlerts {
+		if sentAt.Before(cutoff) {
+			delete(s.appointmentAlerts, key)
+		}
+	}
+}
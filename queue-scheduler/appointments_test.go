@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func appointmentAt(id, status string, in time.Duration) Appointment {
+	return Appointment{
+		ID:          id,
+		MSISDN:      "2761" + id,
+		ScheduledAt: testNow.Add(in),
+		Status:      status,
+	}
+}
+
+func stringPtr(s string) *string { return &s }
+
+func TestReservedSlotsDelayWalkIns(t *testing.T) {
+	checkedIn := appointmentAt("C1", appointmentCheckedIn, -5*time.Minute)
+	checkedIn.EntryID = stringPtr("E9")
+	ignored := []Appointment{
+		appointmentAt("N1", appointmentBooked, -15*time.Minute), // past the grace period
+		appointmentAt("X1", appointmentCancelled, time.Minute),
+		appointmentAt("S1", appointmentNoShow, time.Minute),
+		checkedIn,
+	}
+
+	tests := []struct {
+		name        string
+		appointment Appointment
+		wantWaits   map[string]time.Duration
+		wantJoining time.Duration
+	}{
+		{
+			// W1 takes the idle counter; the appointment is due before it
+			// frees up at 4m, so it goes before W2
+			name:        "due later",
+			appointment: appointmentAt("A1", appointmentBooked, 2*time.Minute),
+			wantWaits:   map[string]time.Duration{"W1": 0, "W2": 8 * time.Minute},
+			wantJoining: 12 * time.Minute,
+		},
+		{
+			name:        "late within grace",
+			appointment: appointmentAt("A1", appointmentBooked, -5*time.Minute),
+			wantWaits:   map[string]time.Duration{"W1": 4 * time.Minute, "W2": 8 * time.Minute},
+			wantJoining: 12 * time.Minute,
+		},
+		{
+			name:        "due after the walk-ins",
+			appointment: appointmentAt("A1", appointmentBooked, time.Hour),
+			wantWaits:   map[string]time.Duration{"W1": 0, "W2": 4 * time.Minute},
+			wantJoining: 8 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+			calc.SetServerCount("Q1", 1)
+
+			queue := Queue{
+				QueueID: "Q1",
+				Entries: []QueueEntry{
+					servedEntry("S0", time.Minute, 4*time.Minute),
+					waitingEntry("W1", 10*time.Minute),
+					waitingEntry("W2", 5*time.Minute),
+				},
+				Appointments: append([]Appointment{tt.appointment}, ignored...),
+			}
+			estimate := calc.EstimateQueue(queue)
+
+			if estimate.Stats.ReservedSlots != 1 {
+				t.Errorf("ReservedSlots = %d, want 1", estimate.Stats.ReservedSlots)
+			}
+			if !reflect.DeepEqual(estimate.Waits, tt.wantWaits) {
+				t.Errorf("waits = %v, want %v", estimate.Waits, tt.wantWaits)
+			}
+			if estimate.Stats.EstimatedWaitTime != tt.wantJoining {
+				t.Errorf("EstimatedWaitTime = %v, want %v", estimate.Stats.EstimatedWaitTime, tt.wantJoining)
+			}
+			if r := estimate.Ranges["W2"]; r.P90 < tt.wantWaits["W2"]/2 {
+				t.Errorf("W2 range %+v ignores the reserved slot", r)
+			}
+		})
+	}
+}
+
+func TestAppointmentReminderAndNoShow(t *testing.T) {
+	queue := Queue{QueueID: "Q1", Name: "Clinic", Appointments: []Appointment{
+		appointmentAt("R1", appointmentBooked, 20*time.Minute),
+		appointmentAt("F1", appointmentBooked, 2*time.Hour),     // too early for a reminder
+		appointmentAt("N1", appointmentBooked, -15*time.Minute), // no-show
+		appointmentAt("X1", appointmentCancelled, -15*time.Minute),
+		appointmentAt("OLD", appointmentBooked, -3*time.Hour), // missed too long ago to mention
+	}}
+	django := newFakeDjango(t, queue)
+	store := NewStateStore(filepath.Join(t.TempDir(), "state.json"))
+
+	graph := newFakeGraphAPI(t)
+	scheduler, clock := newTestScheduler(t, django, graph)
+	scheduler.SetLocation(time.UTC)
+	if err := scheduler.RestoreState(store); err != nil {
+		t.Fatalf("RestoreState: %v", err)
+	}
+	scheduler.processQueues()
+
+	got := messagesTo(graph)
+	want := map[string]string{
+		"2761R1": "your appointment at Clinic is at 12:20",
+		"2761N1": "We missed you for your 11:45 appointment at Clinic",
+	}
+	for msisdn, text := range want {
+		if len(got[msisdn]) != 1 || !strings.Contains(got[msisdn][0], text) {
+			t.Errorf("messages to %s = %q, want one containing %q", msisdn, got[msisdn], text)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("messaged %v, want only %v", got, want)
+	}
+
+	// Past the rate limit window nothing is repeated, nor after a restart
+	clock.Advance(10 * time.Minute)
+	scheduler.processQueues()
+
+	restartedGraph := newFakeGraphAPI(t)
+	restarted, restartedClock := newTestScheduler(t, django, restartedGraph)
+	restartedClock.Set(clock.Now())
+	if err := restarted.RestoreState(store); err != nil {
+		t.Fatalf("RestoreState after restart: %v", err)
+	}
+	restarted.processQueues()
+
+	if n := len(graph.sent()) + len(restartedGraph.sent()); n != len(want) {
+		t.Errorf("sent %d messages in total, want %d: %v %v", n, len(want), messagesTo(graph), messagesTo(restartedGraph))
+	}
+}
+
+func TestNoShowRacingCheckIn(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		updates int
+	)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		updates++
+		mu.Unlock()
+		http.Error(w, `{"error": "Appointment is checked_in"}`, http.StatusConflict)
+	}))
+	t.Cleanup(api.Close)
+
+	// The snapshot still shows N1 booked, but it checked in just after
+	queue := Queue{QueueID: "Q1", Name: "Clinic", Appointments: []Appointment{
+		appointmentAt("N1", appointmentBooked, -15*time.Minute),
+	}}
+	django := newFakeDjango(t, queue)
+	graph := newFakeGraphAPI(t)
+	scheduler, clock := newTestScheduler(t, django, graph)
+	scheduler.appointments = NewAPIClient(api.URL)
+	scheduler.processQueues()
+
+	queue.Appointments[0].Status = appointmentCheckedIn
+	django.setQueues(queue)
+	clock.Advance(time.Minute)
+	scheduler.processQueues()
+
+	if got := messagesTo(graph)["2761N1"]; len(got) != 0 {
+		t.Errorf("messages to the checked-in customer = %q, want none", got)
+	}
+	if updates != 1 {
+		t.Errorf("tried to mark the no-show %d times, want once", updates)
+	}
+}
+
+func TestNoShowsAreWrittenBack(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		updates []string
+		fail    = true
+	)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Status string }
+		json.NewDecoder(r.Body).Decode(&body)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		updates = append(updates, r.Method+" "+r.URL.Path+" "+body.Status)
+	}))
+	t.Cleanup(api.Close)
+
+	queue := Queue{QueueID: "Q1", Name: "Clinic", Appointments: []Appointment{
+		appointmentAt("R1", appointmentBooked, 20*time.Minute),
+		appointmentAt("N1", appointmentBooked, -15*time.Minute),
+		appointmentAt("X1", appointmentCancelled, -15*time.Minute),
+	}}
+	django := newFakeDjango(t, queue)
+	graph := newFakeGraphAPI(t)
+	scheduler, clock := newTestScheduler(t, django, graph)
+	scheduler.appointments = NewAPIClient(api.URL)
+
+	// Django is down: the customer is still told, and the update is retried
+	scheduler.processQueues()
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	clock.Advance(time.Minute)
+	scheduler.processQueues()
+
+	// Once Django has it, the appointment no longer shows as booked
+	queue.Appointments[1].Status = appointmentNoShow
+	django.setQueues(queue)
+	clock.Advance(time.Minute)
+	scheduler.processQueues()
+
+	want := []string{"PUT /appointment/N1/status/ no_show"}
+	if !reflect.DeepEqual(updates, want) {
+		t.Errorf("updates = %q, want %q", updates, want)
+	}
+	if got := messagesTo(graph)["2761N1"]; len(got) != 1 {
+		t.Errorf("messages to the no-show = %q, want one", got)
+	}
+}
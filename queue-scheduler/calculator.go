@@ -2,60 +2,468 @@ package main
 
 import (
 	"log"
+	"math/rand/v2"
+	"sort"
 	"time"
 )
 
 // QueueCalculator handles time calculations for queues
 type QueueCalculator struct {
-	// lookbackWindow defines how far back to look for historical data
-	lookbackWindow time.Duration
+	// config holds the lookback window, per queue, defining how far back
+	// to look for historical data
+	config Config
+
+	// serverCounts pins the number of counters for specific queues instead
+	// of inferring it from concurrent in_progress entries
+	serverCounts map[string]int
+
+	// estimator turns recent service times into the typical service time
+	estimator ServiceTimeEstimator
+
+	// priors hold the assumed service time per queue for cold starts
+	priors map[string]ServicePrior
+
+	// abandonment holds the learned abandonment curve per queue
+	abandonment map[string]*AbandonmentCurve
+
+	// profiles are optional weekday/hour service time profiles used when
+	// the lookback window has few services
+	profiles *ServiceProfiles
+
+	// policy orders waiting customers by priority class
+	policy PriorityPolicy
+
+	// pools maps queues sharing counters to their pool
+	pools map[string]*CounterPool
+
+	// appointmentGrace is how late a booked customer may be before their
+	// slot is released
+	appointmentGrace time.Duration
+
+	clock Clock
+}
+
+// QueueEstimate holds the statistics for a queue snapshot together with the
+// position, expected wait and likely wait range of every waiting entry,
+// keyed by QueueEntry.Key(). EffectivePositions discount the customers ahead
+// by their chance of leaving before being served. Positions follow the
+// calculator's priority policy; PriorityAhead counts the priority customers
+// placed ahead of each entry despite joining later.
+type QueueEstimate struct {
+	Stats              QueueStats
+	Positions          map[string]int
+	EffectivePositions map[string]float64
+	PriorityAhead      map[string]int
+	Waits              map[string]time.Duration
+	Ranges             map[string]WaitRange
 }
 
-// NewQueueCalculator creates a new calculator with default 30-minute lookback
-func NewQueueCalculator() *QueueCalculator {
+// NewQueueCalculator creates a new calculator using config's lookback windows
+// and appointment grace period
+func NewQueueCalculator(clock Clock, config Config) *QueueCalculator {
 	return &QueueCalculator{
-		lookbackWindow: 30 * time.Minute,
+		config:           config,
+		serverCounts:     make(map[string]int),
+		estimator:        MeanEstimator{},
+		priors:           make(map[string]ServicePrior),
+		abandonment:      make(map[string]*AbandonmentCurve),
+		policy:           FIFOPolicy{},
+		pools:            make(map[string]*CounterPool),
+		appointmentGrace: config.AppointmentGrace,
+		clock:            clock,
+	}
+}
+
+// SetEstimator changes how recent service times are summarised
+func (calc *QueueCalculator) SetEstimator(estimator ServiceTimeEstimator) {
+	calc.estimator = estimator
+}
+
+// SetPriorityPolicy changes the order waiting customers are expected to be
+// called in
+func (calc *QueueCalculator) SetPriorityPolicy(policy PriorityPolicy) {
+	calc.policy = policy
+}
+
+// SetPrior sets the assumed service time for a queue without history
+func (calc *QueueCalculator) SetPrior(queueID string, prior ServicePrior) {
+	calc.priors[queueID] = prior
+}
+
+// SetProfiles attaches historical service time profiles, which the
+// calculator also keeps learning from
+func (calc *QueueCalculator) SetProfiles(profiles *ServiceProfiles) {
+	calc.profiles = profiles
+}
+
+// ObserveTransitions learns from entries that changed between two snapshots
+// of a queue: customers who stopped waiting feed the abandonment curve and,
+// with profiles attached, newly served customers feed the profile. Entries
+// missing from the current snapshot tell us nothing and are ignored.
+func (calc *QueueCalculator) ObserveTransitions(previous, current Queue) {
+	now := calc.clock.Now()
+
+	currentByKey := make(map[string]QueueEntry, len(current.Entries))
+	for _, entry := range current.Entries {
+		currentByKey[entry.Key()] = entry
+	}
+
+	for _, before := range previous.Entries {
+		after, ok := currentByKey[before.Key()]
+		if !ok || before.Left {
+			continue
+		}
+
+		if before.Status == "waiting" {
+			calc.observeAbandonment(current.QueueID, after, now)
+		}
+		if calc.profiles != nil && before.Status != "served" && after.Status == "served" &&
+			after.StartedAt != nil && after.ServedAt != nil {
+			calc.profiles.observe(current.QueueID, *after.StartedAt, after.ServedAt.Sub(*after.StartedAt))
+		}
+	}
+}
+
+// prior returns the configured prior for a queue or the default
+func (calc *QueueCalculator) prior(queueID string) ServicePrior {
+	if prior, ok := calc.priors[queueID]; ok {
+		return prior
+	}
+	return defaultServicePrior
+}
+
+// SetServerCount fixes the number of counters serving a queue. A count of
+// zero or less goes back to inferring it.
+func (calc *QueueCalculator) SetServerCount(queueID string, servers int) {
+	if servers <= 0 {
+		delete(calc.serverCounts, queueID)
+		return
 	}
+	calc.serverCounts[queueID] = servers
 }
 
 // CalculateQueueStats computes statistics for a given queue
 func (calc *QueueCalculator) CalculateQueueStats(queue Queue) QueueStats {
-	stats := QueueStats{
-		QueueID: queue.QueueID,
+	return calc.EstimateQueue(queue).Stats
+}
+
+// EstimateQueue computes queue statistics and per-entry waits in one pass.
+//
+// Waits come from a small simulation of the counters: each of the queue's
+// servers is either idle or busy with an in_progress customer, and waiting
+// customers, in the order of the priority policy, take the next counter to
+// free up, each needing an average service. A counter that is busy frees up
+// after the expected remaining service of its customer, given how long they
+// have been there already (see residualServiceTime). Customers who are
+// likely to give up before reaching a counter only hold it up for their
+// chance of staying (see AbandonmentCurve). Booked appointments that haven't
+// checked in reserve the first counter to free up once they are due, ahead
+// of walk-ins.
+//
+// A queue sharing a counter pool is estimated as if it had the pool to
+// itself; use EstimateQueues to account for the other queues.
+func (calc *QueueCalculator) EstimateQueue(queue Queue) QueueEstimate {
+	return calc.estimateQueue(queue, calc.estimator)
+}
+
+// estimateQueue is EstimateQueue with the given service time estimator
+func (calc *QueueCalculator) estimateQueue(queue Queue, estimator ServiceTimeEstimator) QueueEstimate {
+	now := calc.clock.Now()
+	lane := calc.newLane(queue, estimator, now)
+
+	servers := calc.serverCount(queue, now)
+	if pool, ok := calc.pools[queue.QueueID]; ok {
+		servers = pool.Counters
+	}
+	calc.simulateLanes([]*queueLane{lane}, servers, now, queue.QueueID)
+
+	return lane.estimate
+}
+
+// queueLane is one queue's part of a counter simulation, with the
+// statistics that don't depend on the counters already filled in
+type queueLane struct {
+	queue        Queue
+	estimate     QueueEstimate
+	serviceTimes []time.Duration // recent, outliers removed
+	average      time.Duration
+	serving      []QueueEntry
+	waiting      []QueueEntry // in call order
+	reserved     []time.Duration
+	curve        *AbandonmentCurve
+}
+
+// newLane works out a queue's service times, active customers and reserved
+// appointments
+func (calc *QueueCalculator) newLane(queue Queue, estimator ServiceTimeEstimator, now time.Time) *queueLane {
+	lane := &queueLane{
+		queue: queue,
+		estimate: QueueEstimate{
+			Stats:              QueueStats{QueueID: queue.QueueID},
+			Positions:          make(map[string]int),
+			EffectivePositions: make(map[string]float64),
+			Waits:              make(map[string]time.Duration),
+			Ranges:             make(map[string]WaitRange),
+		},
+		curve: calc.abandonment[queue.QueueID],
 	}
+	stats := &lane.estimate.Stats
 
 	// Calculate average processing time from recent served customers
-	avgProcessTime := calc.calculateAverageProcessTime(queue.Entries)
-	stats.AverageProcessTime = avgProcessTime
+	lane.serviceTimes = rejectOutliers(calc.recentServiceTimes(queue, now))
+	if profiled, ok := calc.profiles.expected(queue.QueueID, now); ok {
+		stats.ProfileServiceTime = profiled
+	}
+	lane.average = calc.calculateAverageProcessTime(queue.QueueID, estimator, lane.serviceTimes)
+	stats.AverageProcessTime = lane.average
+
+	sorted := sortedDurations(lane.serviceTimes)
+	stats.ServiceTimeP50 = percentile(sorted, 0.5)
+	stats.ServiceTimeP90 = percentile(sorted, 0.9)
 
 	// Count active entries (not left, not served)
-	activeCount := 0
+	var waiting []QueueEntry
 	for _, entry := range queue.Entries {
-		if !entry.Left && entry.Status != "served" {
-			activeCount++
+		if entry.Left || entry.Status == "served" {
+			continue
+		}
+		stats.ActiveEntries++
+
+		switch entry.Status {
+		case "in_progress":
+			lane.serving = append(lane.serving, entry)
+		case "waiting":
+			waiting = append(waiting, entry)
+		}
+	}
+	stats.BusyServers = len(lane.serving)
+
+	lane.waiting = calc.policy.Order(waiting)
+	lane.estimate.PriorityAhead = priorityAhead(lane.waiting)
+
+	lane.reserved = calc.reservedSlots(queue, now)
+	stats.ReservedSlots = len(lane.reserved)
+
+	return lane
+}
+
+// simulateLanes runs the counter simulation for queues served by the same
+// servers, filling in each lane's waits, positions and ranges. seedKey
+// seeds the percentile simulation.
+func (calc *QueueCalculator) simulateLanes(lanes []*queueLane, servers int, now time.Time, seedKey string) {
+	busy := 0
+	for _, lane := range lanes {
+		busy += len(lane.serving)
+	}
+	if busy > servers {
+		servers = busy // more counters open than we knew of
+	}
+
+	// Time from now until each counter is free
+	counters := make([]time.Duration, servers)
+	var elapsed []busyCounter
+	for l, lane := range lanes {
+		lane.estimate.Stats.Servers = servers
+		for _, entry := range lane.serving {
+			var e time.Duration
+			if entry.StartedAt != nil {
+				e = now.Sub(*entry.StartedAt)
+			}
+			counters[len(elapsed)] = residualServiceTime(lane.serviceTimes, e, lane.average)
+			elapsed = append(elapsed, busyCounter{lane: l, elapsed: e})
 		}
 	}
-	stats.ActiveEntries = activeCount
 
-	// Estimate wait time based on position and average process time
-	if avgProcessTime > 0 && activeCount > 0 {
-		// Rough estimate: average process time * people ahead in line
-		stats.EstimatedWaitTime = time.Duration(activeCount) * avgProcessTime
+	var reserved []reservedSlot
+	for l, lane := range lanes {
+		for _, due := range lane.reserved {
+			reserved = append(reserved, reservedSlot{due: due, lane: l})
+		}
 	}
+	sort.SliceStable(reserved, func(i, j int) bool { return reserved[i].due < reserved[j].due })
 
-	return stats
+	// Expected counter time is a full service if they stay, none if they
+	// leave first
+	leave := make([][]float64, len(lanes))
+	for l, lane := range lanes {
+		leave[l] = make([]float64, len(lane.waiting))
+	}
+	order := callOrder(lanes, -1)
+	waits := expectedWaits(lanes, counters, reserved, order, func(slot laneSlot, wait time.Duration) float64 {
+		waited := now.Sub(lanes[slot.lane].waiting[slot.index].JoinedAt)
+		leave[slot.lane][slot.index] = lanes[slot.lane].curve.leaveProbability(waited, waited+wait)
+		return leave[slot.lane][slot.index]
+	})
+
+	ahead := make([]float64, len(lanes))
+	for i, slot := range order {
+		estimate := &lanes[slot.lane].estimate
+		key := lanes[slot.lane].waiting[slot.index].Key()
+		p := leave[slot.lane][slot.index]
+
+		estimate.Positions[key] = slot.index + 1
+		estimate.EffectivePositions[key] = ahead[slot.lane] + 1
+		estimate.Waits[key] = waits[i]
+		ahead[slot.lane] += 1 - p
+		estimate.Stats.ExpectedAbandonments += p
+	}
+
+	// A customer joining a queue now is served after everyone already
+	// waiting in it, taking their turn with the other queues
+	orders := make([][]laneSlot, len(lanes))
+	for l, lane := range lanes {
+		lane.estimate.Stats.EffectiveLength = ahead[l]
+
+		orders[l] = callOrder(lanes, l)
+		joinWaits := expectedWaits(lanes, counters, reserved, orders[l], func(slot laneSlot, _ time.Duration) float64 {
+			if slot.index == len(lanes[slot.lane].waiting) {
+				return 0
+			}
+			return leave[slot.lane][slot.index]
+		})
+		lane.estimate.Stats.EstimatedWaitTime = joinWaits[joiningSlot(orders[l], l, len(lane.waiting))]
+	}
+
+	// Percentile ranges come from rerunning the simulation with service
+	// times drawn from the recent distribution
+	rng := rand.New(rand.NewPCG(simulationSeed(seedKey, now)))
+	samplers := make([]*serviceSampler, len(lanes))
+	for l, lane := range lanes {
+		samplers[l] = &serviceSampler{history: lane.serviceTimes, average: lane.average, rng: rng}
+	}
+	ranges := simulateWaitRanges(samplers, servers, elapsed, leave, reserved, order, orders)
+	for l, lane := range lanes {
+		for i, entry := range lane.waiting {
+			lane.estimate.Ranges[entry.Key()] = ranges[l][i]
+		}
+		lane.estimate.Stats.WaitP50 = ranges[l][len(lane.waiting)].P50
+		lane.estimate.Stats.WaitP90 = ranges[l][len(lane.waiting)].P90
+	}
+}
+
+// expectedWaits assigns customers in order to the counter that frees up
+// first, each holding it for their lane's average service discounted by
+// their chance of leaving, and returns each customer's wait. Reserved
+// appointments take a counter first once due.
+func expectedWaits(lanes []*queueLane, counters []time.Duration, reserved []reservedSlot, order []laneSlot,
+	leave func(slot laneSlot, wait time.Duration) float64) []time.Duration {
+	counters = append([]time.Duration(nil), counters...)
+	average := func(lane int) time.Duration { return lanes[lane].average }
+
+	waits := make([]time.Duration, len(order))
+	for i, slot := range order {
+		reserved = serveReserved(counters, reserved, average)
+		next := earliestCounter(counters)
+		waits[i] = counters[next]
+		p := leave(slot, waits[i])
+		counters[next] += time.Duration((1 - p) * float64(lanes[slot.lane].average))
+	}
+	return waits
+}
+
+// nextCounter assigns the next customer to the counter that frees up first
+// and returns how long until they are served
+func nextCounter(counters []time.Duration, service time.Duration) time.Duration {
+	next := earliestCounter(counters)
+
+	start := counters[next]
+	counters[next] = start + service
+	return start
+}
+
+// earliestCounter returns the index of the counter that frees up first
+func earliestCounter(counters []time.Duration) int {
+	next := 0
+	for i := range counters {
+		if counters[i] < counters[next] {
+			next = i
+		}
+	}
+	return next
 }
 
-// calculateAverageProcessTime computes average time from in_progress -> served
-// Only considers entries from the last 30 minutes that have been fully processed
-func (calc *QueueCalculator) calculateAverageProcessTime(entries []QueueEntry) time.Duration {
-	now := time.Now()
-	cutoff := now.Add(-calc.lookbackWindow)
-	
-	var totalProcessTime time.Duration
-	var processedCount int
+// serverCount returns the configured number of counters for a queue, or
+// infers it as the peak number of customers served at the same time within
+// the lookback window
+func (calc *QueueCalculator) serverCount(queue Queue, now time.Time) int {
+	if servers, ok := calc.serverCounts[queue.QueueID]; ok {
+		return servers
+	}
+
+	cutoff := now.Add(-calc.config.Queue(queue.QueueID).LookbackWindow)
+
+	type event struct {
+		at    time.Time
+		delta int
+	}
+	var events []event
+
+	for _, entry := range queue.Entries {
+		if entry.StartedAt == nil {
+			continue
+		}
 
-	for _, entry := range entries {
+		end := now
+		if entry.ServedAt != nil {
+			end = *entry.ServedAt
+		} else if entry.Status != "in_progress" {
+			continue // started but never finished, and no longer at a counter
+		}
+		if end.Before(cutoff) {
+			continue
+		}
+
+		events = append(events, event{*entry.StartedAt, 1}, event{end, -1})
+	}
+
+	// Ends sort before starts at the same instant so back-to-back customers
+	// at one counter don't count as two
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].at.Equal(events[j].at) {
+			return events[i].delta < events[j].delta
+		}
+		return events[i].at.Before(events[j].at)
+	})
+
+	peak, current := 1, 0
+	for _, e := range events {
+		current += e.delta
+		if current > peak {
+			peak = current
+		}
+	}
+
+	return peak
+}
+
+// residualServiceTime estimates how much longer a customer who has been at
+// the counter for elapsed will take: the mean of S - elapsed over historical
+// service times S longer than elapsed. Without any such history it falls
+// back to one full average service, treating service as memoryless.
+func residualServiceTime(serviceTimes []time.Duration, elapsed, average time.Duration) time.Duration {
+	var total time.Duration
+	var count int
+
+	for _, service := range serviceTimes {
+		if service > elapsed {
+			total += service - elapsed
+			count++
+		}
+	}
+
+	if count == 0 {
+		return average
+	}
+	return total / time.Duration(count)
+}
+
+// recentServiceTimes returns in_progress -> served durations of entries
+// served within the lookback window, oldest service first
+func (calc *QueueCalculator) recentServiceTimes(queue Queue, now time.Time) []time.Duration {
+	cutoff := now.Add(-calc.config.Queue(queue.QueueID).LookbackWindow)
+
+	var served []QueueEntry
+	for _, entry := range queue.Entries {
 		// Only consider entries that:
 		// 1. Were served recently (within lookback window)
 		// 2. Have both started_at and served_at timestamps
@@ -68,58 +476,66 @@ func (calc *QueueCalculator) calculateAverageProcessTime(entries []QueueEntry) t
 		}
 
 		// Calculate processing time (in_progress -> served)
-		processTime := entry.ServedAt.Sub(*entry.StartedAt)
-		if processTime > 0 {
-			totalProcessTime += processTime
-			processedCount++
+		if entry.ServedAt.After(*entry.StartedAt) {
+			served = append(served, entry)
 		}
 	}
 
-	if processedCount == 0 {
-		log.Printf("No recent processed entries found for queue calculation")
-		return 5 * time.Minute // Default fallback
-	}
+	sort.SliceStable(served, func(i, j int) bool { return served[i].ServedAt.Before(*served[j].ServedAt) })
 
-	avgTime := totalProcessTime / time.Duration(processedCount)
-	log.Printf("Calculated average process time: %v from %d entries", avgTime, processedCount)
-	
-	return avgTime
+	serviceTimes := make([]time.Duration, len(served))
+	for i, entry := range served {
+		serviceTimes[i] = entry.ServedAt.Sub(*entry.StartedAt)
+	}
+	return serviceTimes
 }
 
-// EstimateWaitTimeForPosition calculates wait time for a specific position in queue
-func (calc *QueueCalculator) EstimateWaitTimeForPosition(queue Queue, targetMSISDN string) time.Duration {
-	stats := calc.CalculateQueueStats(queue)
-	
-	// Find the target customer's position
-	position := calc.findPositionInQueue(queue, targetMSISDN)
-	if position <= 0 {
-		return 0 // Not found or already being served
+// calculateAverageProcessTime summarises recent service times (in_progress
+// -> served, outliers already removed) with the given estimator, blended
+// with the queue's prior. Without history the prior is used as is.
+//
+// When the weekday/hour profile covers the current hour it replaces the
+// configured prior, standing in for the live services missing from the
+// first profileBlendSamples.
+func (calc *QueueCalculator) calculateAverageProcessTime(queueID string, estimator ServiceTimeEstimator, serviceTimes []time.Duration) time.Duration {
+	prior := calc.prior(queueID)
+	if profiled, ok := calc.profiles.expected(queueID, calc.clock.Now()); ok {
+		prior = ServicePrior{Mean: profiled, Weight: max(profileBlendSamples-len(serviceTimes), 0)}
 	}
 
-	// Estimate: position * average process time
-	if stats.AverageProcessTime > 0 {
-		return time.Duration(position) * stats.AverageProcessTime
+	if len(serviceTimes) == 0 {
+		log.Printf("No recent processed entries found for queue %s, assuming %v", queueID, prior.Mean)
+		return prior.Mean
 	}
 
-	// Fallback estimate
-	return time.Duration(position) * 5 * time.Minute
+	avgTime := prior.blend(estimator.Estimate(serviceTimes), len(serviceTimes))
+	log.Printf("Calculated %s process time: %v from %d entries", estimator.Name(), avgTime, len(serviceTimes))
+
+	return avgTime
+}
+
+// EstimateWaitTimeForPosition calculates wait time for a specific position in
+// queue. It returns 0 for entries that aren't waiting.
+func (calc *QueueCalculator) EstimateWaitTimeForPosition(queue Queue, entryKey string) time.Duration {
+	return calc.EstimateQueue(queue).Waits[entryKey]
 }
 
 // findPositionInQueue determines customer's position among waiting customers
-func (calc *QueueCalculator) findPositionInQueue(queue Queue, targetMSISDN string) int {
-	position := 0
-	
+// under the priority policy, identifying the customer by QueueEntry.Key()
+func (calc *QueueCalculator) findPositionInQueue(queue Queue, entryKey string) int {
+	var waiting []QueueEntry
 	for _, entry := range queue.Entries {
 		if entry.Left || entry.Status == "served" || entry.Status == "in_progress" {
 			continue // Skip customers who left, were served, or are currently being served
 		}
-		
-		position++
-		
-		if entry.MSISDN == targetMSISDN {
-			return position
+		waiting = append(waiting, entry)
+	}
+
+	for i, entry := range calc.policy.Order(waiting) {
+		if entry.Key() == entryKey {
+			return i + 1
 		}
 	}
-	
+
 	return 0 // Not found in waiting queue
 }
\ No newline at end of file
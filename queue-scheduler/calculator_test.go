@@ -0,0 +1,413 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testNow is the fixed "current time" used across the test suites
+var testNow = time.Date(2025, 8, 17, 12, 0, 0, 0, time.UTC)
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+// servedEntry builds an entry served at servedAgo before testNow after a
+// service of the given length
+func servedEntry(id string, servedAgo, service time.Duration) QueueEntry {
+	served := testNow.Add(-servedAgo)
+	return QueueEntry{
+		ID:        id,
+		MSISDN:    "2760" + id,
+		Status:    "served",
+		JoinedAt:  served.Add(-service - 5*time.Minute),
+		StartedAt: timePtr(served.Add(-service)),
+		ServedAt:  timePtr(served),
+	}
+}
+
+func waitingEntry(id string, joinedAgo time.Duration) QueueEntry {
+	return QueueEntry{
+		ID:       id,
+		MSISDN:   "2760" + id,
+		Status:   "waiting",
+		JoinedAt: testNow.Add(-joinedAgo),
+	}
+}
+
+func inProgressEntry(id string, startedAgo time.Duration) QueueEntry {
+	return QueueEntry{
+		ID:        id,
+		MSISDN:    "2760" + id,
+		Status:    "in_progress",
+		JoinedAt:  testNow.Add(-startedAgo - 5*time.Minute),
+		StartedAt: timePtr(testNow.Add(-startedAgo)),
+	}
+}
+
+// sampleQueue mirrors the data the old -test harness used: three recent
+// services of 3, 5 and 2 minutes, one customer at the counter and three
+// waiting
+func sampleQueue() Queue {
+	return Queue{
+		QueueID: "TEST123",
+		Name:    "Test Queue",
+		Entries: []QueueEntry{
+			servedEntry("COMP1", 17*time.Minute, 3*time.Minute),
+			servedEntry("COMP2", 13*time.Minute, 5*time.Minute),
+			servedEntry("COMP3", 8*time.Minute, 2*time.Minute),
+			inProgressEntry("CURR1", 3*time.Minute),
+			waitingEntry("WAIT1", 5*time.Minute),
+			waitingEntry("WAIT2", 3*time.Minute),
+			waitingEntry("WAIT3", 1*time.Minute),
+		},
+	}
+}
+
+func TestCalculateAverageProcessTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []QueueEntry
+		want    time.Duration
+	}{
+		{
+			name:    "mean of recent services",
+			entries: sampleQueue().Entries,
+			want:    (3*time.Minute + 5*time.Minute + 2*time.Minute) / 3,
+		},
+		{
+			name: "ignores services outside lookback window",
+			entries: []QueueEntry{
+				servedEntry("OLD", 45*time.Minute, 20*time.Minute),
+				servedEntry("NEW", 5*time.Minute, 4*time.Minute),
+			},
+			want: 4 * time.Minute,
+		},
+		{
+			name: "ignores entries without both timestamps",
+			entries: []QueueEntry{
+				{ID: "NOSTART", Status: "served", ServedAt: timePtr(testNow.Add(-time.Minute))},
+				inProgressEntry("CURR", time.Minute),
+				servedEntry("OK", 2*time.Minute, 6*time.Minute),
+			},
+			want: 6 * time.Minute,
+		},
+		{
+			name:    "falls back to 5 minutes without history",
+			entries: []QueueEntry{waitingEntry("W", time.Minute)},
+			want:    5 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+			stats := calc.CalculateQueueStats(Queue{QueueID: "Q1", Entries: tt.entries})
+			if got := stats.AverageProcessTime; got != tt.want {
+				t.Errorf("AverageProcessTime = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateQueueStats(t *testing.T) {
+	calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+	stats := calc.CalculateQueueStats(sampleQueue())
+
+	if stats.QueueID != "TEST123" {
+		t.Errorf("QueueID = %q, want TEST123", stats.QueueID)
+	}
+	// One in progress plus three waiting
+	if stats.ActiveEntries != 4 {
+		t.Errorf("ActiveEntries = %d, want 4", stats.ActiveEntries)
+	}
+	// COMP1 and COMP2 were at counters at the same time
+	if stats.Servers != 2 || stats.BusyServers != 1 {
+		t.Errorf("Servers = %d busy %d, want 2 busy 1", stats.Servers, stats.BusyServers)
+	}
+	// CURR1 has been served for 3 minutes; only the 5 minute service took
+	// longer, so their counter frees up in 2 minutes. WAIT1 takes the idle
+	// counter, WAIT2 CURR1's, WAIT3 WAIT1's, and a new customer CURR1's again.
+	if want := 2*time.Minute + stats.AverageProcessTime; stats.EstimatedWaitTime != want {
+		t.Errorf("EstimatedWaitTime = %v, want %v", stats.EstimatedWaitTime, want)
+	}
+}
+
+func TestServerCount(t *testing.T) {
+	tests := []struct {
+		name       string
+		entries    []QueueEntry
+		configured int
+		want       int
+	}{
+		{
+			name:    "defaults to one counter",
+			entries: []QueueEntry{waitingEntry("W", time.Minute)},
+			want:    1,
+		},
+		{
+			name: "back-to-back services share a counter",
+			entries: []QueueEntry{
+				servedEntry("A", 10*time.Minute, 5*time.Minute),
+				servedEntry("B", 5*time.Minute, 5*time.Minute),
+			},
+			want: 1,
+		},
+		{
+			name: "overlapping services and current customers",
+			entries: []QueueEntry{
+				servedEntry("A", 2*time.Minute, 10*time.Minute),
+				inProgressEntry("B", 8*time.Minute),
+				inProgressEntry("C", 3*time.Minute),
+			},
+			want: 3,
+		},
+		{
+			name: "services before the lookback window are ignored",
+			entries: []QueueEntry{
+				servedEntry("A", 40*time.Minute, 5*time.Minute),
+				servedEntry("B", 40*time.Minute, 5*time.Minute),
+				servedEntry("C", 5*time.Minute, 5*time.Minute),
+			},
+			want: 1,
+		},
+		{
+			name:       "configured count wins",
+			entries:    []QueueEntry{inProgressEntry("A", time.Minute)},
+			configured: 4,
+			want:       4,
+		},
+		{
+			name: "more customers in progress than configured counters",
+			entries: []QueueEntry{
+				inProgressEntry("A", time.Minute),
+				inProgressEntry("B", time.Minute),
+			},
+			configured: 1,
+			want:       2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+			calc.SetServerCount("Q1", tt.configured)
+
+			stats := calc.CalculateQueueStats(Queue{QueueID: "Q1", Entries: tt.entries})
+			if stats.Servers != tt.want {
+				t.Errorf("Servers = %d, want %d", stats.Servers, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultiServerWaits(t *testing.T) {
+	// Three counters, two busy for 1 and 2 of a typical 6 minutes
+	entries := []QueueEntry{
+		servedEntry("S1", time.Minute, 6*time.Minute),
+		inProgressEntry("B1", time.Minute),
+		inProgressEntry("B2", 2*time.Minute),
+	}
+	for _, id := range []string{"W1", "W2", "W3", "W4"} {
+		entries = append(entries, waitingEntry(id, time.Minute))
+	}
+	queue := Queue{QueueID: "Q1", Entries: entries}
+
+	calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+	calc.SetServerCount("Q1", 3)
+	estimate := calc.EstimateQueue(queue)
+
+	want := map[string]time.Duration{
+		"W1": 0,               // idle counter
+		"W2": 4 * time.Minute, // B2 finishes first
+		"W3": 5 * time.Minute, // then B1
+		"W4": 6 * time.Minute, // then W1
+	}
+	for key, wantWait := range want {
+		if got := estimate.Waits[key]; got != wantWait {
+			t.Errorf("wait for %s = %v, want %v", key, got, wantWait)
+		}
+	}
+	// W2's counter frees up next
+	if estimate.Stats.EstimatedWaitTime != 10*time.Minute {
+		t.Errorf("EstimatedWaitTime = %v, want 10m", estimate.Stats.EstimatedWaitTime)
+	}
+}
+
+func TestResidualServiceTime(t *testing.T) {
+	history := []time.Duration{2 * time.Minute, 4 * time.Minute, 6 * time.Minute, 8 * time.Minute, 10 * time.Minute}
+	average := 6 * time.Minute
+
+	tests := []struct {
+		name    string
+		history []time.Duration
+		elapsed time.Duration
+		want    time.Duration
+	}{
+		{"just started", history, 0, 6 * time.Minute},
+		{"part way through", history, 5 * time.Minute, 3 * time.Minute}, // (1+3+5)/3
+		{"nearly the longest seen", history, 9 * time.Minute, time.Minute},
+		{"longer than any history", history, 12 * time.Minute, average},
+		{"no history", nil, 3 * time.Minute, average},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := residualServiceTime(tt.history, tt.elapsed, average); got != tt.want {
+				t.Errorf("residualServiceTime(%v) = %v, want %v", tt.elapsed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindPositionAndEstimateWait(t *testing.T) {
+	queue := sampleQueue()
+	queue.Entries = append(queue.Entries, QueueEntry{
+		ID: "GONE", MSISDN: "27600000000", Status: "waiting", Left: true,
+	})
+	avg := (3*time.Minute + 5*time.Minute + 2*time.Minute) / 3
+	residual := 2 * time.Minute // CURR1 is 3 minutes into a service
+
+	tests := []struct {
+		name         string
+		entryKey     string
+		wantPosition int
+		wantWait     time.Duration
+	}{
+		{"first waiting", "WAIT1", 1, residual},
+		{"second waiting", "WAIT2", 2, residual + avg},
+		{"third waiting", "WAIT3", 3, residual + 2*avg},
+		{"being served", "CURR1", 0, 0},
+		{"already served", "COMP1", 0, 0},
+		{"left the queue", "GONE", 0, 0},
+		{"not in queue", "MISSING1", 0, 0},
+	}
+
+	// A single counter busy with CURR1: position N waits for CURR1 to finish
+	// plus N-1 averages
+	calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+	calc.SetServerCount(queue.QueueID, 1)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calc.findPositionInQueue(queue, tt.entryKey); got != tt.wantPosition {
+				t.Errorf("findPositionInQueue(%s) = %d, want %d", tt.entryKey, got, tt.wantPosition)
+			}
+			if got := calc.EstimateWaitTimeForPosition(queue, tt.entryKey); got != tt.wantWait {
+				t.Errorf("EstimateWaitTimeForPosition(%s) = %v, want %v", tt.entryKey, got, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestEntryKeyFallback(t *testing.T) {
+	first := QueueEntry{MSISDN: "27601234567", JoinedAt: testNow}
+	rejoin := QueueEntry{MSISDN: "27601234567", JoinedAt: testNow.Add(time.Minute)}
+
+	if first.Key() == rejoin.Key() {
+		t.Errorf("rejoin shares key %q with earlier entry", first.Key())
+	}
+	if got := (QueueEntry{ID: "ABC123", MSISDN: "27601234567"}).Key(); got != "ABC123" {
+		t.Errorf("Key() = %q, want entry ID", got)
+	}
+
+	// Positions still resolve through the fallback key
+	queue := Queue{Entries: []QueueEntry{
+		{MSISDN: "27601234567", Status: "waiting", JoinedAt: testNow, Left: true},
+		{MSISDN: "27601234567", Status: "waiting", JoinedAt: testNow.Add(time.Minute)},
+	}}
+	calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+	if got := calc.findPositionInQueue(queue, rejoin.Key()); got != 1 {
+		t.Errorf("position of rejoined entry = %d, want 1", got)
+	}
+}
+
+func TestEntryKeyIsStable(t *testing.T) {
+	johannesburg := time.FixedZone("SAST", 2*60*60)
+	tests := []struct {
+		name  string
+		entry QueueEntry
+		want  string
+	}{
+		{"entry ID wins", QueueEntry{ID: "E1", MSISDN: "2760", JoinedAt: testNow}, "E1"},
+		{"fallback without ID", QueueEntry{MSISDN: "2760", JoinedAt: testNow}, "msisdn:2760@2025-08-17T12:00:00Z"},
+		{"fallback is the same in any zone", QueueEntry{MSISDN: "2760", JoinedAt: testNow.In(johannesburg)}, "msisdn:2760@2025-08-17T12:00:00Z"},
+		{"fallback keeps sub-second join times", QueueEntry{MSISDN: "2760", JoinedAt: testNow.Add(time.Millisecond)}, "msisdn:2760@2025-08-17T12:00:00.001Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.entry.Key(); got != tt.want {
+				t.Errorf("Key() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitRanges(t *testing.T) {
+	// One counter with services spread between 2 and 10 minutes
+	var entries []QueueEntry
+	for i, service := range []time.Duration{2, 4, 6, 8, 10} {
+		entries = append(entries, servedEntry(fmt.Sprintf("S%d", i), time.Duration(i+1)*time.Minute, service*time.Minute))
+	}
+	entries = append(entries, inProgressEntry("B1", time.Minute))
+	for _, id := range []string{"W1", "W2", "W3"} {
+		entries = append(entries, waitingEntry(id, time.Minute))
+	}
+	queue := Queue{QueueID: "Q1", Entries: entries}
+
+	calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+	calc.SetServerCount("Q1", 1)
+	estimate := calc.EstimateQueue(queue)
+
+	var previous WaitRange
+	for _, key := range []string{"W1", "W2", "W3"} {
+		r := estimate.Ranges[key]
+		if r.P50 > r.P90 {
+			t.Errorf("%s: p50 %v above p90 %v", key, r.P50, r.P90)
+		}
+		if r.P50 < previous.P50 || r.P90 < previous.P90 {
+			t.Errorf("%s: range %v shorter than the customer ahead %v", key, r, previous)
+		}
+		// The expected wait should sit inside a generous band around the range
+		if wait := estimate.Waits[key]; wait < r.P50/2 || wait > r.P90 {
+			t.Errorf("%s: expected wait %v outside range %v", key, wait, r)
+		}
+		previous = r
+	}
+
+	if estimate.Stats.ServiceTimeP50 != 6*time.Minute || estimate.Stats.ServiceTimeP90 != 10*time.Minute {
+		t.Errorf("service p50/p90 = %v/%v, want 6m/10m", estimate.Stats.ServiceTimeP50, estimate.Stats.ServiceTimeP90)
+	}
+	if estimate.Stats.WaitP50 < previous.P50 || estimate.Stats.WaitP90 < previous.P90 {
+		t.Errorf("new customer range %v/%v shorter than last waiting %v",
+			estimate.Stats.WaitP50, estimate.Stats.WaitP90, previous)
+	}
+
+	// Same snapshot, same time: same ranges
+	again := calc.EstimateQueue(queue)
+	if again.Ranges["W3"] != estimate.Ranges["W3"] {
+		t.Errorf("ranges not reproducible: %v then %v", estimate.Ranges["W3"], again.Ranges["W3"])
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	values := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0.5, 5},
+		{0.9, 9},
+		{1, 10},
+		{0, 1},
+	}
+	for _, tt := range tests {
+		if got := percentile(values, tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(nil, 0.5); got != 0 {
+		t.Errorf("percentile of nothing = %v, want 0", got)
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -45,6 +47,40 @@ func (c *APIClient) GetAllQueues() (*APIResponse, error) {
 	return &apiResp, nil
 }
 
+// errAppointmentResolved is returned when Django refuses a status change
+// because the appointment is no longer booked, e.g. it was just checked in
+var errAppointmentResolved = errors.New("appointment is no longer booked")
+
+// UpdateAppointmentStatus sets an appointment's status in Django, e.g. to
+// mark a no-show
+func (c *APIClient) UpdateAppointmentStatus(appointmentID, status string) error {
+	body, err := json.Marshal(map[string]string{"status": status})
+	if err != nil {
+		return fmt.Errorf("failed to encode appointment status: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/appointment/%s/status/", c.BaseURL, appointmentID)
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to update appointment %s: %w", appointmentID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		return fmt.Errorf("failed to update appointment %s: %w", appointmentID, errAppointmentResolved)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API returned status %d updating appointment %s", resp.StatusCode, appointmentID)
+	}
+	return nil
+}
+
 // parseTime safely parses ISO timestamp strings, handling null values
 func parseTime(timeStr string) (*time.Time, error) {
 	if timeStr == "" {
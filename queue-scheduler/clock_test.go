@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVirtualClock(t *testing.T) {
+	clock := NewVirtualClock(testNow)
+	if !clock.Now().Equal(testNow) {
+		t.Errorf("Now = %v, want the start", clock.Now())
+	}
+
+	// Time only moves when told to
+	clock.Advance(90 * time.Second)
+	if got := clock.Now().Sub(testNow); got != 90*time.Second {
+		t.Errorf("after Advance the clock moved %v, want 1m30s", got)
+	}
+	clock.Set(testNow.Add(-time.Hour))
+	if got := clock.Now().Sub(testNow); got != -time.Hour {
+		t.Errorf("after Set the clock is at %v, want an hour back", got)
+	}
+
+	// Safe to use from several goroutines
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clock.Advance(time.Minute)
+		}()
+	}
+	wg.Wait()
+	if got := clock.Now().Sub(testNow); got != -50*time.Minute {
+		t.Errorf("after concurrent advances the clock is at %v, want -50m", got)
+	}
+}
+
+func TestCalculatorFollowsClock(t *testing.T) {
+	clock := NewVirtualClock(testNow)
+	calc := NewQueueCalculator(clock, DefaultConfig())
+
+	// COMP1 (3m) was served 17 minutes ago, inside the 30 minute lookback
+	if got, want := calc.CalculateQueueStats(sampleQueue()).AverageProcessTime, (3*time.Minute+5*time.Minute+2*time.Minute)/3; got != want {
+		t.Errorf("AverageProcessTime now = %v, want %v", got, want)
+	}
+
+	// A quarter of an hour later it has left the window
+	clock.Advance(15 * time.Minute)
+	if got, want := calc.CalculateQueueStats(sampleQueue()).AverageProcessTime, (5*time.Minute+2*time.Minute)/2; got != want {
+		t.Errorf("AverageProcessTime 15m later = %v, want %v", got, want)
+	}
+}
+
+func TestAlertsFollowClock(t *testing.T) {
+	clock := NewVirtualClock(testNow)
+	graph := newFakeGraphAPI(t)
+	alerts := NewAlertSystem(clock, graph.config(), DefaultConfig())
+	alert := func() AlertRequest {
+		return AlertRequest{EntryID: "E1", MSISDN: "27601234567", Channel: "whatsapp", QueueID: "Q1", Timestamp: clock.Now()}
+	}
+
+	if outcome := alerts.SendAlert(alert()); outcome != alertOutcomeSent {
+		t.Fatalf("first alert: %s", outcome)
+	}
+	if got := alerts.GetAlertStats()["alerts_24h"]; got != 1 {
+		t.Errorf("alerts_24h = %d, want 1", got)
+	}
+
+	// No real time passes, only the virtual clock's
+	clock.Advance(4*time.Minute + 59*time.Second)
+	if outcome := alerts.SendAlert(alert()); outcome != alertOutcomeRateLimited {
+		t.Errorf("just inside the window: %s, want rate limited", outcome)
+	}
+	clock.Advance(time.Second)
+	if outcome := alerts.SendAlert(alert()); outcome != alertOutcomeSent {
+		t.Errorf("at the end of the window: %s, want sent", outcome)
+	}
+
+	clock.Advance(25 * time.Hour)
+	if got := alerts.GetAlertStats()["alerts_24h"]; got != 0 {
+		t.Errorf("alerts_24h a day later = %d, want 0", got)
+	}
+}
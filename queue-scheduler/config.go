@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults for the settings in Config
+const (
+	defaultPollInterval    = 60 * time.Second
+	defaultLookbackWindow  = 30 * time.Minute
+	defaultRateLimitWindow = 5 * time.Minute
+)
+
+// Lower bounds enforced by Config.Validate
+const (
+	minPollInterval   = time.Second
+	minLookbackWindow = time.Minute
+)
+
+// Config holds how often queues are polled, how far back service history is
+// looked at and how often a customer may get the same kind of message, with
+// optional per-queue overrides, along with the alert thresholds, message
+// templates and WhatsApp endpoint and token. It is built by LoadConfig from
+// defaults, a YAML file, the environment and command line flags, each
+// overriding the last, and can be reloaded while running (see
+// Scheduler.Reload).
+type Config struct {
+	PollInterval    time.Duration          `yaml:"poll_interval"`
+	LookbackWindow  time.Duration          `yaml:"lookback_window"`
+	RateLimitWindow time.Duration          `yaml:"rate_limit_window"`
+	Queues          map[string]QueueConfig `yaml:"queues"`
+
+	// Timezone, OpeningHours, Holidays and QuietHours are the defaults for
+	// every queue's hours (see hours.go)
+	Timezone     Timezone    `yaml:"timezone"`
+	OpeningHours WeeklyHours `yaml:"opening_hours"`
+	Holidays     []string    `yaml:"holidays"`
+	QuietHours   *TimeRange  `yaml:"quiet_hours"`
+
+	// AdaptivePolling lets each queue's poll interval follow its activity
+	AdaptivePolling AdaptivePolling `yaml:"adaptive_polling"`
+
+	// SwitchMinSaving, AppointmentReminder and AppointmentGrace are the
+	// faster queue, reminder and no-show thresholds; zero disables the
+	// first two
+	SwitchMinSaving     time.Duration `yaml:"switch_min_saving"`
+	AppointmentReminder time.Duration `yaml:"appointment_reminder"`
+	AppointmentGrace    time.Duration `yaml:"appointment_grace"`
+
+	// DailyMessageCap is how many messages a phone number may get in any
+	// 24 hours; zero is unlimited
+	DailyMessageCap int `yaml:"daily_message_cap"`
+
+	// Templates override message templates by kind
+	Templates map[string]string `yaml:"templates"`
+
+	// WhatsAppAPIURL overrides the messages endpoint the alert system was
+	// created with
+	WhatsAppAPIURL string `yaml:"whatsapp_api_url"`
+
+	// WhatsAppTokenFile names a file holding the WhatsApp access token, such
+	// as a mounted secret. The token itself is never read from the config
+	// file.
+	WhatsAppTokenFile string `yaml:"whatsapp_token_file"`
+
+	// WhatsAppAccessToken is read from WhatsAppTokenFile, or else from
+	// WHATSAPP_ACCESS_TOKEN, whenever the configuration is loaded, so a
+	// reload picks up a rotated token
+	WhatsAppAccessToken string `yaml:"-"`
+}
+
+// QueueConfig overrides settings for one queue. Zero fields inherit the
+// global setting; holidays add to the global ones.
+type QueueConfig struct {
+	PollInterval    time.Duration `yaml:"poll_interval"`
+	LookbackWindow  time.Duration `yaml:"lookback_window"`
+	RateLimitWindow time.Duration `yaml:"rate_limit_window"`
+
+	Timezone     Timezone    `yaml:"timezone"`
+	OpeningHours WeeklyHours `yaml:"opening_hours"`
+	Holidays     []string    `yaml:"holidays"`
+	QuietHours   *TimeRange  `yaml:"quiet_hours"`
+}
+
+// AdaptivePolling bounds poll intervals that shorten while a queue is busy
+// and back off while it is empty (see adaptive.go). Disabled while both are
+// zero.
+type AdaptivePolling struct {
+	MinInterval time.Duration `yaml:"min_interval"`
+	MaxInterval time.Duration `yaml:"max_interval"`
+}
+
+// Enabled reports whether poll intervals adapt to activity
+func (a AdaptivePolling) Enabled() bool {
+	return a.MinInterval > 0 || a.MaxInterval > 0
+}
+
+// DefaultConfig returns the settings used when nothing is configured
+func DefaultConfig() Config {
+	return Config{
+		PollInterval:        defaultPollInterval,
+		LookbackWindow:      defaultLookbackWindow,
+		RateLimitWindow:     defaultRateLimitWindow,
+		SwitchMinSaving:     defaultSwitchMinSaving,
+		AppointmentReminder: defaultAppointmentReminder,
+		AppointmentGrace:    defaultAppointmentGrace,
+	}
+}
+
+// Queue returns the settings for a queue, its overrides applied
+func (c Config) Queue(queueID string) QueueConfig {
+	resolved := QueueConfig{
+		PollInterval:    c.PollInterval,
+		LookbackWindow:  c.LookbackWindow,
+		RateLimitWindow: c.RateLimitWindow,
+		Timezone:        c.Timezone,
+		OpeningHours:    c.OpeningHours,
+		Holidays:        c.Holidays,
+		QuietHours:      c.QuietHours,
+	}
+
+	override := c.Queues[queueID]
+	if override.PollInterval > 0 {
+		resolved.PollInterval = override.PollInterval
+	}
+	if override.LookbackWindow > 0 {
+		resolved.LookbackWindow = override.LookbackWindow
+	}
+	if override.RateLimitWindow > 0 {
+		resolved.RateLimitWindow = override.RateLimitWindow
+	}
+	if override.Timezone.Location != nil {
+		resolved.Timezone = override.Timezone
+	}
+	if override.OpeningHours != nil {
+		resolved.OpeningHours = override.OpeningHours
+	}
+	if len(override.Holidays) > 0 {
+		resolved.Holidays = append(append([]string(nil), c.Holidays...), override.Holidays...)
+	}
+	if override.QuietHours != nil {
+		resolved.QuietHours = override.QuietHours
+	}
+	return resolved
+}
+
+// MinPollInterval returns the shortest poll interval of any queue, adaptive
+// ones included, which is how often the scheduler wakes up
+func (c Config) MinPollInterval() time.Duration {
+	interval := c.PollInterval
+	if c.AdaptivePolling.Enabled() {
+		interval = min(interval, c.AdaptivePolling.MinInterval)
+	}
+	for _, override := range c.Queues {
+		if override.PollInterval > 0 && override.PollInterval < interval {
+			interval = override.PollInterval
+		}
+	}
+	return interval
+}
+
+// MaxPollInterval returns the longest poll interval of any queue, adaptive
+// ones included, the longest the source goes unfetched
+func (c Config) MaxPollInterval() time.Duration {
+	interval := c.PollInterval
+	if c.AdaptivePolling.Enabled() {
+		interval = max(interval, c.AdaptivePolling.MaxInterval)
+	}
+	for _, override := range c.Queues {
+		interval = max(interval, override.PollInterval)
+	}
+	return interval
+}
+
+// MaxRateLimitWindow returns the longest rate limit window of any queue, how
+// long sent alerts must be remembered
+func (c Config) MaxRateLimitWindow() time.Duration {
+	window := c.RateLimitWindow
+	for _, override := range c.Queues {
+		window = max(window, override.RateLimitWindow)
+	}
+	return window
+}
+
+// Validate reports every setting out of bounds
+func (c Config) Validate() error {
+	var errs []error
+	check := func(scope string, settings QueueConfig, inherit bool) {
+		// Overrides may leave a setting zero to inherit it
+		if settings.PollInterval != 0 || !inherit {
+			if settings.PollInterval < minPollInterval {
+				errs = append(errs, fmt.Errorf("%spoll_interval %v is below %v", scope, settings.PollInterval, minPollInterval))
+			}
+		}
+		if settings.LookbackWindow != 0 || !inherit {
+			if settings.LookbackWindow < minLookbackWindow {
+				errs = append(errs, fmt.Errorf("%slookback_window %v is below %v", scope, settings.LookbackWindow, minLookbackWindow))
+			}
+		}
+		if settings.RateLimitWindow < 0 {
+			errs = append(errs, fmt.Errorf("%srate_limit_window %v is negative", scope, settings.RateLimitWindow))
+		}
+	}
+
+	check("", QueueConfig{
+		PollInterval:    c.PollInterval,
+		LookbackWindow:  c.LookbackWindow,
+		RateLimitWindow: c.RateLimitWindow,
+	}, false)
+	errs = append(errs, validateHolidays("", c.Holidays)...)
+
+	queueIDs := make([]string, 0, len(c.Queues))
+	for queueID := range c.Queues {
+		queueIDs = append(queueIDs, queueID)
+	}
+	sort.Strings(queueIDs)
+	for _, queueID := range queueIDs {
+		if queueID == "" {
+			errs = append(errs, errors.New("queue override with an empty queue ID"))
+			continue
+		}
+		check("queue "+queueID+": ", c.Queues[queueID], true)
+		errs = append(errs, validateHolidays("queue "+queueID+": ", c.Queues[queueID].Holidays)...)
+	}
+
+	if adaptive := c.AdaptivePolling; adaptive.Enabled() {
+		if adaptive.MinInterval < minPollInterval {
+			errs = append(errs, fmt.Errorf("adaptive_polling.min_interval %v is below %v", adaptive.MinInterval, minPollInterval))
+		}
+		if adaptive.MaxInterval < adaptive.MinInterval {
+			errs = append(errs, fmt.Errorf("adaptive_polling.max_interval %v is below min_interval %v", adaptive.MaxInterval, adaptive.MinInterval))
+		}
+	}
+
+	thresholds := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"switch_min_saving", c.SwitchMinSaving},
+		{"appointment_reminder", c.AppointmentReminder},
+		{"appointment_grace", c.AppointmentGrace},
+	}
+	for _, threshold := range thresholds {
+		if threshold.value < 0 {
+			errs = append(errs, fmt.Errorf("%s %v is negative", threshold.name, threshold.value))
+		}
+	}
+
+	if c.DailyMessageCap < 0 {
+		errs = append(errs, fmt.Errorf("daily_message_cap %d is negative", c.DailyMessageCap))
+	}
+
+	if _, err := NewMessageTemplates(c.Templates); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.WhatsAppAPIURL != "" {
+		u, err := url.Parse(c.WhatsAppAPIURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("whatsapp_api_url %q is not an http(s) URL", c.WhatsAppAPIURL))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// configEnv maps environment variables to the global settings they override
+var configEnv = []struct {
+	name  string
+	field func(*Config) *time.Duration
+}{
+	{"POLL_INTERVAL", func(c *Config) *time.Duration { return &c.PollInterval }},
+	{"LOOKBACK_WINDOW", func(c *Config) *time.Duration { return &c.LookbackWindow }},
+	{"RATE_LIMIT_WINDOW", func(c *Config) *time.Duration { return &c.RateLimitWindow }},
+	{"ADAPTIVE_POLL_MIN", func(c *Config) *time.Duration { return &c.AdaptivePolling.MinInterval }},
+	{"ADAPTIVE_POLL_MAX", func(c *Config) *time.Duration { return &c.AdaptivePolling.MaxInterval }},
+	{"SWITCH_MIN_SAVING", func(c *Config) *time.Duration { return &c.SwitchMinSaving }},
+	{"APPOINTMENT_REMINDER", func(c *Config) *time.Duration { return &c.AppointmentReminder }},
+	{"APPOINTMENT_GRACE", func(c *Config) *time.Duration { return &c.AppointmentGrace }},
+}
+
+// configFlags maps command line flags to the global settings they override
+var configFlags = []struct {
+	name  string
+	usage string
+	field func(*Config) *time.Duration
+}{
+	{"poll-interval", "How often queues are polled (default 60s)", func(c *Config) *time.Duration { return &c.PollInterval }},
+	{"lookback-window", "How far back service times are looked at (default 30m)", func(c *Config) *time.Duration { return &c.LookbackWindow }},
+	{"rate-limit-window", "Minimum time between messages of the same kind to a customer (default 5m)", func(c *Config) *time.Duration { return &c.RateLimitWindow }},
+}
+
+// RegisterConfigFlags adds the -config file flag and a flag per global
+// setting to flags. Unset flags leave the setting to the file and
+// environment.
+func RegisterConfigFlags(flags *flag.FlagSet) {
+	flags.String("config", getEnv("CONFIG_FILE", ""), "YAML file with scheduler settings and per-queue overrides (default $CONFIG_FILE)")
+	for _, f := range configFlags {
+		flags.Duration(f.name, 0, f.usage)
+	}
+}
+
+// LoadConfig builds the configuration: defaults, then the YAML file named by
+// the -config flag or CONFIG_FILE, then environment variables, then flags
+// set on the command line. flags may be nil, or must have been registered
+// with RegisterConfigFlags and parsed.
+func LoadConfig(flags *flag.FlagSet) (Config, error) {
+	config := DefaultConfig()
+
+	path := getEnv("CONFIG_FILE", "")
+	if flags != nil {
+		path = flags.Lookup("config").Value.String()
+	}
+	if path != "" {
+		if err := config.loadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, env := range configEnv {
+		value, ok := os.LookupEnv(env.name)
+		if !ok || value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", env.name, err)
+		}
+		*env.field(&config) = duration
+	}
+	if apiURL := getEnv("WHATSAPP_API_URL", ""); apiURL != "" {
+		config.WhatsAppAPIURL = apiURL
+	}
+	if tokenFile := getEnv("WHATSAPP_ACCESS_TOKEN_FILE", ""); tokenFile != "" {
+		config.WhatsAppTokenFile = tokenFile
+	}
+	config.WhatsAppAccessToken = getEnv("WHATSAPP_ACCESS_TOKEN", "")
+	if config.WhatsAppTokenFile != "" {
+		token, err := os.ReadFile(config.WhatsAppTokenFile)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to read WhatsApp token file: %w", err)
+		}
+		if config.WhatsAppAccessToken = strings.TrimSpace(string(token)); config.WhatsAppAccessToken == "" {
+			return Config{}, fmt.Errorf("WhatsApp token file %s is empty", config.WhatsAppTokenFile)
+		}
+	}
+	if value := getEnv("DAILY_MESSAGE_CAP", ""); value != "" {
+		daily, err := strconv.Atoi(value)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid DAILY_MESSAGE_CAP: %w", err)
+		}
+		config.DailyMessageCap = daily
+	}
+
+	if flags != nil {
+		set := make(map[string]bool)
+		flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		for _, f := range configFlags {
+			if set[f.name] {
+				*f.field(&config) = flags.Lookup(f.name).Value.(flag.Getter).Get().(time.Duration)
+			}
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return config, nil
+}
+
+// loadFile overrides the settings present in a YAML file. Unknown keys are
+// rejected so typos don't go unnoticed.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// settings flattens the configuration into named values, leaving out unset
+// overrides, so two configurations can be compared
+func (c Config) settings() map[string]string {
+	settings := map[string]string{
+		"poll_interval":        c.PollInterval.String(),
+		"lookback_window":      c.LookbackWindow.String(),
+		"rate_limit_window":    c.RateLimitWindow.String(),
+		"switch_min_saving":    c.SwitchMinSaving.String(),
+		"appointment_reminder": c.AppointmentReminder.String(),
+		"appointment_grace":    c.AppointmentGrace.String(),
+	}
+	if c.WhatsAppAPIURL != "" {
+		settings["whatsapp_api_url"] = c.WhatsAppAPIURL
+	}
+	if c.WhatsAppTokenFile != "" {
+		settings["whatsapp_token_file"] = c.WhatsAppTokenFile
+	}
+	if c.WhatsAppAccessToken != "" {
+		settings["whatsapp_access_token"] = redactToken(c.WhatsAppAccessToken)
+	}
+	if c.DailyMessageCap != 0 {
+		settings["daily_message_cap"] = strconv.Itoa(c.DailyMessageCap)
+	}
+	addHours(settings, "", QueueConfig{
+		Timezone:     c.Timezone,
+		OpeningHours: c.OpeningHours,
+		Holidays:     c.Holidays,
+		QuietHours:   c.QuietHours,
+	})
+	if c.AdaptivePolling.Enabled() {
+		settings["adaptive_polling.min_interval"] = c.AdaptivePolling.MinInterval.String()
+		settings["adaptive_polling.max_interval"] = c.AdaptivePolling.MaxInterval.String()
+	}
+	for queueID, override := range c.Queues {
+		prefix := "queues." + queueID + "."
+		if override.PollInterval != 0 {
+			settings[prefix+"poll_interval"] = override.PollInterval.String()
+		}
+		if override.LookbackWindow != 0 {
+			settings[prefix+"lookback_window"] = override.LookbackWindow.String()
+		}
+		if override.RateLimitWindow != 0 {
+			settings[prefix+"rate_limit_window"] = override.RateLimitWindow.String()
+		}
+		addHours(settings, prefix, override)
+	}
+	for kind, text := range c.Templates {
+		settings["templates."+kind] = strconv.Quote(text)
+	}
+	return settings
+}
+
+// redactToken stands in for a secret in logs: a short fingerprint that shows
+// whether it changed without revealing it
+func redactToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("(redacted, sha256 %x)", sum[:4])
+}
+
+// addHours adds the hours settings that are set to settings, under prefix
+func addHours(settings map[string]string, prefix string, hours QueueConfig) {
+	if hours.Timezone.Location != nil {
+		settings[prefix+"timezone"] = hours.Timezone.String()
+	}
+	if hours.OpeningHours != nil {
+		settings[prefix+"opening_hours"] = hours.OpeningHours.String()
+	}
+	if len(hours.Holidays) > 0 {
+		settings[prefix+"holidays"] = strings.Join(hours.Holidays, ", ")
+	}
+	if hours.QuietHours != nil {
+		settings[prefix+"quiet_hours"] = hours.QuietHours.String()
+	}
+}
+
+// Changes describes every setting that differs from old, e.g.
+// "queues.ABC123.poll_interval: 30s -> 15s", sorted by name
+func (c Config) Changes(old Config) []string {
+	before, after := old.settings(), c.settings()
+
+	names := make(map[string]bool)
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+
+	var changes []string
+	for name := range names {
+		was, wasSet := before[name]
+		now, isSet := after[name]
+		if was == now && wasSet == isSet {
+			continue
+		}
+		if !wasSet {
+			was = "(unset)"
+		}
+		if !isSet {
+			now = "(unset)"
+		}
+		changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, was, now))
+	}
+	sort.Strings(changes)
+	return changes
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "scheduler.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+poll_interval: 30s
+lookback_window: 45m
+rate_limit_window: 2m
+queues:
+  ABC123:
+    poll_interval: 10s
+    rate_limit_window: 15m
+  XYZ789:
+    lookback_window: 2h
+`)
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("POLL_INTERVAL", "")
+	t.Setenv("LOOKBACK_WINDOW", "40m")
+	t.Setenv("RATE_LIMIT_WINDOW", "3m")
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterConfigFlags(flags)
+	if err := flags.Parse([]string{"-config", path, "-rate-limit-window", "4m"}); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	config, err := LoadConfig(flags)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	// The file sets the poll interval, the environment the lookback window
+	// and the flag, overriding both, the rate limit window
+	tests := []struct {
+		queueID string
+		want    QueueConfig
+	}{
+		{"OTHER", QueueConfig{PollInterval: 30 * time.Second, LookbackWindow: 40 * time.Minute, RateLimitWindow: 4 * time.Minute}},
+		{"ABC123", QueueConfig{PollInterval: 10 * time.Second, LookbackWindow: 40 * time.Minute, RateLimitWindow: 15 * time.Minute}},
+		{"XYZ789", QueueConfig{PollInterval: 30 * time.Second, LookbackWindow: 2 * time.Hour, RateLimitWindow: 4 * time.Minute}},
+	}
+	for _, tt := range tests {
+		if got := config.Queue(tt.queueID); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Queue(%s) = %+v, want %+v", tt.queueID, got, tt.want)
+		}
+	}
+	if got := config.MinPollInterval(); got != 10*time.Second {
+		t.Errorf("MinPollInterval = %v, want 10s", got)
+	}
+	if got := config.MaxPollInterval(); got != 30*time.Second {
+		t.Errorf("MaxPollInterval = %v, want 30s", got)
+	}
+	if got := config.MaxRateLimitWindow(); got != 15*time.Minute {
+		t.Errorf("MaxRateLimitWindow = %v, want 15m", got)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	for _, name := range []string{"CONFIG_FILE", "POLL_INTERVAL", "LOOKBACK_WINDOW", "RATE_LIMIT_WINDOW"} {
+		t.Setenv(name, "")
+	}
+
+	config, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	want := QueueConfig{PollInterval: time.Minute, LookbackWindow: 30 * time.Minute, RateLimitWindow: 5 * time.Minute}
+	if got := config.Queue("ANY"); !reflect.DeepEqual(got, want) {
+		t.Errorf("defaults = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		wantErr string
+	}{
+		{
+			name:    "unknown key",
+			file:    "poll_intervall: 30s\n",
+			wantErr: "field poll_intervall not found",
+		},
+		{
+			name:    "not a duration",
+			file:    "lookback_window: 30\n",
+			wantErr: "failed to parse config file",
+		},
+		{
+			name:    "bad environment",
+			env:     map[string]string{"POLL_INTERVAL": "soon"},
+			wantErr: "invalid POLL_INTERVAL",
+		},
+		{
+			name:    "global bounds",
+			file:    "poll_interval: 100ms\nlookback_window: 10s\nrate_limit_window: -1m\n",
+			wantErr: "poll_interval 100ms is below 1s",
+		},
+		{
+			name:    "override bounds",
+			file:    "queues:\n  ABC123:\n    lookback_window: 30s\n",
+			wantErr: "queue ABC123: lookback_window 30s is below 1m0s",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"CONFIG_FILE", "POLL_INTERVAL", "LOOKBACK_WINDOW", "RATE_LIMIT_WINDOW"} {
+				t.Setenv(name, tt.env[name])
+			}
+			if tt.file != "" {
+				t.Setenv("CONFIG_FILE", writeConfigFile(t, tt.file))
+			}
+
+			_, err := LoadConfig(nil)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadConfig error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	config := DefaultConfig()
+	config.PollInterval = 0
+	config.RateLimitWindow = -time.Minute
+	config.Queues = map[string]QueueConfig{"ABC123": {PollInterval: -time.Second}}
+
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("Validate succeeded, want errors")
+	}
+	for _, want := range []string{"poll_interval 0s", "rate_limit_window -1m0s is negative", "queue ABC123: poll_interval -1s"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate error %q doesn't mention %q", err, want)
+		}
+	}
+}
+
+func TestPerQueueOverrides(t *testing.T) {
+	config := DefaultConfig()
+	config.Queues = map[string]QueueConfig{
+		"SLOW": {PollInterval: 5 * time.Minute, LookbackWindow: 2 * time.Hour, RateLimitWindow: 20 * time.Minute},
+	}
+
+	// An hour old service only counts with SLOW's longer lookback
+	history := []QueueEntry{servedEntry("OLD", time.Hour, 4*time.Minute), waitingEntry("W1", time.Minute)}
+	calc := NewQueueCalculator(NewVirtualClock(testNow), config)
+	if got := calc.recentServiceTimes(Queue{QueueID: "SLOW", Entries: history}, testNow); len(got) != 1 {
+		t.Errorf("SLOW service times = %v, want the hour old one", got)
+	}
+	if got := calc.recentServiceTimes(Queue{QueueID: "FAST", Entries: history}, testNow); len(got) != 0 {
+		t.Errorf("FAST service times = %v, want none within 30 minutes", got)
+	}
+
+	// SLOW is only polled every five minutes; FAST at every tick
+	fast := Queue{QueueID: "FAST", Name: "Fast", Entries: []QueueEntry{waitingEntry("F1", time.Minute)}}
+	slow := Queue{QueueID: "SLOW", Name: "Slow", Entries: []QueueEntry{waitingEntry("S1", time.Minute)}}
+	django := newFakeDjango(t, fast, slow)
+	graph := newFakeGraphAPI(t)
+	scheduler, clock := newConfiguredTestScheduler(t, django, graph, config)
+
+	scheduler.processQueues()
+	first := scheduler.lastPolled["SLOW"]
+	for range 4 {
+		clock.Advance(time.Minute)
+		scheduler.processQueues()
+		if !scheduler.lastPolled["SLOW"].Equal(first) {
+			t.Fatalf("SLOW polled again after %v", clock.Now().Sub(testNow))
+		}
+	}
+	if !scheduler.lastPolled["FAST"].Equal(clock.Now()) {
+		t.Errorf("FAST last polled %v, want every tick", scheduler.lastPolled["FAST"])
+	}
+	clock.Advance(time.Minute)
+	scheduler.processQueues()
+	if !scheduler.lastPolled["SLOW"].Equal(clock.Now()) {
+		t.Errorf("SLOW last polled %v, want after five minutes", scheduler.lastPolled["SLOW"])
+	}
+
+	// S1's next message is held back by SLOW's 20 minute rate limit window
+	alerts := scheduler.alerter
+	alert := AlertRequest{EntryID: "S1", MSISDN: "2760S1", QueueID: "SLOW", Channel: "whatsapp", Message: "hi"}
+	clock.Advance(10 * time.Minute)
+	alert.Timestamp = clock.Now()
+	if outcome := alerts.SendAlert(alert); outcome != alertOutcomeRateLimited {
+		t.Errorf("SLOW alert after 15 minutes = %s, want rate limited", outcome)
+	}
+	alert.EntryID, alert.MSISDN, alert.QueueID = "F1", "2760F1", "FAST"
+	if outcome := alerts.SendAlert(alert); outcome != alertOutcomeSent {
+		t.Errorf("FAST alert 10 minutes after its last = %s, want sent", outcome)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// outlierFence is how many interquartile ranges above the upper quartile a
+// service time may lie before it is treated as a ticket someone forgot to
+// close
+const outlierFence = 3.0
+
+// ServiceTimeEstimator turns recent service times, oldest first, into the
+// typical service time used for wait estimates. It is only called with at
+// least one sample.
+type ServiceTimeEstimator interface {
+	Name() string
+	Estimate(samples []time.Duration) time.Duration
+}
+
+// MeanEstimator is the plain average
+type MeanEstimator struct{}
+
+func (MeanEstimator) Name() string { return "mean" }
+
+func (MeanEstimator) Estimate(samples []time.Duration) time.Duration {
+	var total time.Duration
+	for _, sample := range samples {
+		total += sample
+	}
+	return total / time.Duration(len(samples))
+}
+
+// EWMAEstimator weights recent services more heavily. Alpha (0-1] is the
+// weight of each new sample.
+type EWMAEstimator struct {
+	Alpha float64
+}
+
+func (e EWMAEstimator) Name() string { return fmt.Sprintf("ewma:%g", e.Alpha) }
+
+func (e EWMAEstimator) Estimate(samples []time.Duration) time.Duration {
+	average := float64(samples[0])
+	for _, sample := range samples[1:] {
+		average = e.Alpha*float64(sample) + (1-e.Alpha)*average
+	}
+	return time.Duration(average)
+}
+
+// TrimmedMeanEstimator averages after dropping the Trim fraction of samples
+// from each end
+type TrimmedMeanEstimator struct {
+	Trim float64
+}
+
+func (e TrimmedMeanEstimator) Name() string { return fmt.Sprintf("trimmed:%g", e.Trim) }
+
+func (e TrimmedMeanEstimator) Estimate(samples []time.Duration) time.Duration {
+	sorted := sortedDurations(samples)
+	cut := int(e.Trim * float64(len(sorted)))
+	if 2*cut >= len(sorted) {
+		cut = (len(sorted) - 1) / 2
+	}
+	return MeanEstimator{}.Estimate(sorted[cut : len(sorted)-cut])
+}
+
+// MedianEstimator is the middle service time
+type MedianEstimator struct{}
+
+func (MedianEstimator) Name() string { return "median" }
+
+func (MedianEstimator) Estimate(samples []time.Duration) time.Duration {
+	sorted := sortedDurations(samples)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// ParseServiceTimeEstimator parses "mean", "median", "ewma[:alpha]" or
+// "trimmed[:fraction]"
+func ParseServiceTimeEstimator(spec string) (ServiceTimeEstimator, error) {
+	name, param, hasParam := strings.Cut(strings.TrimSpace(spec), ":")
+
+	value := func(def, min, max float64) (float64, error) {
+		if !hasParam {
+			return def, nil
+		}
+		v, err := strconv.ParseFloat(param, 64)
+		if err != nil || v <= min || v > max {
+			return 0, fmt.Errorf("invalid %s parameter %q", name, param)
+		}
+		return v, nil
+	}
+
+	switch name {
+	case "mean":
+		return MeanEstimator{}, nil
+	case "median":
+		return MedianEstimator{}, nil
+	case "ewma":
+		alpha, err := value(0.3, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		return EWMAEstimator{Alpha: alpha}, nil
+	case "trimmed":
+		trim, err := value(0.1, 0, 0.5)
+		if err != nil {
+			return nil, err
+		}
+		return TrimmedMeanEstimator{Trim: trim}, nil
+	default:
+		return nil, fmt.Errorf("unknown service time estimator %q", spec)
+	}
+}
+
+// ServicePrior is the assumed service time for a queue before it has
+// history. Weight is how many observed services the prior counts as once
+// history arrives; zero means it is only used with no history at all.
+type ServicePrior struct {
+	Mean   time.Duration
+	Weight int
+}
+
+// defaultServicePrior applies to queues without a configured prior
+var defaultServicePrior = ServicePrior{Mean: 5 * time.Minute}
+
+// blend combines an estimate from n samples with the prior
+func (p ServicePrior) blend(estimate time.Duration, n int) time.Duration {
+	if n == 0 {
+		return p.Mean
+	}
+	if p.Weight <= 0 {
+		return estimate
+	}
+	total := time.Duration(p.Weight)*p.Mean + time.Duration(n)*estimate
+	return total / time.Duration(p.Weight+n)
+}
+
+// rejectOutliers drops service times far above the rest, using a Tukey fence
+// of outlierFence interquartile ranges above the upper quartile (and never
+// below twice the upper quartile). With fewer than four samples there is too
+// little data to tell, so all are kept. Order is preserved.
+func rejectOutliers(samples []time.Duration) []time.Duration {
+	if len(samples) < 4 {
+		return samples
+	}
+
+	sorted := sortedDurations(samples)
+	q1 := percentile(sorted, 0.25)
+	q3 := percentile(sorted, 0.75)
+	fence := q3 + time.Duration(outlierFence*float64(q3-q1))
+	if fence < 2*q3 {
+		fence = 2 * q3 // identical samples shouldn't make every small deviation an outlier
+	}
+
+	kept := make([]time.Duration, 0, len(samples))
+	for _, sample := range samples {
+		if sample <= fence {
+			kept = append(kept, sample)
+		}
+	}
+	return kept
+}
+
+// sortedDurations returns a sorted copy
+func sortedDurations(values []time.Duration) []time.Duration {
+	sorted := append([]time.Duration(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
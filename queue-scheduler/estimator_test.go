@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServiceTimeEstimators(t *testing.T) {
+	// Oldest first: services getting longer
+	samples := []time.Duration{2 * time.Minute, 4 * time.Minute, 6 * time.Minute, 8 * time.Minute, 20 * time.Minute}
+
+	tests := []struct {
+		spec string
+		want time.Duration
+	}{
+		{"mean", 8 * time.Minute},
+		{"median", 6 * time.Minute},
+		{"trimmed:0.2", 6 * time.Minute},
+		{"ewma:0.5", 13*time.Minute + 7500*time.Millisecond}, // 2, 3, 4.5, 6.25, 13.125
+		{"ewma:1", 20 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			estimator, err := ParseServiceTimeEstimator(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseServiceTimeEstimator: %v", err)
+			}
+			if got := estimator.Estimate(samples); got != tt.want {
+				t.Errorf("%s estimate = %v, want %v", estimator.Name(), got, tt.want)
+			}
+		})
+	}
+
+	if got := (MedianEstimator{}).Estimate(samples[:4]); got != 5*time.Minute {
+		t.Errorf("even median = %v, want 5m", got)
+	}
+	if got := (TrimmedMeanEstimator{Trim: 0.4}).Estimate(samples[:2]); got != 3*time.Minute {
+		t.Errorf("over-trimmed mean = %v, want 3m", got)
+	}
+
+	for _, spec := range []string{"mode", "ewma:0", "ewma:1.5", "trimmed:0.6", "trimmed:x"} {
+		if _, err := ParseServiceTimeEstimator(spec); err == nil {
+			t.Errorf("ParseServiceTimeEstimator(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestRejectOutliers(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		want    int
+	}{
+		{"too few to judge", []time.Duration{3, 4, 300}, 3},
+		{"stuck ticket", []time.Duration{3 * time.Minute, 4 * time.Minute, 5 * time.Minute, 4 * time.Minute, 3 * time.Hour}, 4},
+		{"identical with small deviation", []time.Duration{5 * time.Minute, 5 * time.Minute, 5 * time.Minute, 5 * time.Minute, 6 * time.Minute}, 5},
+		{"wide but plausible", []time.Duration{2 * time.Minute, 5 * time.Minute, 9 * time.Minute, 14 * time.Minute}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rejectOutliers(tt.samples); len(got) != tt.want {
+				t.Errorf("kept %v, want %d samples", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStuckTicketDoesNotInflateAverage(t *testing.T) {
+	queue := Queue{QueueID: "Q1", Entries: []QueueEntry{
+		servedEntry("S1", 25*time.Minute, 4*time.Minute),
+		servedEntry("S2", 20*time.Minute, 4*time.Minute),
+		servedEntry("S3", 15*time.Minute, 4*time.Minute),
+		servedEntry("STUCK", 10*time.Minute, 3*time.Hour),
+		servedEntry("S4", 5*time.Minute, 4*time.Minute),
+	}}
+
+	calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+	stats := calc.CalculateQueueStats(queue)
+	if stats.AverageProcessTime != 4*time.Minute {
+		t.Errorf("AverageProcessTime = %v, want 4m", stats.AverageProcessTime)
+	}
+	if stats.ServiceTimeP90 != 4*time.Minute {
+		t.Errorf("ServiceTimeP90 = %v, want 4m", stats.ServiceTimeP90)
+	}
+}
+
+func TestServicePriors(t *testing.T) {
+	calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+	calc.SetPrior("COLD", ServicePrior{Mean: 8 * time.Minute})
+	calc.SetPrior("BLEND", ServicePrior{Mean: 8 * time.Minute, Weight: 3})
+
+	history := []QueueEntry{servedEntry("S1", 5*time.Minute, 4*time.Minute)}
+
+	tests := []struct {
+		queueID string
+		entries []QueueEntry
+		want    time.Duration
+	}{
+		{"COLD", nil, 8 * time.Minute},
+		{"COLD", history, 4 * time.Minute},
+		{"BLEND", history, 7 * time.Minute}, // (3×8 + 1×4) / 4
+		{"OTHER", nil, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		stats := calc.CalculateQueueStats(Queue{QueueID: tt.queueID, Entries: tt.entries})
+		if stats.AverageProcessTime != tt.want {
+			t.Errorf("%s with %d services: AverageProcessTime = %v, want %v",
+				tt.queueID, len(tt.entries), stats.AverageProcessTime, tt.want)
+		}
+	}
+}
+
+func TestParseServicePriors(t *testing.T) {
+	priors := parseServicePriors("A=4m, B=90s/5,C=soon,D=-1m,E=3m/x")
+
+	if len(priors) != 2 {
+		t.Fatalf("priors = %v, want A and B only", priors)
+	}
+	if priors["A"] != (ServicePrior{Mean: 4 * time.Minute}) {
+		t.Errorf("A = %+v", priors["A"])
+	}
+	if priors["B"] != (ServicePrior{Mean: 90 * time.Second, Weight: 5}) {
+		t.Errorf("B = %+v", priors["B"])
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// forecastHistory is how far back arrival rates are learned from
+	forecastHistory = 28 * 24 * time.Hour
+
+	// forecastHorizon is how many hours ahead the scheduler forecasts
+	forecastHorizon = 12
+)
+
+// HourForecast is the outlook for one hour of a queue
+type HourForecast struct {
+	Start          time.Time `json:"start"`
+	Arrivals       float64   `json:"arrivals"`        // expected customers joining during the hour
+	ServiceMinutes float64   `json:"service_minutes"` // expected service time per customer
+	Capacity       float64   `json:"capacity"`        // customers the counters can serve in the hour
+	QueueLength    float64   `json:"queue_length"`    // expected customers waiting at the end of the hour
+	WaitMinutes    float64   `json:"wait_minutes"`    // expected wait for someone joining at the end of the hour
+}
+
+// QueueForecast is the outlook for a queue over the next hours
+type QueueForecast struct {
+	QueueID     string         `json:"queue_id"`
+	Name        string         `json:"name"`
+	GeneratedAt time.Time      `json:"generated_at"`
+	Servers     int            `json:"servers"`
+	Waiting     int            `json:"waiting"`
+	Hours       []HourForecast `json:"hours"`
+}
+
+// ArrivalForecaster predicts arrivals and queue length per hour. Arrival
+// rates are learned from join times over the last four weeks, per weekday
+// and hour; service comes from the calculator's estimate and profiles.
+type ArrivalForecaster struct {
+	calc     *QueueCalculator
+	history  time.Duration
+	location *time.Location
+}
+
+// NewArrivalForecaster creates a forecaster using calc's clock, estimates and
+// profiles, bucketing arrivals by weekday and hour in location
+func NewArrivalForecaster(calc *QueueCalculator, location *time.Location) *ArrivalForecaster {
+	return &ArrivalForecaster{
+		calc:     calc,
+		history:  forecastHistory,
+		location: location,
+	}
+}
+
+// arrivalRates returns the mean joins per hour in each weekday/hour slot,
+// over complete hours since the history window (or the queue's first
+// entry, if later) began
+func (f *ArrivalForecaster) arrivalRates(queue Queue, now time.Time) [7][24]float64 {
+	end := now.Truncate(time.Hour)
+	start := now.Add(-f.history)
+
+	var counts, hours [7][24]float64
+	earliest := end
+	for _, entry := range queue.Entries {
+		if entry.JoinedAt.Before(start) || !entry.JoinedAt.Before(end) {
+			continue
+		}
+		local := entry.JoinedAt.In(f.location)
+		counts[local.Weekday()][local.Hour()]++
+		if entry.JoinedAt.Before(earliest) {
+			earliest = entry.JoinedAt
+		}
+	}
+
+	for h := earliest.Truncate(time.Hour); h.Before(end); h = h.Add(time.Hour) {
+		local := h.In(f.location)
+		hours[local.Weekday()][local.Hour()]++
+	}
+
+	var rates [7][24]float64
+	for day := range rates {
+		for hour := range rates[day] {
+			if hours[day][hour] > 0 {
+				rates[day][hour] = counts[day][hour] / hours[day][hour]
+			}
+		}
+	}
+	return rates
+}
+
+// Forecast projects the queue hour by hour as a fluid: each hour the
+// expected arrivals join, and the counters serve as many as they can given
+// the expected service time (the weekday/hour profile when there is one,
+// otherwise the current estimate).
+func (f *ArrivalForecaster) Forecast(queue Queue, estimate QueueEstimate, hours int) QueueForecast {
+	now := f.calc.clock.Now()
+	rates := f.arrivalRates(queue, now)
+	stats := estimate.Stats
+
+	forecast := QueueForecast{
+		QueueID:     queue.QueueID,
+		Name:        queue.Name,
+		GeneratedAt: now,
+		Servers:     stats.Servers,
+		Waiting:     len(estimate.Positions),
+	}
+
+	waiting := float64(forecast.Waiting)
+	for i := 0; i < hours; i++ {
+		start := now.Add(time.Duration(i) * time.Hour)
+		local := start.In(f.location)
+
+		service := stats.AverageProcessTime
+		if profiled, ok := f.calc.profiles.expected(queue.QueueID, start); ok {
+			service = profiled
+		}
+
+		hour := HourForecast{
+			Start:          start,
+			Arrivals:       rates[local.Weekday()][local.Hour()],
+			ServiceMinutes: service.Minutes(),
+			Capacity:       float64(stats.Servers) * float64(time.Hour) / float64(service),
+		}
+		waiting = math.Max(0, waiting+hour.Arrivals-hour.Capacity)
+		hour.QueueLength = waiting
+		hour.WaitMinutes = waiting * service.Minutes() / float64(stats.Servers)
+
+		forecast.Hours = append(forecast.Hours, hour)
+	}
+
+	return forecast
+}
+
+// BacktestResult is the forecast error for one queue at one horizon: the
+// hour starting Hour hours after the forecast was made
+type BacktestResult struct {
+	QueueID     string
+	Hour        int
+	Samples     int
+	ArrivalMAE  float64
+	ArrivalBias float64 // mean of forecast - actual
+	LengthMAE   float64
+	LengthBias  float64
+}
+
+// timedSnapshot is a recorded snapshot with the time it was taken
+type timedSnapshot struct {
+	at       time.Time
+	response *APIResponse
+}
+
+// Backtest replays recorded snapshots, forecasting from one snapshot every
+// interval and scoring each hour of the forecast against what the recording
+// shows happened: the entries that joined during the hour, and the number
+// waiting in the last snapshot before it ended. Hours the recording doesn't
+// reach are skipped. The forecaster's calculator must run on the replay's
+// clock.
+func (f *ArrivalForecaster) Backtest(replay *ReplaySource, hours int, every time.Duration) []BacktestResult {
+	var snapshots []timedSnapshot
+	for replay.Advance() {
+		response, _ := replay.GetAllQueues()
+		snapshots = append(snapshots, timedSnapshot{at: replay.clock.Now(), response: response})
+	}
+	if len(snapshots) == 0 {
+		return nil
+	}
+	last := snapshots[len(snapshots)-1].at
+
+	// Every entry ever seen, by queue, for actual arrivals
+	joined := make(map[string]map[string]time.Time)
+	for _, snapshot := range snapshots {
+		for _, queue := range snapshot.response.Queues {
+			if joined[queue.QueueID] == nil {
+				joined[queue.QueueID] = make(map[string]time.Time)
+			}
+			for _, entry := range queue.Entries {
+				joined[queue.QueueID][entry.Key()] = entry.JoinedAt
+			}
+		}
+	}
+
+	type key struct {
+		queueID string
+		hour    int
+	}
+	results := make(map[key]*BacktestResult)
+
+	var nextOrigin time.Time
+	for _, origin := range snapshots {
+		if origin.at.Before(nextOrigin) {
+			continue
+		}
+		nextOrigin = origin.at.Add(every)
+		replay.clock.Set(origin.at)
+		estimates := f.calc.EstimateQueues(origin.response.Queues)
+
+		for _, queue := range origin.response.Queues {
+			forecast := f.Forecast(queue, estimates[queue.QueueID], hours)
+
+			for i, hour := range forecast.Hours {
+				end := hour.Start.Add(time.Hour)
+				if end.After(last) {
+					break
+				}
+
+				var arrivals float64
+				for _, at := range joined[queue.QueueID] {
+					if !at.Before(hour.Start) && at.Before(end) {
+						arrivals++
+					}
+				}
+				length := float64(waitingAt(snapshots, queue.QueueID, end))
+
+				k := key{queue.QueueID, i}
+				if results[k] == nil {
+					results[k] = &BacktestResult{QueueID: queue.QueueID, Hour: i}
+				}
+				r := results[k]
+				r.Samples++
+				r.ArrivalMAE += math.Abs(hour.Arrivals - arrivals)
+				r.ArrivalBias += hour.Arrivals - arrivals
+				r.LengthMAE += math.Abs(hour.QueueLength - length)
+				r.LengthBias += hour.QueueLength - length
+			}
+		}
+	}
+
+	var report []BacktestResult
+	for _, r := range results {
+		n := float64(r.Samples)
+		r.ArrivalMAE /= n
+		r.ArrivalBias /= n
+		r.LengthMAE /= n
+		r.LengthBias /= n
+		report = append(report, *r)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].QueueID != report[j].QueueID {
+			return report[i].QueueID < report[j].QueueID
+		}
+		return report[i].Hour < report[j].Hour
+	})
+	return report
+}
+
+// waitingAt counts the customers waiting in a queue in the last snapshot
+// taken at or before t
+func waitingAt(snapshots []timedSnapshot, queueID string, t time.Time) int {
+	i := sort.Search(len(snapshots), func(i int) bool { return snapshots[i].at.After(t) }) - 1
+	if i < 0 {
+		return 0
+	}
+
+	waiting := 0
+	for _, queue := range snapshots[i].response.Queues {
+		if queue.QueueID != queueID {
+			continue
+		}
+		for _, entry := range queue.Entries {
+			if !entry.Left && entry.Status == "waiting" {
+				waiting++
+			}
+		}
+	}
+	return waiting
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// arrivalsQueue has two customers joining at :10 and :40 past each hour
+// from start for the given number of hours, all served on the spot
+func arrivalsQueue(start time.Time, hours int, until time.Time) []QueueEntry {
+	var entries []QueueEntry
+	for h := 0; h < hours; h++ {
+		for _, minute := range []int{10, 40} {
+			joined := start.Add(time.Duration(h)*time.Hour + time.Duration(minute)*time.Minute)
+			if joined.After(until) {
+				return entries
+			}
+			entries = append(entries, QueueEntry{
+				ID:        joined.Format("0102T1504"),
+				MSISDN:    "2760",
+				Status:    "served",
+				JoinedAt:  joined,
+				StartedAt: timePtr(joined),
+				ServedAt:  timePtr(joined),
+			})
+		}
+	}
+	return entries
+}
+
+func TestArrivalRates(t *testing.T) {
+	calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+	forecaster := NewArrivalForecaster(calc, time.UTC)
+
+	// Sunday 10:00 for three weeks: 1 join two weeks ago, 3 last week and
+	// none today. The current (incomplete) hour doesn't count.
+	lastWeek := testNow.Add(-7*24*time.Hour - 2*time.Hour)
+	queue := Queue{QueueID: "Q1", Entries: []QueueEntry{
+		waitingEntry("OLD", 14*24*time.Hour+2*time.Hour-time.Minute),
+		{ID: "A", JoinedAt: lastWeek},
+		{ID: "B", JoinedAt: lastWeek.Add(20 * time.Minute)},
+		{ID: "C", JoinedAt: lastWeek.Add(50 * time.Minute)},
+		waitingEntry("NOW", -20*time.Minute),
+	}}
+
+	rates := forecaster.arrivalRates(queue, testNow.Add(30*time.Minute))
+	if got := rates[time.Sunday][10]; math.Abs(got-4.0/3) > 1e-9 {
+		t.Errorf("Sunday 10:00 rate = %v, want 4/3", got)
+	}
+	if got := rates[time.Sunday][11]; got != 0 {
+		t.Errorf("Sunday 11:00 rate = %v, want 0", got)
+	}
+	if got := rates[time.Sunday][12]; got != 0 {
+		t.Errorf("Sunday 12:00 rate = %v, want 0 (hour not over)", got)
+	}
+}
+
+func TestForecastQueueLength(t *testing.T) {
+	// Last week: 10 joins an hour from 12:00 to 14:00. Today: one counter,
+	// 10 minute services (6 an hour) and 3 waiting.
+	var entries []QueueEntry
+	lastWeek := testNow.Add(-7 * 24 * time.Hour)
+	for i := 0; i < 20; i++ {
+		entries = append(entries, QueueEntry{ID: string(rune('a' + i)), JoinedAt: lastWeek.Add(time.Duration(i) * 6 * time.Minute)})
+	}
+	entries = append(entries,
+		servedEntry("S1", 5*time.Minute, 10*time.Minute),
+		waitingEntry("W1", 3*time.Minute),
+		waitingEntry("W2", 2*time.Minute),
+		waitingEntry("W3", time.Minute),
+	)
+	queue := Queue{QueueID: "Q1", Name: "Clinic", Entries: entries}
+
+	calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+	calc.SetServerCount("Q1", 1)
+	forecaster := NewArrivalForecaster(calc, time.UTC)
+	forecast := forecaster.Forecast(queue, calc.EstimateQueue(queue), 3)
+
+	if forecast.Waiting != 3 || forecast.Servers != 1 || len(forecast.Hours) != 3 {
+		t.Fatalf("forecast = %+v, want 3 waiting, 1 counter, 3 hours", forecast)
+	}
+
+	want := []struct{ arrivals, length float64 }{
+		{10, 7},  // 3 + 10 - 6
+		{10, 11}, // 7 + 10 - 6
+		{0, 5},   // nobody joined at 14:00 last week
+	}
+	for i, hour := range forecast.Hours {
+		if hour.Arrivals != want[i].arrivals || math.Abs(hour.QueueLength-want[i].length) > 1e-9 {
+			t.Errorf("hour %d: %.1f arrivals, %.1f waiting; want %.0f, %.0f",
+				i, hour.Arrivals, hour.QueueLength, want[i].arrivals, want[i].length)
+		}
+		if hour.Capacity != 6 {
+			t.Errorf("hour %d: capacity %.1f, want 6", i, hour.Capacity)
+		}
+	}
+	if got := forecast.Hours[1].WaitMinutes; got != 110 {
+		t.Errorf("wait after 2 hours = %.0f minutes, want 110", got)
+	}
+}
+
+func TestBacktest(t *testing.T) {
+	// Same pattern last week and during a recorded four hours today
+	history := arrivalsQueue(testNow.Add(-7*24*time.Hour), 6, testNow)
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoder := json.NewEncoder(file)
+	for at := testNow; !at.After(testNow.Add(4 * time.Hour)); at = at.Add(30 * time.Minute) {
+		entries := append(append([]QueueEntry(nil), history...), arrivalsQueue(testNow, 4, at)...)
+		response := &APIResponse{Queues: []Queue{{QueueID: "Q1", Entries: entries}}}
+		if err := encoder.Encode(recordEntry{Kind: recordKindSnapshot, Timestamp: at, Response: response}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file.Close()
+
+	clock := NewVirtualClock(time.Time{})
+	replay, err := LoadReplaySource(clock, path)
+	if err != nil {
+		t.Fatalf("LoadReplaySource: %v", err)
+	}
+	forecaster := NewArrivalForecaster(NewQueueCalculator(clock, DefaultConfig()), time.UTC)
+	results := forecaster.Backtest(replay, 2, time.Hour)
+
+	// Forecasts from 12:00, 13:00, 14:00 and 15:00; the recording ends at
+	// 16:00, so only three of them can be scored two hours ahead
+	if len(results) != 2 {
+		t.Fatalf("results = %+v, want one per hour ahead", results)
+	}
+	for i, r := range results {
+		if r.QueueID != "Q1" || r.Hour != i || r.Samples != 4-i {
+			t.Errorf("result %d = %+v, want Q1 hour %d with %d samples", i, r, i, 4-i)
+		}
+		if r.ArrivalMAE != 0 || r.LengthMAE != 0 {
+			t.Errorf("hour %d: arrivals MAE %.2f, waiting MAE %.2f; want exact forecasts", i, r.ArrivalMAE, r.LengthMAE)
+		}
+	}
+}
+
+func TestForecastEndpoint(t *testing.T) {
+	queue := Queue{QueueID: "Q1", Name: "Clinic", Entries: []QueueEntry{waitingEntry("W1", time.Minute)}}
+	django := newFakeDjango(t, queue)
+	scheduler, _ := newTestScheduler(t, django, newFakeGraphAPI(t))
+	scheduler.forecaster = NewArrivalForecaster(scheduler.calculator, time.UTC)
+	scheduler.processQueues()
+
+	handler := NewStatusServer("", scheduler).server.Handler
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantHours  int
+	}{
+		{"", http.StatusOK, forecastHorizon},
+		{"?queue=Q1&hours=2", http.StatusOK, 2},
+		{"?queue=NOPE", http.StatusNotFound, 0},
+		{"?hours=0", http.StatusBadRequest, 0},
+		{"?hours=99", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/forecast"+tt.query, nil))
+
+		if recorder.Code != tt.wantStatus {
+			t.Errorf("GET /forecast%s = %d, want %d", tt.query, recorder.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+
+		var body struct {
+			Forecasts []QueueForecast `json:"forecasts"`
+		}
+		if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if len(body.Forecasts) != 1 || body.Forecasts[0].Waiting != 1 || len(body.Forecasts[0].Hours) != tt.wantHours {
+			t.Errorf("GET /forecast%s = %+v, want Q1 with 1 waiting and %d hours", tt.query, body.Forecasts, tt.wantHours)
+		}
+	}
+}
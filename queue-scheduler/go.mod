@@ -1,3 +1,8 @@
 module queue-scheduler
 
 go 1.24.6
+
+require (
+	github.com/lib/pq v1.12.3
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TimeRange is a daily span of local time such as "08:00-17:00". One that
+// ends at or before its start runs past midnight, e.g. quiet hours
+// "21:00-07:00". "24:00" ends a range at midnight.
+type TimeRange struct {
+	Start, End time.Duration // since midnight
+}
+
+// ParseTimeRange parses "HH:MM-HH:MM"
+func ParseTimeRange(value string) (TimeRange, error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(value), "-")
+	if !ok {
+		return TimeRange{}, fmt.Errorf("invalid time range %q, want HH:MM-HH:MM", value)
+	}
+
+	start, err := parseTimeOfDay(from)
+	if err != nil {
+		return TimeRange{}, fmt.Errorf("invalid time range %q: %w", value, err)
+	}
+	end, err := parseTimeOfDay(to)
+	if err != nil {
+		return TimeRange{}, fmt.Errorf("invalid time range %q: %w", value, err)
+	}
+	if start == 24*time.Hour {
+		return TimeRange{}, fmt.Errorf("invalid time range %q: cannot start at 24:00", value)
+	}
+	return TimeRange{Start: start, End: end}, nil
+}
+
+// parseTimeOfDay parses "HH:MM" into the time since midnight
+func parseTimeOfDay(value string) (time.Duration, error) {
+	var hours, minutes int
+	value = strings.TrimSpace(value)
+	if n, err := fmt.Sscanf(value, "%d:%d", &hours, &minutes); err != nil || n != 2 || len(value) != 5 {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", value)
+	}
+	if hours < 0 || minutes < 0 || minutes > 59 || hours > 24 || (hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// String formats the range as "HH:MM-HH:MM"
+func (r TimeRange) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return format(r.Start) + "-" + format(r.End)
+}
+
+// overnight reports whether the range runs past midnight
+func (r TimeRange) overnight() bool {
+	return r.End <= r.Start
+}
+
+// Contains reports whether a local time falls in the range, counting the
+// part of an overnight range after midnight
+func (r TimeRange) Contains(t time.Time) bool {
+	clock := timeOfDay(t)
+	if r.overnight() {
+		return clock >= r.Start || clock < r.End
+	}
+	return clock >= r.Start && clock < r.End
+}
+
+// UnmarshalYAML parses a "HH:MM-HH:MM" scalar
+func (r *TimeRange) UnmarshalYAML(node *yaml.Node) error {
+	parsed, err := ParseTimeRange(node.Value)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+// timeOfDay returns how long after midnight a time is, in its location
+func timeOfDay(t time.Time) time.Duration {
+	hour, minute, second := t.Clock()
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute +
+		time.Duration(second)*time.Second + time.Duration(t.Nanosecond())
+}
+
+// weekdayNames are the keys of WeeklyHours in YAML
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// WeeklyHours are the opening hours per weekday. Days without hours are
+// closed; nil means always open.
+type WeeklyHours map[time.Weekday][]TimeRange
+
+// UnmarshalYAML parses a mapping of weekday ("mon".."sun") to a
+// comma-separated list of ranges or "closed", e.g.
+//
+//	mon: 08:00-12:00, 13:00-17:00
+//	sun: closed
+func (w *WeeklyHours) UnmarshalYAML(node *yaml.Node) error {
+	var days map[string]string
+	if err := node.Decode(&days); err != nil {
+		return err
+	}
+
+	hours := make(WeeklyHours)
+	for name, value := range days {
+		day := -1
+		for i, weekday := range weekdayNames {
+			if strings.EqualFold(name, weekday) {
+				day = i
+			}
+		}
+		if day < 0 {
+			return fmt.Errorf("unknown weekday %q, want one of %s", name, strings.Join(weekdayNames, ", "))
+		}
+
+		if strings.EqualFold(strings.TrimSpace(value), "closed") {
+			continue
+		}
+		for _, spec := range strings.Split(value, ",") {
+			r, err := ParseTimeRange(spec)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			hours[time.Weekday(day)] = append(hours[time.Weekday(day)], r)
+		}
+	}
+	*w = hours
+	return nil
+}
+
+// String formats the hours as "mon 08:00-17:00; tue ...", days in order
+func (w WeeklyHours) String() string {
+	var days []string
+	for day, name := range weekdayNames {
+		var ranges []string
+		for _, r := range w[time.Weekday(day)] {
+			ranges = append(ranges, r.String())
+		}
+		if len(ranges) == 0 {
+			ranges = []string{"closed"}
+		}
+		days = append(days, name+" "+strings.Join(ranges, ","))
+	}
+	return strings.Join(days, "; ")
+}
+
+// Open reports whether the hours include a local time. An overnight range
+// keeps the day it starts on open into the next morning.
+func (w WeeklyHours) Open(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+
+	clock := timeOfDay(t)
+	for _, r := range w[t.Weekday()] {
+		if clock >= r.Start && (r.overnight() || clock < r.End) {
+			return true
+		}
+	}
+	for _, r := range w[(t.Weekday()+6)%7] {
+		if r.overnight() && clock < r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// Timezone is a time zone loaded from its IANA name, e.g.
+// "Africa/Johannesburg". The zero value means the local time zone.
+type Timezone struct {
+	*time.Location
+}
+
+// UnmarshalYAML loads the named time zone
+func (z *Timezone) UnmarshalYAML(node *yaml.Node) error {
+	location, err := time.LoadLocation(node.Value)
+	if err != nil {
+		return fmt.Errorf("invalid timezone: %w", err)
+	}
+	z.Location = location
+	return nil
+}
+
+// location returns the time zone, local if unset
+func (z Timezone) location() *time.Location {
+	if z.Location == nil {
+		return time.Local
+	}
+	return z.Location
+}
+
+// holidayLayout is how holidays are written: "2025-12-25"
+const holidayLayout = "2006-01-02"
+
+// isHoliday reports whether the local date of t is one of the holidays
+func isHoliday(holidays []string, t time.Time) bool {
+	date := t.Format(holidayLayout)
+	for _, holiday := range holidays {
+		if holiday == date {
+			return true
+		}
+	}
+	return false
+}
+
+// validateHolidays reports holidays that aren't dates
+func validateHolidays(scope string, holidays []string) []error {
+	var errs []error
+	for _, holiday := range holidays {
+		if _, err := time.Parse(holidayLayout, holiday); err != nil {
+			errs = append(errs, fmt.Errorf("%sholiday %q is not a YYYY-MM-DD date", scope, holiday))
+		}
+	}
+	return errs
+}
+
+// isOpen reports whether a queue is within its opening hours at now: not a
+// holiday and within the hours for the weekday, in the queue's time zone
+func (c QueueConfig) isOpen(now time.Time) bool {
+	local := now.In(c.Timezone.location())
+	return !isHoliday(c.Holidays, local) && c.OpeningHours.Open(local)
+}
+
+// inQuietHours reports whether now is within a queue's quiet hours
+func (c QueueConfig) inQuietHours(now time.Time) bool {
+	return c.QuietHours != nil && c.QuietHours.Contains(now.In(c.Timezone.location()))
+}
+
+// urgentMessages are the kinds still sent during quiet hours and over the
+// daily cap: the customer is about to be, or is being, served
+var urgentMessages = map[string]bool{
+	messageServing: true,
+	messageNext:    true,
+	messageAlmost:  true,
+}
+
+// holdForQuietHours reports whether a message of the given kind waits until
+// the queue's quiet hours are over, logging it if so
+func (s *Scheduler) holdForQuietHours(queueID, kind, recipient string) bool {
+	if urgentMessages[kind] || !s.config.Queue(queueID).inQuietHours(s.clock.Now()) {
+		return false
+	}
+	log.Printf("🌙 Quiet hours in queue %s: holding %s message for %s", queueID, kind, recipient)
+	return true
+}
+
+// openQueues drops the queues outside their opening hours, logging when a
+// queue closes or opens. A closed queue's snapshot still replaces the
+// previous one, without learning from it, so that reopening compares with
+// how the queue was left rather than how it was at closing time. Queues no
+// longer listed are forgotten.
+func (s *Scheduler) openQueues(queues []Queue, now time.Time) []Queue {
+	var open []Queue
+	listed := make(map[string]bool, len(queues))
+	for _, queue := range queues {
+		listed[queue.QueueID] = true
+		isOpen := s.config.Queue(queue.QueueID).isOpen(now)
+		wasClosed, seen := s.closed[queue.QueueID]
+		switch {
+		case !isOpen && (!seen || !wasClosed):
+			log.Printf("🔒 Queue %s closed, pausing polling and alerts", queue.QueueID)
+		case isOpen && wasClosed:
+			log.Printf("🏪 Queue %s opened, resuming polling and alerts", queue.QueueID)
+		}
+		s.closed[queue.QueueID] = !isOpen
+		if isOpen {
+			open = append(open, queue)
+		} else {
+			s.previousQueues[queue.QueueID] = queue
+		}
+	}
+
+	for queueID := range s.closed {
+		if !listed[queueID] {
+			delete(s.closed, queueID)
+		}
+	}
+	return open
+}
+
+// allClosed reports whether every queue seen so far is outside its opening
+// hours, so the source needn't be polled at all
+func (s *Scheduler) allClosed(now time.Time) bool {
+	if len(s.closed) == 0 {
+		return false
+	}
+
+	for queueID := range s.closed {
+		if s.config.Queue(queueID).isOpen(now) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestTimeRange(t *testing.T) {
+	at := func(clock string) time.Time {
+		parsed, err := time.Parse("15:04", clock)
+		if err != nil {
+			t.Fatalf("bad test time %q", clock)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		spec    string
+		inside  []string
+		outside []string
+	}{
+		{"08:00-17:00", []string{"08:00", "12:30", "16:59"}, []string{"07:59", "17:00", "23:00"}},
+		{"21:00-07:00", []string{"21:00", "23:59", "00:00", "06:59"}, []string{"07:00", "12:00", "20:59"}},
+		{"00:00-24:00", []string{"00:00", "12:00", "23:59"}, nil},
+	}
+	for _, tt := range tests {
+		r, err := ParseTimeRange(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseTimeRange(%q): %v", tt.spec, err)
+		}
+		if r.String() != tt.spec {
+			t.Errorf("String() = %q, want %q", r, tt.spec)
+		}
+		for _, clock := range tt.inside {
+			if !r.Contains(at(clock)) {
+				t.Errorf("%s doesn't contain %s", tt.spec, clock)
+			}
+		}
+		for _, clock := range tt.outside {
+			if r.Contains(at(clock)) {
+				t.Errorf("%s contains %s", tt.spec, clock)
+			}
+		}
+	}
+
+	for _, spec := range []string{"8-17", "08:00", "08:00-25:00", "08:60-09:00", "24:00-06:00", "8:00-17:00"} {
+		if _, err := ParseTimeRange(spec); err == nil {
+			t.Errorf("ParseTimeRange(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestWeeklyHours(t *testing.T) {
+	var hours WeeklyHours
+	err := yaml.Unmarshal([]byte(`
+mon: 08:00-12:00, 13:00-17:00
+fri: 20:00-02:00
+sun: closed
+`), &hours)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	// 2025-08-18 is a Monday
+	day := func(offset int, clock string) time.Time {
+		parsed, _ := time.Parse("2006-01-02 15:04", "2025-08-18 "+clock)
+		return parsed.AddDate(0, 0, offset)
+	}
+	tests := []struct {
+		at   time.Time
+		open bool
+	}{
+		{day(0, "09:00"), true},
+		{day(0, "12:30"), false}, // lunch
+		{day(0, "16:59"), true},
+		{day(1, "09:00"), false}, // Tuesday has no hours
+		{day(4, "23:00"), true},  // Friday night
+		{day(5, "01:30"), true},  // ...into Saturday morning
+		{day(5, "02:00"), false},
+		{day(6, "12:00"), false}, // Sunday is closed
+	}
+	for _, tt := range tests {
+		if got := hours.Open(tt.at); got != tt.open {
+			t.Errorf("Open(%s) = %v, want %v", tt.at.Format("Mon 15:04"), got, tt.open)
+		}
+	}
+
+	if !WeeklyHours(nil).Open(day(6, "03:00")) {
+		t.Error("unset hours should always be open")
+	}
+	if err := yaml.Unmarshal([]byte("funday: 08:00-17:00\n"), &hours); err == nil {
+		t.Error("unknown weekday accepted")
+	}
+}
+
+func TestQueueHoursConfig(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, `
+timezone: Africa/Johannesburg
+opening_hours:
+  mon: 08:00-17:00
+  sun: 10:00-14:00
+holidays: ["2025-12-25"]
+quiet_hours: 21:00-07:00
+queues:
+  ER:
+    opening_hours:
+      sun: 00:00-24:00
+    holidays: ["2025-08-18"]
+    quiet_hours: 22:00-06:00
+`))
+	config, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	// testNow is Sunday 12:00 UTC, 14:00 in Johannesburg
+	main, er := config.Queue("MAIN"), config.Queue("ER")
+	if main.isOpen(testNow) {
+		t.Error("MAIN open at 14:00 on Sunday, want closed")
+	}
+	if !main.isOpen(testNow.Add(-time.Hour)) {
+		t.Error("MAIN closed at 13:00 on Sunday, want open")
+	}
+	if !er.isOpen(testNow) {
+		t.Error("ER closed on Sunday afternoon, want open all day")
+	}
+	if er.isOpen(testNow.Add(24*time.Hour)) || !main.isOpen(testNow.Add(24*time.Hour)) {
+		t.Error("the ER's own holiday should close only the ER")
+	}
+	if main.isOpen(time.Date(2025, 12, 25, 10, 0, 0, 0, time.UTC)) {
+		t.Error("MAIN open on the global holiday")
+	}
+
+	night := testNow.Add(7*time.Hour + 30*time.Minute) // 21:30 in Johannesburg
+	if !main.inQuietHours(night) || er.inQuietHours(night) {
+		t.Errorf("quiet hours at 21:30: MAIN %v, ER %v, want only MAIN", main.inQuietHours(night), er.inQuietHours(night))
+	}
+
+	want := "queues.ER.holidays: 2025-08-18 -> (unset)"
+	if changes := DefaultConfig().Changes(config); !containsString(changes, want) {
+		t.Errorf("Changes = %q, want one %q", changes, want)
+	}
+
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "holidays: [\"25 December\"]\ntimezone: Mars/Olympus\n"))
+	if _, err := LoadConfig(nil); err == nil || !strings.Contains(err.Error(), "invalid timezone") {
+		t.Errorf("LoadConfig error = %v, want the bad timezone reported", err)
+	}
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "holidays: [\"25 December\"]\n"))
+	if _, err := LoadConfig(nil); err == nil || !strings.Contains(err.Error(), `holiday "25 December" is not a YYYY-MM-DD date`) {
+		t.Errorf("LoadConfig error = %v, want the bad holiday reported", err)
+	}
+}
+
+func containsString(values []string, want string) bool {
+	for _, value := range values {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestClosedQueuesAreNotPolled(t *testing.T) {
+	open := sampleQueue()
+	closed := sampleQueue()
+	closed.QueueID = "CLOSED"
+	for i := range closed.Entries {
+		closed.Entries[i].MSISDN = "2761" + closed.Entries[i].ID
+	}
+
+	// testNow is 12:00 UTC on a Sunday
+	config := DefaultConfig()
+	config.Timezone = Timezone{time.UTC}
+	config.Queues = map[string]QueueConfig{"CLOSED": {OpeningHours: WeeklyHours{time.Sunday: {{Start: 13 * time.Hour, End: 17 * time.Hour}}}}}
+	django := newFakeDjango(t, open, closed)
+	graph := newFakeGraphAPI(t)
+	scheduler, clock := newConfiguredTestScheduler(t, django, graph, config)
+
+	scheduler.processQueues()
+	for msisdn := range messagesTo(graph) {
+		if strings.HasPrefix(msisdn, "2761") {
+			t.Errorf("messaged %s in the closed queue", msisdn)
+		}
+	}
+	if _, polled := scheduler.lastPolled["CLOSED"]; polled {
+		t.Error("closed queue was processed")
+	}
+
+	// With every queue closed the source is only fetched every poll
+	// interval, to find new queues
+	config.Queues["TEST123"] = config.Queues["CLOSED"]
+	scheduler.config = config
+	fetches := django.fetches()
+	clock.Advance(30 * time.Second)
+	scheduler.processQueues()
+	if !scheduler.allClosed(clock.Now()) {
+		t.Error("allClosed = false with every queue closed")
+	}
+	if got := django.fetches(); got != fetches {
+		t.Errorf("fetches = %d, want %d with every queue closed", got, fetches)
+	}
+
+	added := sampleQueue()
+	added.QueueID = "NEW"
+	for i := range added.Entries {
+		added.Entries[i].MSISDN = "2762" + added.Entries[i].ID
+	}
+	django.setQueues(open, closed, added)
+	clock.Advance(30 * time.Second)
+	scheduler.processQueues()
+	if got := django.fetches(); got != fetches+1 {
+		t.Errorf("fetches = %d, want %d after the poll interval", got, fetches+1)
+	}
+	if got := scheduler.lastPolled["NEW"]; !got.Equal(clock.Now()) {
+		t.Errorf("NEW last polled %v, want found while the others are closed", got)
+	}
+
+	// At opening time both are processed again
+	django.setQueues(open, closed)
+	clock.Set(testNow.Add(time.Hour))
+	scheduler.processQueues()
+	if got := scheduler.lastPolled["CLOSED"]; !got.Equal(clock.Now()) {
+		t.Errorf("CLOSED last polled %v, want at opening", got)
+	}
+	if len(messagesTo(graph)["2761WAIT1"]) != 1 {
+		t.Errorf("messages to 2761WAIT1 = %q, want one after opening", messagesTo(graph)["2761WAIT1"])
+	}
+}
+
+func TestClosedQueueSnapshotsAreKept(t *testing.T) {
+	other := Queue{QueueID: "OTHER", Entries: []QueueEntry{inProgressEntry("AT", time.Minute)}}
+	lunch := Queue{QueueID: "LUNCH", Entries: []QueueEntry{waitingEntry("W", 5*time.Minute)}}
+
+	// LUNCH closes from 12:30 to 13:00 on Sundays
+	config := DefaultConfig()
+	config.Timezone = Timezone{time.UTC}
+	config.Queues = map[string]QueueConfig{"LUNCH": {OpeningHours: WeeklyHours{time.Sunday: {
+		{Start: 8 * time.Hour, End: 12*time.Hour + 30*time.Minute},
+		{Start: 13 * time.Hour, End: 17 * time.Hour},
+	}}}}
+	django := newFakeDjango(t, other, lunch)
+	scheduler, clock := newConfiguredTestScheduler(t, django, newFakeGraphAPI(t), config)
+	scheduler.processQueues()
+
+	// W gives up over lunch: the snapshot is kept but nothing is learned
+	leftAt := testNow.Add(35 * time.Minute)
+	lunch.Entries[0].Left, lunch.Entries[0].LeftAt = true, &leftAt
+	django.setQueues(other, lunch)
+	clock.Set(testNow.Add(40 * time.Minute))
+	scheduler.processQueues()
+	if previous := scheduler.previousQueues["LUNCH"]; len(previous.Entries) != 1 || !previous.Entries[0].Left {
+		t.Errorf("LUNCH previous snapshot = %+v, want the one taken while closed", previous)
+	}
+	if _, learned := scheduler.calculator.abandonment["LUNCH"]; learned {
+		t.Error("learned abandonment from a closed queue")
+	}
+
+	// Nor after reopening, since the change happened while closed
+	clock.Set(testNow.Add(time.Hour))
+	scheduler.processQueues()
+	if _, learned := scheduler.calculator.abandonment["LUNCH"]; learned {
+		t.Error("learned abandonment from the lunch break after reopening")
+	}
+
+	// A queue that is no longer listed is forgotten
+	django.setQueues(other)
+	clock.Advance(time.Minute)
+	scheduler.processQueues()
+	if _, seen := scheduler.closed["LUNCH"]; seen {
+		t.Error("LUNCH still tracked after it was removed")
+	}
+}
+
+func TestQuietHoursHoldNonUrgentMessages(t *testing.T) {
+	config := DefaultConfig()
+	config.Timezone = Timezone{time.UTC}
+	config.QuietHours = &TimeRange{Start: 11 * time.Hour, End: 13 * time.Hour} // around testNow
+
+	django := newFakeDjango(t, sampleQueue())
+	graph := newFakeGraphAPI(t)
+	scheduler, clock := newConfiguredTestScheduler(t, django, graph, config)
+
+	scheduler.processQueues()
+	got := messagesTo(graph)
+	for _, msisdn := range []string{"2760CURR1", "2760WAIT1", "2760WAIT2"} {
+		if len(got[msisdn]) != 1 {
+			t.Errorf("messages to %s = %q, want the urgent one", msisdn, got[msisdn])
+		}
+	}
+	if len(got["2760WAIT3"]) != 0 {
+		t.Errorf("messages to 2760WAIT3 = %q, want the position update held", got["2760WAIT3"])
+	}
+
+	clock.Set(testNow.Add(time.Hour))
+	scheduler.processQueues()
+	if got := messagesTo(graph)["2760WAIT3"]; len(got) != 1 || !strings.Contains(got[0], "Position #3") {
+		t.Errorf("messages to 2760WAIT3 = %q, want the position update after quiet hours", got)
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultLeaseTTL is how long a lease file stays valid without renewal. The
+// leader renews every third of it, so a replica taking over waits at most
+// this long after the leader dies.
+const defaultLeaseTTL = 15 * time.Second
+
+// LeaderLock is a lock at most one scheduler replica holds at a time
+type LeaderLock interface {
+	// TryAcquire takes the lock, or renews it if already held, and reports
+	// whether this replica holds it
+	TryAcquire(ctx context.Context) (bool, error)
+
+	// Release gives the lock up so another replica can take over at once
+	Release() error
+
+	Name() string
+}
+
+// NewLeaderLock builds the lock selected by kind ("file" or "postgres") for
+// the replica named identity. An empty kind means no leader election.
+func NewLeaderLock(kind, identity, leaseFile, databaseURL string, ttl time.Duration, clock Clock) (LeaderLock, error) {
+	switch strings.ToLower(kind) {
+	case "":
+		return nil, nil
+	case "file":
+		if leaseFile == "" {
+			return nil, fmt.Errorf("LEADER_LEASE_FILE is required for file leader election")
+		}
+		return NewFileLease(leaseFile, identity, ttl, clock), nil
+	case "postgres":
+		if databaseURL == "" {
+			return nil, fmt.Errorf("DATABASE_URL is required for postgres leader election")
+		}
+		return NewPostgresLock(databaseURL, "queue-scheduler")
+	default:
+		return nil, fmt.Errorf("unknown leader election backend %q", kind)
+	}
+}
+
+// defaultInstanceID names this replica by host and process
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// leaseRecord is the content of a lease file
+type leaseRecord struct {
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// FileLease is a lease in a file on storage shared by the replicas. The
+// holder rewrites it with a new expiry on every renewal; once it expires any
+// replica may take it. Updates are serialised by an exclusively created
+// "<path>.lock" file. Expiry is an absolute time compared with each
+// replica's own clock, so the replicas' clocks must be in sync (e.g. by NTP)
+// to well within the TTL; a replica whose clock runs ahead takes the lease
+// early.
+type FileLease struct {
+	path   string
+	holder string
+	ttl    time.Duration
+	clock  Clock
+}
+
+// NewFileLease creates a lease on path for holder, valid for ttl after each
+// renewal
+func NewFileLease(path, holder string, ttl time.Duration, clock Clock) *FileLease {
+	return &FileLease{path: path, holder: holder, ttl: ttl, clock: clock}
+}
+
+// Name describes the lease for logs
+func (l *FileLease) Name() string {
+	return "lease file " + l.path
+}
+
+// TryAcquire takes the lease if it is free or expired, and renews it if
+// already held
+func (l *FileLease) TryAcquire(ctx context.Context) (bool, error) {
+	unlock, err := l.lock(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	record, err := l.read()
+	if err != nil {
+		return false, err
+	}
+	now := l.clock.Now()
+	if record.Holder != "" && record.Holder != l.holder && now.Before(record.ExpiresAt) {
+		return false, nil
+	}
+
+	data, err := json.Marshal(leaseRecord{Holder: l.holder, ExpiresAt: now.Add(l.ttl)})
+	if err != nil {
+		return false, fmt.Errorf("failed to encode lease: %w", err)
+	}
+	if err := writeFileAtomic(l.path, data); err != nil {
+		return false, fmt.Errorf("failed to write lease: %w", err)
+	}
+	return true, nil
+}
+
+// Release removes the lease if this replica holds it
+func (l *FileLease) Release() error {
+	unlock, err := l.lock(context.Background())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	record, err := l.read()
+	if err != nil || record.Holder != l.holder {
+		return err
+	}
+	if err := os.Remove(l.path); err != nil {
+		return fmt.Errorf("failed to remove lease: %w", err)
+	}
+	return nil
+}
+
+// read loads the lease, zero if there is none
+func (l *FileLease) read() (leaseRecord, error) {
+	var record leaseRecord
+	data, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return record, nil
+	}
+	if err != nil {
+		return record, fmt.Errorf("failed to read lease: %w", err)
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, fmt.Errorf("failed to parse lease %s: %w", l.path, err)
+	}
+	return record, nil
+}
+
+// lock creates the lock file guarding lease updates, waiting briefly while
+// another replica holds it. A lock file older than the lease TTL was left
+// by a replica that died mid-update and is removed.
+func (l *FileLease) lock(ctx context.Context) (func(), error) {
+	path := l.path + ".lock"
+	for attempt := 0; ; attempt++ {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock lease: %w", err)
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > l.ttl {
+			os.Remove(path)
+			continue
+		}
+		if attempt == 20 {
+			return nil, fmt.Errorf("failed to lock lease: %s is held by another replica", path)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// PostgresLock is a session-level Postgres advisory lock. It is held for as
+// long as its connection stays open, so when the leader dies the database
+// frees it straight away.
+type PostgresLock struct {
+	db   *sql.DB
+	key  int64
+	conn *sql.Conn
+}
+
+// NewPostgresLock connects to the database for an advisory lock keyed by a
+// hash of name
+func NewPostgresLock(databaseURL, name string) (*PostgresLock, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+	return &PostgresLock{db: db, key: int64(hash.Sum64())}, nil
+}
+
+// Name describes the lock for logs
+func (l *PostgresLock) Name() string {
+	return fmt.Sprintf("postgres advisory lock %d", l.key)
+}
+
+// TryAcquire takes the advisory lock on a dedicated connection, or checks
+// that connection is still alive if the lock is already held
+func (l *PostgresLock) TryAcquire(ctx context.Context) (bool, error) {
+	if l.conn != nil {
+		if _, err := l.conn.ExecContext(ctx, "SELECT 1"); err != nil {
+			l.conn.Close()
+			l.conn = nil
+			return false, fmt.Errorf("failed to keep advisory lock, connection lost: %w", err)
+		}
+		return true, nil
+	}
+
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect for advisory lock: %w", err)
+	}
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&acquired); err != nil {
+		conn.Close()
+		return false, fmt.Errorf("failed to try advisory lock: %w", err)
+	}
+	if !acquired {
+		conn.Close()
+		return false, nil
+	}
+	l.conn = conn
+	return true, nil
+}
+
+// Release unlocks and closes the lock's connection
+func (l *PostgresLock) Release() error {
+	if l.conn == nil {
+		return nil
+	}
+	defer func() { l.conn = nil }()
+
+	_, err := l.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", l.key)
+	l.conn.Close()
+	if err != nil {
+		return fmt.Errorf("failed to release advisory lock: %w", err)
+	}
+	return nil
+}
+
+// Close releases the lock and closes the database
+func (l *PostgresLock) Close() error {
+	return errors.Join(l.Release(), l.db.Close())
+}
+
+// LeaderStatus is what a replica knows about its leadership
+type LeaderStatus struct {
+	Identity    string    `json:"identity"`
+	Backend     string    `json:"backend"`
+	Leader      bool      `json:"leader"`
+	Since       time.Time `json:"since"`
+	Transitions int       `json:"transitions"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// LeaderElector keeps trying to take the leader lock, and renewing it once
+// held, so that only one replica processes queues and sends messages
+type LeaderElector struct {
+	lock     LeaderLock
+	identity string
+	interval time.Duration
+	clock    Clock
+
+	// elected is signalled whenever this replica becomes the leader, so the
+	// scheduler can poll straight away
+	elected chan struct{}
+
+	mu     sync.RWMutex
+	status LeaderStatus
+}
+
+// NewLeaderElector creates an elector for the replica named identity,
+// trying the lock every interval
+func NewLeaderElector(lock LeaderLock, identity string, interval time.Duration, clock Clock) *LeaderElector {
+	return &LeaderElector{
+		lock:     lock,
+		identity: identity,
+		interval: interval,
+		clock:    clock,
+		elected:  make(chan struct{}, 1),
+		status:   LeaderStatus{Identity: identity, Backend: lock.Name(), Since: clock.Now()},
+	}
+}
+
+// Run campaigns until ctx is done, then releases the lock so another
+// replica can take over without waiting for it to expire
+func (e *LeaderElector) Run(ctx context.Context) {
+	log.Printf("🗳️  Campaigning for leader as %s using %s", e.identity, e.lock.Name())
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		e.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			if err := e.lock.Release(); err != nil {
+				log.Printf("Error releasing leader lock: %v", err)
+			}
+			e.setLeader(false, nil)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check tries to take or renew the lock once. An error counts as losing
+// it: better to pause than to risk two replicas messaging customers.
+func (e *LeaderElector) check(ctx context.Context) bool {
+	leader, err := e.lock.TryAcquire(ctx)
+	if err != nil {
+		log.Printf("Leader election error: %v", err)
+		leader = false
+	}
+	e.setLeader(leader, err)
+	return leader
+}
+
+// setLeader records the outcome of a check, logging changes of leadership
+func (e *LeaderElector) setLeader(leader bool, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.status.LastError = ""
+	if err != nil {
+		e.status.LastError = err.Error()
+	}
+	if leader == e.status.Leader {
+		return
+	}
+
+	e.status.Leader = leader
+	e.status.Since = e.clock.Now()
+	e.status.Transitions++
+	if leader {
+		log.Printf("👑 %s is now the leader, processing queues", e.identity)
+		select {
+		case e.elected <- struct{}{}:
+		default:
+		}
+	} else {
+		log.Printf("🪑 %s is no longer the leader, standing by", e.identity)
+	}
+}
+
+// IsLeader reports whether this replica held the lock at the last check
+func (e *LeaderElector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.status.Leader
+}
+
+// Elected fires when this replica becomes the leader
+func (e *LeaderElector) Elected() <-chan struct{} {
+	return e.elected
+}
+
+// Status returns this replica's leadership
+func (e *LeaderElector) Status() LeaderStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.status
+}
+
+// WriteMetrics writes the leadership in the Prometheus text format
+func (e *LeaderElector) WriteMetrics(w io.Writer) {
+	status := e.Status()
+	leader := 0
+	if status.Leader {
+		leader = 1
+	}
+
+	fmt.Fprintln(w, "# HELP queue_scheduler_leader Whether this replica is the leader processing queues.")
+	fmt.Fprintln(w, "# TYPE queue_scheduler_leader gauge")
+	fmt.Fprintf(w, "queue_scheduler_leader{instance=%q} %d\n", status.Identity, leader)
+	fmt.Fprintln(w, "# HELP queue_scheduler_leader_transitions_total Times this replica gained or lost leadership.")
+	fmt.Fprintln(w, "# TYPE queue_scheduler_leader_transitions_total counter")
+	fmt.Fprintf(w, "queue_scheduler_leader_transitions_total{instance=%q} %d\n", status.Identity, status.Transitions)
+}
+
+// leading reports whether this replica should process queues: always
+// without leader election. On taking over from another replica the state
+// it last saved is loaded, so customers it already messaged aren't
+// messaged again.
+func (s *Scheduler) leading() bool {
+	if s.elector == nil {
+		return true
+	}
+
+	leader := s.elector.IsLeader()
+	if leader && !s.wasLeader && s.store != nil {
+		if err := s.RestoreState(s.store); err != nil {
+			log.Printf("⚠️  Warning: Could not load the previous leader's state: %v", err)
+		}
+	}
+	s.wasLeader = leader
+	return leader
+}
+
+// stillLeading reports whether this replica still holds leadership in the
+// middle of a poll. The elector renews in the background, so leadership can
+// be lost after a poll has started, and another replica may already be
+// messaging the same customers.
+func (s *Scheduler) stillLeading() bool {
+	return s.elector == nil || s.elector.IsLeader()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileLease(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "leader.lease")
+	clock := NewVirtualClock(testNow)
+	a := NewFileLease(path, "a", 15*time.Second, clock)
+	b := NewFileLease(path, "b", 15*time.Second, clock)
+
+	tryAcquire := func(lease *FileLease, want bool) {
+		t.Helper()
+		got, err := lease.TryAcquire(ctx)
+		if err != nil {
+			t.Fatalf("%s TryAcquire: %v", lease.holder, err)
+		}
+		if got != want {
+			t.Errorf("%s TryAcquire at +%v = %v, want %v", lease.holder, clock.Now().Sub(testNow), got, want)
+		}
+	}
+
+	tryAcquire(a, true)
+	tryAcquire(b, false)
+
+	// Renewals keep the lease with a
+	clock.Advance(10 * time.Second)
+	tryAcquire(a, true)
+	clock.Advance(10 * time.Second)
+	tryAcquire(b, false)
+
+	// Once a stops renewing, b takes over when the lease expires
+	clock.Advance(16 * time.Second)
+	tryAcquire(b, true)
+	tryAcquire(a, false)
+
+	// Releasing hands over at once
+	if err := b.Release(); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	tryAcquire(a, true)
+	if err := b.Release(); err != nil {
+		t.Fatalf("Release of a lease held by another: %v", err)
+	}
+	tryAcquire(b, false)
+
+	// A lock file left behind by a crashed replica is cleared once stale
+	if err := os.WriteFile(path+".lock", nil, 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	stale := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(path+".lock", stale, stale); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+	tryAcquire(a, true)
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock file left behind: %v", err)
+	}
+}
+
+func TestLeadershipLostMidPoll(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewVirtualClock(testNow)
+	lease := NewFileLease(filepath.Join(dir, "leader.lease"), "a", 15*time.Second, clock)
+	elector := NewLeaderElector(lease, "a", 5*time.Second, clock)
+
+	// The lease renewal fails while the first message goes out
+	var sent int
+	graph := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent++
+		elector.setLeader(false, nil)
+		w.Write([]byte(`{"messages":[{"id":"wamid.test"}]}`))
+	}))
+	t.Cleanup(graph.Close)
+
+	second := sampleQueue()
+	second.QueueID = "SECOND"
+	django := newFakeDjango(t, sampleQueue(), second)
+	config := DefaultConfig()
+	scheduler := mustNewScheduler(t,
+		NewAPIClient(django.URL),
+		NewQueueCalculator(clock, config),
+		NewAlertSystem(clock, WhatsAppConfig{APIURL: graph.URL, AccessToken: "test-token"}, config),
+		clock,
+		config,
+	)
+	stateFile := filepath.Join(dir, "state.json")
+	if err := scheduler.RestoreState(NewStateStore(stateFile)); err != nil {
+		t.Fatalf("RestoreState: %v", err)
+	}
+	scheduler.elector = elector
+	if !elector.check(context.Background()) {
+		t.Fatal("not elected")
+	}
+
+	scheduler.processQueues()
+	if sent != 1 {
+		t.Errorf("sent %d messages, want only the one in flight when leadership was lost", sent)
+	}
+	if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+		t.Errorf("state saved after losing leadership: %v", err)
+	}
+}
+
+func TestLeaderFailover(t *testing.T) {
+	django := newFakeDjango(t, sampleQueue())
+	graph := newFakeGraphAPI(t)
+	clock := NewVirtualClock(testNow)
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "state.json")
+
+	newReplica := func(identity string) *Scheduler {
+		config := DefaultConfig()
+		scheduler := mustNewScheduler(t,
+			NewAPIClient(django.URL),
+			NewQueueCalculator(clock, config),
+			NewAlertSystem(clock, graph.config(), config),
+			clock,
+			config,
+		)
+		if err := scheduler.RestoreState(NewStateStore(stateFile)); err != nil {
+			t.Fatalf("RestoreState: %v", err)
+		}
+		lease := NewFileLease(filepath.Join(dir, "leader.lease"), identity, 15*time.Second, clock)
+		scheduler.elector = NewLeaderElector(lease, identity, 5*time.Second, clock)
+		return scheduler
+	}
+	a, b := newReplica("a"), newReplica("b")
+
+	ctx := context.Background()
+	if !a.elector.check(ctx) || b.elector.check(ctx) {
+		t.Fatal("want a elected and b standing by")
+	}
+	select {
+	case <-a.elector.Elected():
+	default:
+		t.Error("a's election not signalled")
+	}
+
+	// Only the leader messages customers
+	a.processQueues()
+	b.processQueues()
+	sent := len(graph.sent())
+	for msisdn, messages := range messagesTo(graph) {
+		if len(messages) != 1 {
+			t.Errorf("messages to %s = %q, want one", msisdn, messages)
+		}
+	}
+
+	// a dies; b takes over once the lease expires and carries on from a's
+	// saved state, so nobody is messaged again
+	clock.Advance(20 * time.Second)
+	if !b.elector.check(ctx) {
+		t.Fatal("b not elected after a's lease expired")
+	}
+	b.processQueues()
+	if got := graph.sent()[sent:]; len(got) != 0 {
+		t.Errorf("b sent %v after taking over, want nothing", got)
+	}
+	if a.elector.check(ctx) {
+		t.Error("a still the leader after b took over")
+	}
+
+	recorder := httptest.NewRecorder()
+	NewStatusServer("", b).server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/leader", nil))
+	var status LeaderStatus
+	if err := json.NewDecoder(recorder.Body).Decode(&status); err != nil {
+		t.Fatalf("decode /leader: %v", err)
+	}
+	if status.Identity != "b" || !status.Leader || !status.Since.Equal(clock.Now()) || status.Transitions != 1 {
+		t.Errorf("/leader = %+v, want b leading since now", status)
+	}
+
+	recorder = httptest.NewRecorder()
+	NewStatusServer("", a).server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`queue_scheduler_leader{instance="a"} 0`,
+		`queue_scheduler_leader_transitions_total{instance="a"} 2`,
+	} {
+		if !strings.Contains(recorder.Body.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, recorder.Body.String())
+		}
+	}
+}
@@ -3,46 +3,140 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 )
 
 func main() {
+	// Subcommands for staff planning; everything else runs the service
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "forecast":
+			runForecastCommand(os.Args[2:])
+			return
+		case "backtest":
+			runBacktestCommand(os.Args[2:])
+			return
+		}
+	}
+
 	// Parse command line flags
-	testMode := flag.Bool("test", false, "Run calculation tests instead of scheduler")
-	whatsappTest := flag.Bool("whatsapp", false, "Test WhatsApp alert function")
+	replayFiles := flag.String("replay", "", "Replay recorded queue snapshots (comma-separated JSONL or .jsonl.gz files) offline instead of polling")
+	recordPath := flag.String("record", "", "Record fetched snapshots and emitted alerts to <path>-<time>.jsonl.gz")
+	recordMaxSize := flag.Int64("record-max-size", 64, "Start a new recording file after this many MB (uncompressed)")
+	recordMaxAge := flag.Duration("record-max-age", 24*time.Hour, "Start a new recording file after this long")
+	rebuildProfiles := flag.String("rebuild-profiles", "", `Rebuild service time profiles from recordings (comma-separated files), or from the queue source with "source", then exit`)
+	httpAddr := flag.String("http", "", "Serve forecasts, accuracy and metrics over HTTP on this address (e.g. :8081)")
+	RegisterConfigFlags(flag.CommandLine)
 	flag.Parse()
 
-	if *testMode {
-		log.Println("🧪 Running Calculation Tests")
-		testCalculations()
-		return
-	}
+	config := mustLoadConfig(flag.CommandLine)
 
-	if *whatsappTest {
-		testWhatsAppAlert()
+	if *replayFiles != "" {
+		runReplayMode(strings.Split(*replayFiles, ","), config)
 		return
 	}
 
 	log.Println("🚀 Starting Queue Scheduler Service")
 
 	// Configuration from environment or defaults
+	queueSourceKind := getEnv("QUEUE_SOURCE", "http")
 	djangoBaseURL := getEnv("DJANGO_BASE_URL", "http://127.0.0.1:8000")
-	
+	databaseURL := getEnv("DATABASE_URL", "")
+	stateFile := getEnv("STATE_FILE", "scheduler_state.json")
+	profileFile := getEnv("PROFILE_FILE", "service_profiles.json")
+	profileLocation := profileLocationFromEnv()
+	whatsapp := WhatsAppConfig{APIURL: defaultWhatsAppURL} // the token is part of config
+
+	log.Printf("Queue source: %s", queueSourceKind)
 	log.Printf("Django API URL: %s", djangoBaseURL)
+	log.Printf("State file: %s", stateFile)
 
 	// Initialize components
-	apiClient := NewAPIClient(djangoBaseURL)
-	calculator := NewQueueCalculator()
-	alertSystem := NewAlertSystem()
-	scheduler := NewScheduler(apiClient, calculator, alertSystem)
+	source, err := NewQueueSource(queueSourceKind, djangoBaseURL, databaseURL)
+	if err != nil {
+		log.Fatalf("Could not create queue source: %v", err)
+	}
+	if closer, ok := source.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	if *rebuildProfiles != "" {
+		runRebuildProfiles(*rebuildProfiles, source, NewProfileStore(profileFile), profileLocation)
+		return
+	}
+
+	clock := systemClock{}
+	calculator := NewQueueCalculator(clock, config)
+	configureCalculator(calculator)
+	if config.WhatsAppAccessToken == "" {
+		log.Println("⚠️  Warning: neither WHATSAPP_ACCESS_TOKEN nor a WhatsApp token file set, WhatsApp alerts will fail")
+	}
+	alertSystem := NewAlertSystem(clock, whatsapp, config)
+	if redisURL := getEnv("REDIS_URL", ""); redisURL != "" {
+		// Share rate limits with every other scheduler process
+		limiter, err := NewRedisRateLimiter(redisURL)
+		if err != nil {
+			log.Fatalf("Invalid REDIS_URL: %v", err)
+		}
+		defer limiter.Close()
+		log.Printf("Rate limiting with %s", limiter.Name())
+		alertSystem.limiter = limiter
+	}
+	scheduler, err := NewScheduler(source, calculator, alertSystem, clock, config)
+	if err != nil {
+		log.Fatalf("Could not create scheduler: %v", err)
+	}
+	configureScheduler(scheduler)
+	scheduler.accuracy = NewAccuracyTracker(calculator, parseShadowEstimators(getEnv("ACCURACY_SHADOW_ESTIMATORS", ""))...)
+
+	// No-shows are written back through the Django API, whichever source
+	// reads the queues
+	scheduler.appointments = NewAPIClient(djangoBaseURL)
+
+	// Capture snapshots and alerts for later replay
+	if *recordPath != "" {
+		recorder, err := NewRecorder(clock, *recordPath, *recordMaxSize*1024*1024, *recordMaxAge)
+		if err != nil {
+			log.Fatalf("Could not start recorder: %v", err)
+		}
+		defer recorder.Close()
+
+		scheduler.recorder = recorder
+		alertSystem.recorder = recorder
+	}
+
+	// Restore state from the previous run so in-progress customers aren't re-notified
+	if err := scheduler.RestoreState(NewStateStore(stateFile)); err != nil {
+		log.Printf("⚠️  Warning: Could not restore scheduler state: %v", err)
+	}
+	if err := scheduler.RestoreProfiles(NewProfileStore(profileFile), profileLocation); err != nil {
+		log.Printf("⚠️  Warning: Could not load service profiles (rebuild with -rebuild-profiles): %v", err)
+	}
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if *httpAddr != "" {
+		scheduler.forecaster = NewArrivalForecaster(calculator, profileLocation)
+		server := NewStatusServer(*httpAddr, scheduler)
+		server.Start()
+		defer func() {
+			shutdownCtx, done := context.WithTimeout(context.Background(), 5*time.Second)
+			defer done()
+			server.Shutdown(shutdownCtx)
+		}()
+	}
+
 	// Handle shutdown signals
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -54,26 +148,442 @@ func main() {
 		cancel()
 	}()
 
+	// Reload the configuration on SIGHUP and whenever the config file changes
+	reload := func(reason string) {
+		config, err := LoadConfig(flag.CommandLine)
+		if err != nil {
+			log.Printf("⚠️  Keeping the current configuration after %s: %v", reason, err)
+			return
+		}
+		if err := scheduler.Reload(ctx, config); err != nil && ctx.Err() == nil {
+			log.Printf("⚠️  Keeping the current configuration after %s: %v", reason, err)
+		}
+	}
+
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hupChan:
+				log.Println("Received SIGHUP, reloading configuration")
+				reload("SIGHUP")
+			}
+		}
+	}()
+
+	if path := flag.Lookup("config").Value.String(); path != "" {
+		go WatchConfigFile(ctx, path, defaultConfigWatchInterval, func() { reload("config file change") })
+	}
+	if config.WhatsAppTokenFile != "" {
+		// A rotated token is picked up without a signal too
+		go WatchConfigFile(ctx, config.WhatsAppTokenFile, defaultConfigWatchInterval, func() { reload("token file change") })
+	}
+
 	// Test API connectivity
 	log.Println("Testing API connectivity...")
-	if _, err := apiClient.GetAllQueues(); err != nil {
-		log.Printf("⚠️  Warning: Could not connect to Django API: %v", err)
+	if _, err := source.GetAllQueues(); err != nil {
+		log.Printf("⚠️  Warning: Could not connect to queue source: %v", err)
 		log.Println("Service will continue and retry on next poll...")
 	} else {
 		log.Println("✅ API connectivity test successful")
 	}
 
+	elector := configureLeaderElection(clock, databaseURL)
+	sharder := configureSharding(clock)
+	if elector != nil && sharder != nil {
+		log.Fatal("Leader election and sharding can't be combined, set only one of LEADER_ELECTION and SHARD_MEMBERS/SHARD_DIRECTORY")
+	}
+
+	// With several replicas only the elected leader processes queues. The
+	// lock is released after the scheduler has saved its state. A new leader
+	// carries on from the state file, so the replicas must share it.
+	if elector != nil {
+		if os.Getenv("STATE_FILE") == "" {
+			log.Fatal("LEADER_ELECTION needs STATE_FILE set to a path on storage every replica shares")
+		}
+		log.Printf("👑 Leader election on, STATE_FILE %s must be shared by every replica or customers may get repeated updates after a failover", stateFile)
+		if closer, ok := elector.lock.(io.Closer); ok {
+			defer closer.Close()
+		}
+		scheduler.elector = elector
+
+		electionCtx, stopElection := context.WithCancel(context.Background())
+		electionDone := make(chan struct{})
+		go func() {
+			elector.Run(electionCtx)
+			close(electionDone)
+		}()
+		defer func() {
+			stopElection()
+			<-electionDone
+		}()
+	}
+
+	// With sharding each instance only processes its share of the queues and
+	// leaves the membership when it stops. A queue's new owner only knows
+	// what the old one sent through the shared rate limiter.
+	if sharder != nil {
+		if alertSystem.limiter == nil {
+			log.Fatal("Sharding needs REDIS_URL, so queues moving between instances keep their rate limits")
+		}
+		scheduler.sharder = sharder
+
+		shardCtx, stopSharding := context.WithCancel(context.Background())
+		shardDone := make(chan struct{})
+		go func() {
+			sharder.Run(shardCtx)
+			close(shardDone)
+		}()
+		defer func() {
+			stopSharding()
+			<-shardDone
+		}()
+	}
+
 	// Start the scheduler
 	log.Println("Starting scheduler...")
 	scheduler.Start(ctx)
-	
+
 	log.Println("Queue Scheduler Service stopped")
 }
 
+// runReplayMode replays recorded files on a virtual clock with the
+// calculator configured from the environment, then prints how accurate its
+// wait predictions were. Alerts are only logged, never delivered, and no
+// state is persisted.
+func runReplayMode(paths []string, config Config) {
+	log.Printf("⏪ Replaying queue snapshots from %s", strings.Join(paths, ", "))
+
+	clock := NewVirtualClock(time.Time{})
+	replay, err := LoadReplaySource(clock, paths...)
+	if err != nil {
+		log.Fatalf("Could not load replay: %v", err)
+	}
+
+	alertSystem := NewAlertSystem(clock, WhatsAppConfig{}, config)
+	alertSystem.dryRun = true
+	calculator := NewQueueCalculator(clock, config)
+	configureCalculator(calculator)
+	scheduler, err := NewScheduler(replay, calculator, alertSystem, clock, config)
+	if err != nil {
+		log.Fatalf("Could not create scheduler: %v", err)
+	}
+	configureScheduler(scheduler)
+	scheduler.accuracy = NewAccuracyTracker(calculator, parseShadowEstimators(getEnv("ACCURACY_SHADOW_ESTIMATORS", ""))...)
+
+	RunReplay(scheduler, replay)
+
+	fmt.Println("\nWait prediction accuracy:")
+	scheduler.accuracy.WriteReport(os.Stdout)
+}
+
+// mustLoadConfig loads the configuration for flags, exiting if it is invalid
+func mustLoadConfig(flags *flag.FlagSet) Config {
+	config, err := LoadConfig(flags)
+	if err != nil {
+		log.Fatalf("Could not load configuration: %v", err)
+	}
+	log.Printf("Polling every %v, %v lookback, %v rate limit window, %d queue overrides",
+		config.PollInterval, config.LookbackWindow, config.RateLimitWindow, len(config.Queues))
+	return config
+}
+
+// configureCalculator applies the counter, counter pool, estimator,
+// priority and prior settings from the environment. They are read once at
+// startup and aren't part of Config, so changing them needs a restart.
+func configureCalculator(calculator *QueueCalculator) {
+	for queueID, servers := range parseQueueServers(getEnv("QUEUE_SERVERS", "")) {
+		log.Printf("Queue %s: %d counters configured", queueID, servers)
+		calculator.SetServerCount(queueID, servers)
+	}
+
+	pools, err := parseCounterPools(getEnv("COUNTER_POOLS", ""))
+	if err != nil {
+		log.Fatalf("Invalid COUNTER_POOLS: %v", err)
+	}
+	for _, pool := range pools {
+		log.Printf("Counter pool %s: %d counters shared by queues %s", pool.Name, pool.Counters, strings.Join(pool.Queues, ", "))
+		calculator.SetCounterPool(pool)
+	}
+
+	estimator, err := ParseServiceTimeEstimator(getEnv("SERVICE_ESTIMATOR", "mean"))
+	if err != nil {
+		log.Fatalf("Invalid SERVICE_ESTIMATOR: %v", err)
+	}
+	log.Printf("Service time estimator: %s", estimator.Name())
+	calculator.SetEstimator(estimator)
+
+	policy, err := ParsePriorityPolicy(getEnv("PRIORITY_POLICY", "fifo"))
+	if err != nil {
+		log.Fatalf("Invalid PRIORITY_POLICY: %v", err)
+	}
+	log.Printf("Priority policy: %s", policy.Name())
+	calculator.SetPriorityPolicy(policy)
+
+	for queueID, prior := range parseServicePriors(getEnv("SERVICE_PRIORS", "")) {
+		log.Printf("Queue %s: assuming %v service time (weight %d) until history builds up", queueID, prior.Mean, prior.Weight)
+		calculator.SetPrior(queueID, prior)
+	}
+}
+
+// configureScheduler applies the time zone appointment times are shown in
+// from the environment
+func configureScheduler(scheduler *Scheduler) {
+	scheduler.SetLocation(profileLocationFromEnv())
+}
+
+// configureLeaderElection sets up leader election between replicas from the
+// environment, nil unless LEADER_ELECTION is set
+func configureLeaderElection(clock Clock, databaseURL string) *LeaderElector {
+	ttl := ttlFromEnv("LEADER_LEASE_TTL")
+	identity := getEnv("INSTANCE_ID", defaultInstanceID())
+	lock, err := NewLeaderLock(getEnv("LEADER_ELECTION", ""), identity, getEnv("LEADER_LEASE_FILE", ""), databaseURL, ttl, clock)
+	if err != nil {
+		log.Fatalf("Invalid leader election settings: %v", err)
+	}
+	if lock == nil {
+		return nil
+	}
+	return NewLeaderElector(lock, identity, ttl/3, clock)
+}
+
+// configureSharding sets up sharding of the queues between instances from
+// the environment, nil unless SHARD_MEMBERS or SHARD_DIRECTORY is set
+func configureSharding(clock Clock) *Sharder {
+	ttl := ttlFromEnv("SHARD_HEARTBEAT_TTL")
+	membership, err := NewMembership(getEnv("SHARD_MEMBERS", ""), getEnv("SHARD_DIRECTORY", ""), ttl, clock)
+	if err != nil {
+		log.Fatalf("Invalid sharding settings: %v", err)
+	}
+	if membership == nil {
+		return nil
+	}
+	return NewSharder(getEnv("INSTANCE_ID", defaultInstanceID()), membership, ttl/3, clock)
+}
+
+// ttlFromEnv parses a lease or heartbeat TTL, defaultLeaseTTL if unset
+func ttlFromEnv(key string) time.Duration {
+	value := getEnv(key, "")
+	if value == "" {
+		return defaultLeaseTTL
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < time.Second {
+		log.Fatalf("Invalid %s %q, want a duration of at least 1s", key, value)
+	}
+	return d
+}
+
+// profileLocationFromEnv returns the time zone profiles and forecasts are
+// bucketed in
+func profileLocationFromEnv() *time.Location {
+	location, err := time.LoadLocation(getEnv("PROFILE_TIMEZONE", "Local"))
+	if err != nil {
+		log.Fatalf("Invalid PROFILE_TIMEZONE: %v", err)
+	}
+	return location
+}
+
+// runForecastCommand prints the arrival and queue length forecast for the
+// next hours from one snapshot of the configured queue source
+func runForecastCommand(args []string) {
+	flags := flag.NewFlagSet("forecast", flag.ExitOnError)
+	queueID := flags.String("queue", "", "Only forecast this queue")
+	hours := flags.Int("hours", 8, "Hours to forecast")
+	RegisterConfigFlags(flags)
+	flags.Parse(args)
+	config := mustLoadConfig(flags)
+
+	source, err := NewQueueSource(getEnv("QUEUE_SOURCE", "http"), getEnv("DJANGO_BASE_URL", "http://127.0.0.1:8000"), getEnv("DATABASE_URL", ""))
+	if err != nil {
+		log.Fatalf("Could not create queue source: %v", err)
+	}
+	if closer, ok := source.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	location := profileLocationFromEnv()
+	calculator := NewQueueCalculator(systemClock{}, config)
+	configureCalculator(calculator)
+	profiles, err := NewProfileStore(getEnv("PROFILE_FILE", "service_profiles.json")).Load(location)
+	if err != nil {
+		log.Printf("⚠️  Warning: Could not load service profiles: %v", err)
+	}
+	calculator.SetProfiles(profiles)
+	forecaster := NewArrivalForecaster(calculator, location)
+
+	response, err := source.GetAllQueues()
+	if err != nil {
+		log.Fatalf("Could not fetch queues: %v", err)
+	}
+
+	estimates := calculator.EstimateQueues(response.Queues)
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, queue := range response.Queues {
+		if *queueID != "" && queue.QueueID != *queueID {
+			continue
+		}
+
+		forecast := forecaster.Forecast(queue, estimates[queue.QueueID], *hours)
+		fmt.Fprintf(out, "\n%s (%s): %d waiting, %d counters\n", forecast.Name, forecast.QueueID, forecast.Waiting, forecast.Servers)
+		fmt.Fprintln(out, "HOUR\tARRIVALS\tCAPACITY\tWAITING\tWAIT (MIN)")
+		for _, hour := range forecast.Hours {
+			fmt.Fprintf(out, "%s\t%.1f\t%.1f\t%.1f\t%.0f\n", hour.Start.In(location).Format("Mon 15:04"),
+				hour.Arrivals, hour.Capacity, hour.QueueLength, hour.WaitMinutes)
+		}
+	}
+	out.Flush()
+}
+
+// runBacktestCommand replays recordings, forecasting along the way, and
+// prints the forecast error per queue and hour ahead
+func runBacktestCommand(args []string) {
+	flags := flag.NewFlagSet("backtest", flag.ExitOnError)
+	hours := flags.Int("hours", 4, "Hours ahead to forecast and score")
+	every := flags.Duration("every", time.Hour, "Make a forecast this often during the recording")
+	RegisterConfigFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: queue-scheduler backtest [flags] recording.jsonl.gz...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	config := mustLoadConfig(flags)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	clock := NewVirtualClock(time.Time{})
+	replay, err := LoadReplaySource(clock, flags.Args()...)
+	if err != nil {
+		log.Fatalf("Could not load recordings: %v", err)
+	}
+
+	calculator := NewQueueCalculator(clock, config)
+	configureCalculator(calculator)
+	forecaster := NewArrivalForecaster(calculator, profileLocationFromEnv())
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(out, "QUEUE\tHOURS AHEAD\tSAMPLES\tARRIVALS MAE\tARRIVALS BIAS\tWAITING MAE\tWAITING BIAS")
+	for _, r := range forecaster.Backtest(replay, *hours, *every) {
+		fmt.Fprintf(out, "%s\t%d\t%d\t%.2f\t%+.2f\t%.2f\t%+.2f\n", r.QueueID, r.Hour+1, r.Samples,
+			r.ArrivalMAE, r.ArrivalBias, r.LengthMAE, r.LengthBias)
+	}
+	out.Flush()
+}
+
+// runRebuildProfiles rebuilds service time profiles from recorded snapshots,
+// or from a single snapshot of the live source (which carries each queue's
+// full entry history), and overwrites the profile file
+func runRebuildProfiles(from string, source QueueSource, store *ProfileStore, location *time.Location) {
+	var snapshots []*APIResponse
+
+	if from == "source" {
+		log.Println("📊 Rebuilding service profiles from the queue source")
+		response, err := source.GetAllQueues()
+		if err != nil {
+			log.Fatalf("Could not fetch queues: %v", err)
+		}
+		snapshots = append(snapshots, response)
+	} else {
+		paths := strings.Split(from, ",")
+		log.Printf("📊 Rebuilding service profiles from %s", strings.Join(paths, ", "))
+		replay, err := LoadReplaySource(NewVirtualClock(time.Time{}), paths...)
+		if err != nil {
+			log.Fatalf("Could not load recordings: %v", err)
+		}
+		for replay.Advance() {
+			response, _ := replay.GetAllQueues()
+			snapshots = append(snapshots, response)
+		}
+	}
+
+	profiles := RebuildServiceProfiles(location, snapshots)
+	if err := store.Save(profiles, time.Now()); err != nil {
+		log.Fatalf("Could not save service profiles: %v", err)
+	}
+	log.Printf("✅ Saved service profiles for %d queues from %d snapshots", len(profiles.queues), len(snapshots))
+}
+
+// parseQueueServers parses "QUEUEID=N,QUEUEID=N" counter overrides,
+// skipping malformed pairs
+func parseQueueServers(value string) map[string]int {
+	servers := make(map[string]int)
+
+	for _, pair := range strings.Split(value, ",") {
+		queueID, count, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n <= 0 {
+			log.Printf("⚠️  Warning: ignoring invalid counter count %q for queue %s", count, queueID)
+			continue
+		}
+		servers[queueID] = n
+	}
+
+	return servers
+}
+
+// parseServicePriors parses "QUEUEID=DURATION[/WEIGHT],..." priors, skipping
+// malformed pairs
+func parseServicePriors(value string) map[string]ServicePrior {
+	priors := make(map[string]ServicePrior)
+
+	for _, pair := range strings.Split(value, ",") {
+		queueID, spec, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+
+		mean, weight, hasWeight := strings.Cut(spec, "/")
+		prior := ServicePrior{}
+		d, err := time.ParseDuration(mean)
+		if err != nil || d <= 0 {
+			log.Printf("⚠️  Warning: ignoring invalid service prior %q for queue %s", spec, queueID)
+			continue
+		}
+		prior.Mean = d
+		if hasWeight {
+			w, err := strconv.Atoi(weight)
+			if err != nil || w < 0 {
+				log.Printf("⚠️  Warning: ignoring invalid service prior %q for queue %s", spec, queueID)
+				continue
+			}
+			prior.Weight = w
+		}
+		priors[queueID] = prior
+	}
+
+	return priors
+}
+
+// parseShadowEstimators parses a comma-separated list of estimators to score
+// alongside the active one, skipping invalid ones
+func parseShadowEstimators(value string) []ServiceTimeEstimator {
+	var shadows []ServiceTimeEstimator
+	for _, spec := range strings.Split(value, ",") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		estimator, err := ParseServiceTimeEstimator(spec)
+		if err != nil {
+			log.Printf("⚠️  Warning: ignoring shadow estimator: %v", err)
+			continue
+		}
+		shadows = append(shadows, estimator)
+	}
+	return shadows
+}
+
 // getEnv gets environment variable with fallback
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
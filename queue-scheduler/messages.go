@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+)
+
+// Message kinds, one template each
+const (
+	messageServing  = "serving"  // status changed to in_progress
+	messageNext     = "next"     // position 1
+	messageAlmost   = "almost"   // position 2
+	messagePosition = "position" // position 3 and beyond
+	messageReminder = "reminder" // appointment coming up
+	messageNoShow   = "no_show"  // appointment missed, slot released
+)
+
+// defaultMessageTemplates are the customer-facing messages. Templates get a
+// MessageData value.
+var defaultMessageTemplates = map[string]string{
+	messageServing:  "🔔 You're now being served! Please proceed to the counter.",
+	messageNext:     "⏰ You're NEXT! Please be ready. Estimated wait: {{.Wait}}",
+	messageAlmost:   "📍 Position #{{.Position}} - You're almost up! Estimated wait: {{.Wait}}",
+	messagePosition: "📋 Position #{{.Position}} in {{.QueueName}}{{if .PriorityAhead}} ({{.PriorityAhead}} priority ahead of you){{end}}. Estimated wait: {{.Wait}}{{if .FasterQueue}} 💡 {{.FasterQueue}} is faster right now: about {{.FasterWaitMinutes}} minutes.{{end}}",
+	messageReminder: "📅 Reminder: your appointment at {{.QueueName}} is at {{.AppointmentTime}}. Please check in when you arrive.",
+	messageNoShow:   "🚫 We missed you for your {{.AppointmentTime}} appointment at {{.QueueName}}, so the slot has been released. Walk-ins currently wait {{.Wait}}.",
+}
+
+// MessageData is what message templates can refer to
+type MessageData struct {
+	QueueName string
+	Position  int
+
+	// EffectivePosition discounts the customers ahead who are likely to
+	// leave before being served; it equals Position until abandonment has
+	// been learned for the queue
+	EffectivePosition int
+
+	// Priority is the customer's priority class, e.g. "standard"
+	Priority string
+
+	// PriorityAhead is how many priority customers who joined later are
+	// ahead of the customer
+	PriorityAhead int
+
+	// FasterQueue names another queue served by the same counters that
+	// someone joining now would get through noticeably sooner, and
+	// FasterWaitMinutes its expected wait; empty when there is none
+	FasterQueue       string
+	FasterWaitMinutes int
+
+	// AppointmentTime is the local time of the appointment, e.g. "14:30",
+	// for reminder and no_show messages
+	AppointmentTime string
+
+	// Wait is the ready-formatted estimate, e.g. "10–18 minutes"
+	Wait string
+
+	// Raw estimate in whole minutes: expected wait, p50 and p90
+	WaitMinutes     int
+	WaitLowMinutes  int
+	WaitHighMinutes int
+}
+
+// MessageTemplates renders customer messages by kind
+type MessageTemplates struct {
+	templates map[string]*template.Template
+}
+
+// NewMessageTemplates parses templates by kind, using the defaults for any
+// kind not given
+func NewMessageTemplates(overrides map[string]string) (*MessageTemplates, error) {
+	m := &MessageTemplates{templates: make(map[string]*template.Template)}
+
+	for kind, text := range defaultMessageTemplates {
+		if override, ok := overrides[kind]; ok {
+			text = override
+		}
+
+		tmpl, err := template.New(kind).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s message template: %w", kind, err)
+		}
+		m.templates[kind] = tmpl
+	}
+
+	for kind := range overrides {
+		if _, ok := defaultMessageTemplates[kind]; !ok {
+			return nil, fmt.Errorf("unknown message template %q", kind)
+		}
+	}
+
+	return m, nil
+}
+
+// Render produces the message of the given kind
+func (m *MessageTemplates) Render(kind string, data MessageData) (string, error) {
+	tmpl, ok := m.templates[kind]
+	if !ok {
+		return "", fmt.Errorf("unknown message template %q", kind)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render %s message: %w", kind, err)
+	}
+	return buf.String(), nil
+}
+
+// newMessageData fills in the wait fields from an estimate
+func newMessageData(queueName string, position int, wait time.Duration, waitRange WaitRange) MessageData {
+	return MessageData{
+		QueueName:         queueName,
+		Position:          position,
+		EffectivePosition: position,
+		Wait:              formatWaitRange(waitRange),
+		WaitMinutes:       int(wait.Minutes()),
+		WaitLowMinutes:    int(waitRange.P50.Minutes()),
+		WaitHighMinutes:   int(waitRange.P90.Minutes()),
+	}
+}
+
+// formatWaitRange renders a p50-p90 range for customers. Anything likely
+// under two minutes reads "0–2 minutes"; ranges that collapse to a single
+// minute value are shown as one number.
+func formatWaitRange(waitRange WaitRange) string {
+	low := int(waitRange.P50.Minutes())
+	high := int(waitRange.P90.Minutes())
+
+	switch {
+	case high < 2:
+		return "0–2 minutes"
+	case low >= high:
+		return fmt.Sprintf("%d minutes", high)
+	default:
+		return fmt.Sprintf("%d–%d minutes", low, high)
+	}
+}
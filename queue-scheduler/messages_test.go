@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatWaitRange(t *testing.T) {
+	tests := []struct {
+		name string
+		r    WaitRange
+		want string
+	}{
+		{"under two minutes", WaitRange{30 * time.Second, 90 * time.Second}, "0–2 minutes"},
+		{"single value", WaitRange{6 * time.Minute, 6*time.Minute + 40*time.Second}, "6 minutes"},
+		{"range", WaitRange{10 * time.Minute, 18 * time.Minute}, "10–18 minutes"},
+		{"low end under two minutes", WaitRange{time.Minute, 4 * time.Minute}, "1–4 minutes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatWaitRange(tt.r); got != tt.want {
+				t.Errorf("formatWaitRange(%v) = %q, want %q", tt.r, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageTemplates(t *testing.T) {
+	data := newMessageData("Clinic", 3, 12*time.Minute, WaitRange{10 * time.Minute, 18 * time.Minute})
+
+	defaults, err := NewMessageTemplates(nil)
+	if err != nil {
+		t.Fatalf("NewMessageTemplates: %v", err)
+	}
+	got, err := defaults.Render(messagePosition, data)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if want := "📋 Position #3 in Clinic. Estimated wait: 10–18 minutes"; got != want {
+		t.Errorf("default position message = %q, want %q", got, want)
+	}
+
+	custom, err := NewMessageTemplates(map[string]string{
+		messagePosition: "#{{.Position}}: {{.WaitLowMinutes}} to {{.WaitHighMinutes}} min (about {{.WaitMinutes}})",
+	})
+	if err != nil {
+		t.Fatalf("NewMessageTemplates with override: %v", err)
+	}
+	got, _ = custom.Render(messagePosition, data)
+	if want := "#3: 10 to 18 min (about 12)"; got != want {
+		t.Errorf("custom message = %q, want %q", got, want)
+	}
+	// Kinds without an override keep their default
+	if got, _ := custom.Render(messageServing, data); !strings.Contains(got, "now being served") {
+		t.Errorf("serving message = %q, want default", got)
+	}
+
+	for name, overrides := range map[string]map[string]string{
+		"unknown kind": {"farewell": "bye"},
+		"bad syntax":   {messageNext: "{{.Wait"},
+	} {
+		if _, err := NewMessageTemplates(overrides); err == nil {
+			t.Errorf("%s: NewMessageTemplates succeeded, want error", name)
+		}
+	}
+	bad, _ := NewMessageTemplates(map[string]string{messageNext: "{{.Nope}}"})
+	if _, err := bad.Render(messageNext, data); err == nil {
+		t.Error("rendering unknown field succeeded, want error")
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // QueueEntry represents a customer in a queue, matching Django model
 type QueueEntry struct {
@@ -12,15 +15,40 @@ type QueueEntry struct {
 	Status    string     `json:"status"` // "waiting", "in_progress", "served"
 	StartedAt *time.Time `json:"started_at"`
 	ServedAt  *time.Time `json:"served_at"`
+	LeftAt    *time.Time `json:"left_at"`  // nil for older API payloads
+	Priority  string     `json:"priority"` // "standard", "assisted", "appointment"; empty for older API payloads
+}
+
+// Key returns the identity used to track an entry across polls. It is the
+// Django entry ID when present; older API payloads without IDs fall back to
+// MSISDN plus join time, which still tells a rejoining customer apart from
+// their earlier entry.
+func (e QueueEntry) Key() string {
+	if e.ID != "" {
+		return e.ID
+	}
+	return fmt.Sprintf("msisdn:%s@%s", e.MSISDN, e.JoinedAt.UTC().Format(time.RFC3339Nano))
+}
+
+// Appointment is a booked slot in a queue, matching Django model. On check-in
+// Django creates a walk-in entry in the appointment lane and links it.
+type Appointment struct {
+	ID          string    `json:"id"`
+	MSISDN      string    `json:"msisdn"`
+	FullName    *string   `json:"full_name"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+	Status      string    `json:"status"`   // "booked", "checked_in", "no_show", "cancelled"
+	EntryID     *string   `json:"entry_id"` // set once checked in
 }
 
 // Queue represents a service queue, matching Django model
 type Queue struct {
-	QueueID     string       `json:"queue_id"`
-	Name        string       `json:"name"`
-	Description string       `json:"description"`
-	CreatedAt   time.Time    `json:"created_at"`
-	Entries     []QueueEntry `json:"entries"`
+	QueueID      string        `json:"queue_id"`
+	Name         string        `json:"name"`
+	Description  string        `json:"description"`
+	CreatedAt    time.Time     `json:"created_at"`
+	Entries      []QueueEntry  `json:"entries"`
+	Appointments []Appointment `json:"appointments"` // from the last day onwards; nil for older API payloads
 }
 
 // APIResponse represents the response from Django /queues/all/ endpoint
@@ -32,15 +60,31 @@ type APIResponse struct {
 type QueueStats struct {
 	QueueID            string
 	AverageProcessTime time.Duration // Average time from in_progress -> served
-	EstimatedWaitTime  time.Duration // Estimated wait based on position
-	ActiveEntries      int           // Number of people still waiting
+	EstimatedWaitTime  time.Duration // Estimated wait for someone joining now
+	ActiveEntries      int           // Number of people still waiting or being served
+	Servers            int           // Counters serving the queue (configured or inferred)
+	BusyServers        int           // Counters currently serving someone
+
+	EffectiveLength      float64 // Waiting customers expected to stay until served
+	ExpectedAbandonments float64 // Waiting customers expected to leave first
+
+	ReservedSlots int // Booked appointments still holding a counter slot
+
+	ServiceTimeP50 time.Duration // Median recent service time
+	ServiceTimeP90 time.Duration // 90th percentile recent service time
+
+	ProfileServiceTime time.Duration // Weekday/hour profile for now, zero without enough history
+	WaitP50            time.Duration // Median wait for someone joining now
+	WaitP90            time.Duration // 90th percentile wait for someone joining now
 }
 
 // AlertRequest represents a notification to be sent
 type AlertRequest struct {
-	MSISDN    string
-	Message   string
-	Channel   string // "whatsapp", "ussd", "websocket"
-	QueueID   string
-	Timestamp time.Time
-}
\ No newline at end of file
+	EntryID   string    `json:"entry_id"` // QueueEntry.Key() of the recipient's entry
+	MSISDN    string    `json:"msisdn"`
+	Message   string    `json:"message"`
+	Channel   string    `json:"channel"` // "whatsapp", "ussd", "websocket"
+	QueueID   string    `json:"queue_id"`
+	Kind      string    `json:"kind,omitempty"` // message kind, e.g. "serving"; empty in older recordings
+	Timestamp time.Time `json:"timestamp"`
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSwitchMinSaving is how much sooner another queue must be before a
+// customer is told about it
+const defaultSwitchMinSaving = 10 * time.Minute
+
+// CounterPool is a set of counters whose staff serve several queues. The
+// queues take turns at the pool's counters: whenever a counter frees up, the
+// next queue in rotation with someone waiting is called from.
+type CounterPool struct {
+	Name     string
+	Counters int
+	Queues   []string
+}
+
+// SetCounterPool makes the pool's queues share its counters, replacing any
+// pool they were in before
+func (calc *QueueCalculator) SetCounterPool(pool CounterPool) {
+	for _, queueID := range pool.Queues {
+		calc.pools[queueID] = &pool
+	}
+}
+
+// alternatives returns the other queues served by a queue's counter pool
+func (calc *QueueCalculator) alternatives(queueID string) []string {
+	pool, ok := calc.pools[queueID]
+	if !ok {
+		return nil
+	}
+
+	var others []string
+	for _, other := range pool.Queues {
+		if other != queueID {
+			others = append(others, other)
+		}
+	}
+	return others
+}
+
+// EstimateQueues estimates every queue of a snapshot, keyed by queue ID.
+// Queues sharing a counter pool are simulated together so each one's waits
+// account for the others; the rest are estimated as by EstimateQueue.
+func (calc *QueueCalculator) EstimateQueues(queues []Queue) map[string]QueueEstimate {
+	return calc.estimateQueues(queues, calc.estimator)
+}
+
+// estimateQueues is EstimateQueues with the given service time estimator
+func (calc *QueueCalculator) estimateQueues(queues []Queue, estimator ServiceTimeEstimator) map[string]QueueEstimate {
+	now := calc.clock.Now()
+	estimates := make(map[string]QueueEstimate, len(queues))
+
+	// Lanes of the pooled queues present in the snapshot, in pool order
+	pooled := make(map[*CounterPool][]*queueLane)
+	var pools []*CounterPool
+	for _, queue := range queues {
+		pool, ok := calc.pools[queue.QueueID]
+		if !ok {
+			estimates[queue.QueueID] = calc.estimateQueue(queue, estimator)
+			continue
+		}
+		if _, seen := pooled[pool]; !seen {
+			pools = append(pools, pool)
+		}
+		pooled[pool] = append(pooled[pool], calc.newLane(queue, estimator, now))
+	}
+
+	for _, pool := range pools {
+		lanes := pooled[pool]
+		calc.simulateLanes(lanes, pool.Counters, now, "pool:"+pool.Name)
+		for _, lane := range lanes {
+			estimates[lane.queue.QueueID] = lane.estimate
+		}
+	}
+
+	return estimates
+}
+
+// laneSlot is one customer in a counter simulation: waiting customer index
+// of a lane, or index len(waiting) for a customer joining that lane now
+type laneSlot struct {
+	lane, index int
+}
+
+// callOrder is the order lanes' waiting customers are called in: round
+// robin between the lanes, starting with the one whose next customer has
+// waited longest. With join >= 0, a customer joining that lane now is added
+// at its back.
+func callOrder(lanes []*queueLane, join int) []laneSlot {
+	sizes := make([]int, len(lanes))
+	total := 0
+	start := 0
+	for l, lane := range lanes {
+		sizes[l] = len(lane.waiting)
+		if l == join {
+			sizes[l]++
+		}
+		total += sizes[l]
+
+		if len(lane.waiting) > 0 && (len(lanes[start].waiting) == 0 ||
+			lane.waiting[0].JoinedAt.Before(lanes[start].waiting[0].JoinedAt)) {
+			start = l
+		}
+	}
+
+	order := make([]laneSlot, 0, total)
+	next := make([]int, len(lanes))
+	for l := start; len(order) < total; l = (l + 1) % len(lanes) {
+		if next[l] < sizes[l] {
+			order = append(order, laneSlot{lane: l, index: next[l]})
+			next[l]++
+		}
+	}
+	return order
+}
+
+// joiningSlot returns the position in order of the customer joining lane
+func joiningSlot(order []laneSlot, lane, waiting int) int {
+	for i, slot := range order {
+		if slot.lane == lane && slot.index == waiting {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseCounterPools parses "name=counters:QUEUE+QUEUE,..." e.g.
+// "tellers=3:ABC123+XYZ789". A queue may only be in one pool.
+func parseCounterPools(value string) ([]CounterPool, error) {
+	var pools []CounterPool
+	seen := make(map[string]string)
+
+	for _, spec := range strings.Split(value, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		name, rest, ok := strings.Cut(spec, "=")
+		count, queueList, ok2 := strings.Cut(rest, ":")
+		counters, err := strconv.Atoi(count)
+		if !ok || !ok2 || name == "" || err != nil || counters < 1 {
+			return nil, fmt.Errorf("invalid counter pool %q, want name=counters:QUEUE+QUEUE", spec)
+		}
+
+		pool := CounterPool{Name: name, Counters: counters}
+		for _, queueID := range strings.Split(queueList, "+") {
+			queueID = strings.TrimSpace(queueID)
+			if queueID == "" {
+				return nil, fmt.Errorf("invalid counter pool %q: empty queue ID", spec)
+			}
+			if other, dup := seen[queueID]; dup {
+				return nil, fmt.Errorf("queue %s is in counter pools %s and %s", queueID, other, name)
+			}
+			seen[queueID] = name
+			pool.Queues = append(pool.Queues, queueID)
+		}
+		pools = append(pools, pool)
+	}
+
+	return pools, nil
+}
+
+// SetSwitchMinSaving changes how much sooner another queue must be before a
+// customer is told about it. Zero disables the suggestions.
+func (s *Scheduler) SetSwitchMinSaving(saving time.Duration) {
+	s.switchMinSaving = saving
+}
+
+// fasterQueue looks for another queue of the customer's counter pool that
+// someone joining now would get through at least switchMinSaving sooner than the
+// customer's own expected wait, returning the best one
+func (s *Scheduler) fasterQueue(queueID string, wait time.Duration, queues map[string]Queue, estimates map[string]QueueEstimate) (Queue, time.Duration, bool) {
+	if s.switchMinSaving <= 0 {
+		return Queue{}, 0, false
+	}
+
+	var best Queue
+	var bestWait time.Duration
+	found := false
+	for _, other := range s.calculator.alternatives(queueID) {
+		queue, ok := queues[other]
+		if !ok {
+			continue
+		}
+		otherWait := estimates[other].Stats.EstimatedWaitTime
+		if wait-otherWait >= s.switchMinSaving && (!found || otherWait < bestWait) {
+			best, bestWait, found = queue, otherWait, true
+		}
+	}
+	return best, bestWait, found
+}
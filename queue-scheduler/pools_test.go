@@ -0,0 +1,163 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCounterPools(t *testing.T) {
+	pools, err := parseCounterPools(" tellers=3:ABC+XYZ , help=1:HLP")
+	if err != nil {
+		t.Fatalf("parseCounterPools: %v", err)
+	}
+	want := []CounterPool{
+		{Name: "tellers", Counters: 3, Queues: []string{"ABC", "XYZ"}},
+		{Name: "help", Counters: 1, Queues: []string{"HLP"}},
+	}
+	if !reflect.DeepEqual(pools, want) {
+		t.Errorf("pools = %+v, want %+v", pools, want)
+	}
+
+	for _, value := range []string{
+		"tellers",
+		"tellers=3",
+		"=3:ABC",
+		"tellers=0:ABC",
+		"tellers=x:ABC",
+		"tellers=2:ABC+",
+		"tellers=2:ABC,help=1:ABC",
+	} {
+		if _, err := parseCounterPools(value); err == nil {
+			t.Errorf("parseCounterPools(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestCallOrder(t *testing.T) {
+	lanes := []*queueLane{
+		{waiting: []QueueEntry{waitingEntry("A1", 5*time.Minute), waitingEntry("A2", 4*time.Minute)}},
+		{waiting: []QueueEntry{waitingEntry("B1", 8*time.Minute)}},
+		{},
+	}
+
+	tests := []struct {
+		name string
+		join int
+		want []laneSlot
+	}{
+		{"no one joining", -1, []laneSlot{{1, 0}, {0, 0}, {0, 1}}},
+		{"joining the longer lane", 0, []laneSlot{{1, 0}, {0, 0}, {0, 1}, {0, 2}}},
+		{"joining the empty lane", 2, []laneSlot{{1, 0}, {2, 0}, {0, 0}, {0, 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := callOrder(lanes, tt.join); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("callOrder = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// pooledQueues returns two queues with four minute services, A with two
+// customers waiting since 10 and 6 minutes ago, B with two since 8 and 4
+func pooledQueues() []Queue {
+	return []Queue{
+		{QueueID: "A", Name: "Queue A", Entries: []QueueEntry{
+			servedEntry("A0", time.Minute, 4*time.Minute),
+			waitingEntry("A1", 10*time.Minute),
+			waitingEntry("A2", 6*time.Minute),
+		}},
+		{QueueID: "B", Name: "Queue B", Entries: []QueueEntry{
+			servedEntry("B0", 2*time.Minute, 4*time.Minute),
+			waitingEntry("B1", 8*time.Minute),
+			waitingEntry("B2", 4*time.Minute),
+		}},
+	}
+}
+
+func TestEstimateQueuesSharesPoolCounters(t *testing.T) {
+	calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+	calc.SetServerCount("A", 1)
+	calc.SetServerCount("B", 1)
+
+	// On their own each queue has its own counter
+	standalone := calc.EstimateQueues(pooledQueues())
+	if got, want := standalone["A"].Waits, map[string]time.Duration{"A1": 0, "A2": 4 * time.Minute}; !reflect.DeepEqual(got, want) {
+		t.Errorf("standalone A waits = %v, want %v", got, want)
+	}
+
+	// Sharing one counter the queues take turns, oldest first
+	calc.SetCounterPool(CounterPool{Name: "desk", Counters: 1, Queues: []string{"A", "B"}})
+	estimates := calc.EstimateQueues(pooledQueues())
+
+	wantWaits := map[string]map[string]time.Duration{
+		"A": {"A1": 0, "A2": 8 * time.Minute},
+		"B": {"B1": 4 * time.Minute, "B2": 12 * time.Minute},
+	}
+	for queueID, want := range wantWaits {
+		estimate := estimates[queueID]
+		if !reflect.DeepEqual(estimate.Waits, want) {
+			t.Errorf("queue %s waits = %v, want %v", queueID, estimate.Waits, want)
+		}
+		if estimate.Stats.EstimatedWaitTime != 16*time.Minute {
+			t.Errorf("queue %s EstimatedWaitTime = %v, want 16m", queueID, estimate.Stats.EstimatedWaitTime)
+		}
+		if estimate.Stats.Servers != 1 {
+			t.Errorf("queue %s Servers = %d, want the pool's 1", queueID, estimate.Stats.Servers)
+		}
+	}
+	if got := estimates["B"].Positions["B2"]; got != 2 {
+		t.Errorf("B2 position = %d, want 2 within its own queue", got)
+	}
+}
+
+func TestFasterQueueSuggestion(t *testing.T) {
+	long := Queue{QueueID: "A", Name: "Branch A", Entries: []QueueEntry{
+		servedEntry("A0", time.Minute, 10*time.Minute),
+	}}
+	for i, joinedAgo := range []time.Duration{25, 20, 15, 10, 5} {
+		long.Entries = append(long.Entries, waitingEntry("A"+string(rune('1'+i)), joinedAgo*time.Minute))
+	}
+	short := Queue{QueueID: "B", Name: "Branch B", Entries: []QueueEntry{
+		servedEntry("B0", time.Minute, 10*time.Minute),
+	}}
+	django := newFakeDjango(t, long, short)
+	graph := newFakeGraphAPI(t)
+	scheduler, _ := newTestScheduler(t, django, graph)
+	scheduler.calculator.SetCounterPool(CounterPool{Name: "desk", Counters: 1, Queues: []string{"A", "B"}})
+
+	scheduler.processQueues()
+
+	// Someone joining B now is called right after A1, in 10 minutes; A2
+	// waits as long and A3 only 10 minutes longer
+	got := messagesTo(graph)
+	const tip = "💡 Branch B is faster right now: about 10 minutes."
+	for _, msisdn := range []string{"2760A3", "2760A4", "2760A5"} {
+		if len(got[msisdn]) != 1 || !strings.Contains(got[msisdn][0], tip) {
+			t.Errorf("messages to %s = %q, want one containing %q", msisdn, got[msisdn], tip)
+		}
+	}
+	for _, msisdn := range []string{"2760A1", "2760A2"} {
+		for _, message := range got[msisdn] {
+			if strings.Contains(message, "💡") {
+				t.Errorf("message to %s = %q suggests switching", msisdn, message)
+			}
+		}
+	}
+
+	// Disabled, nobody is told
+	quiet := newFakeGraphAPI(t)
+	disabled, _ := newTestScheduler(t, django, quiet)
+	disabled.calculator.SetCounterPool(CounterPool{Name: "desk", Counters: 1, Queues: []string{"A", "B"}})
+	disabled.SetSwitchMinSaving(0)
+	disabled.processQueues()
+	for msisdn, messages := range messagesTo(quiet) {
+		for _, message := range messages {
+			if strings.Contains(message, "💡") {
+				t.Errorf("message to %s = %q suggests switching while disabled", msisdn, message)
+			}
+		}
+	}
+}
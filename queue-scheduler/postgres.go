@@ -0,0 +1,218 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// queueChangeChannel is the NOTIFY channel raised by the Django trigger
+// installed in migration 0007_queue_change_notify
+const queueChangeChannel = "minaturn_queue_changed"
+
+// allQueuesQuery mirrors the Django /queues/all/ view: every queue with its
+// entries ordered by join time
+const allQueuesQuery = `
+SELECT q.id, q.name, COALESCE(q.description, ''), q.created_at,
+       e.id, e.msisdn, e.full_name, e.joined_at, e."left", e.status,
+       e.started_at, e.served_at, e.left_at, e.priority
+FROM minaturn_queue q
+LEFT JOIN minaturn_queueentry e ON e.queue_id = q.id
+ORDER BY q.created_at, q.id, e.joined_at`
+
+// appointmentsQuery mirrors the appointments listed by the Django
+// /queues/all/ view: those scheduled from a day ago onwards
+const appointmentsQuery = `
+SELECT queue_id, id, msisdn, full_name, scheduled_at, status, entry_id
+FROM minaturn_appointment
+WHERE scheduled_at >= now() - interval '1 day'
+ORDER BY scheduled_at`
+
+// PostgresSource reads queues straight from the Django database
+type PostgresSource struct {
+	db       *sql.DB
+	listener *pq.Listener
+	changes  chan struct{}
+}
+
+// NewPostgresSource connects to the Django database and subscribes to change
+// notifications. If LISTEN isn't available the source still works and the
+// scheduler falls back to polling.
+func NewPostgresSource(databaseURL string) (*PostgresSource, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(2)
+
+	source := &PostgresSource{
+		db:      db,
+		changes: make(chan struct{}, 1),
+	}
+
+	listener := pq.NewListener(databaseURL, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Postgres listener %v: %v", event, err)
+		}
+	})
+	if err := listener.Listen(queueChangeChannel); err != nil {
+		log.Printf("⚠️  Warning: LISTEN %s failed, relying on polling: %v", queueChangeChannel, err)
+		listener.Close()
+	} else {
+		source.listener = listener
+		go source.forwardNotifications()
+	}
+
+	return source, nil
+}
+
+// GetAllQueues loads all queues and their entries in a single query
+func (p *PostgresSource) GetAllQueues() (*APIResponse, error) {
+	rows, err := p.db.Query(allQueuesQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query queues: %w", err)
+	}
+	defer rows.Close()
+
+	var apiResp APIResponse
+	index := make(map[string]int)
+
+	for rows.Next() {
+		var (
+			queue     Queue
+			entryID   sql.NullString
+			msisdn    sql.NullString
+			fullName  sql.NullString
+			joinedAt  sql.NullTime
+			left      sql.NullBool
+			status    sql.NullString
+			startedAt sql.NullTime
+			servedAt  sql.NullTime
+			leftAt    sql.NullTime
+			priority  sql.NullString
+		)
+
+		if err := rows.Scan(&queue.QueueID, &queue.Name, &queue.Description, &queue.CreatedAt,
+			&entryID, &msisdn, &fullName, &joinedAt, &left, &status, &startedAt, &servedAt, &leftAt, &priority); err != nil {
+			return nil, fmt.Errorf("failed to scan queue row: %w", err)
+		}
+
+		i, seen := index[queue.QueueID]
+		if !seen {
+			queue.Entries = []QueueEntry{}
+			apiResp.Queues = append(apiResp.Queues, queue)
+			i = len(apiResp.Queues) - 1
+			index[queue.QueueID] = i
+		}
+
+		// LEFT JOIN yields a single row of NULLs for queues without entries
+		if !entryID.Valid {
+			continue
+		}
+
+		entry := QueueEntry{
+			ID:        entryID.String,
+			MSISDN:    msisdn.String,
+			JoinedAt:  joinedAt.Time,
+			Left:      left.Bool,
+			Status:    status.String,
+			StartedAt: nullTimePtr(startedAt),
+			ServedAt:  nullTimePtr(servedAt),
+			LeftAt:    nullTimePtr(leftAt),
+			Priority:  priority.String,
+		}
+		if fullName.Valid {
+			entry.FullName = &fullName.String
+		}
+
+		apiResp.Queues[i].Entries = append(apiResp.Queues[i].Entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read queue rows: %w", err)
+	}
+
+	if err := p.loadAppointments(&apiResp, index); err != nil {
+		return nil, err
+	}
+
+	return &apiResp, nil
+}
+
+// loadAppointments attaches upcoming and recent appointments to their queues,
+// located by index
+func (p *PostgresSource) loadAppointments(apiResp *APIResponse, index map[string]int) error {
+	rows, err := p.db.Query(appointmentsQuery)
+	if err != nil {
+		return fmt.Errorf("failed to query appointments: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			queueID     string
+			appointment Appointment
+			fullName    sql.NullString
+			entryID     sql.NullString
+		)
+		if err := rows.Scan(&queueID, &appointment.ID, &appointment.MSISDN, &fullName,
+			&appointment.ScheduledAt, &appointment.Status, &entryID); err != nil {
+			return fmt.Errorf("failed to scan appointment row: %w", err)
+		}
+		if fullName.Valid {
+			appointment.FullName = &fullName.String
+		}
+		if entryID.Valid {
+			appointment.EntryID = &entryID.String
+		}
+
+		i, ok := index[queueID]
+		if !ok {
+			continue // queue created since the first query
+		}
+		apiResp.Queues[i].Appointments = append(apiResp.Queues[i].Appointments, appointment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read appointment rows: %w", err)
+	}
+	return nil
+}
+
+// Changes signals when the database reports a queue change. Bursts of
+// notifications are coalesced into a single pending signal.
+func (p *PostgresSource) Changes() <-chan struct{} {
+	return p.changes
+}
+
+// Close releases the listener and database connections
+func (p *PostgresSource) Close() error {
+	if p.listener != nil {
+		p.listener.Close()
+	}
+	return p.db.Close()
+}
+
+// forwardNotifications turns NOTIFY messages into change signals. A nil
+// notification means the listener reconnected and may have missed events,
+// so it is treated as a change too.
+func (p *PostgresSource) forwardNotifications() {
+	for range p.listener.Notify {
+		select {
+		case p.changes <- struct{}{}:
+		default: // a signal is already pending
+		}
+	}
+}
+
+// nullTimePtr converts a nullable timestamp to the model's pointer form
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	value := t.Time
+	return &value
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Priority classes, matching the Django QueueEntry.Priority choices
+const (
+	priorityStandard    = "standard"
+	priorityAssisted    = "assisted"    // elderly or disabled customers
+	priorityAppointment = "appointment" // appointment holders
+)
+
+// defaultPriorityRanks orders the classes for strict priority, highest first
+var defaultPriorityRanks = []string{priorityAppointment, priorityAssisted, priorityStandard}
+
+// defaultPriorityWeights are the weighted fair shares of the classes
+var defaultPriorityWeights = map[string]int{
+	priorityAppointment: 3,
+	priorityAssisted:    2,
+	priorityStandard:    1,
+}
+
+// PriorityClass returns the entry's priority class. Older API payloads
+// without one are standard.
+func (e QueueEntry) PriorityClass() string {
+	if e.Priority == "" {
+		return priorityStandard
+	}
+	return e.Priority
+}
+
+// PriorityPolicy decides the order waiting customers are expected to be
+// called in. Order gets the waiting entries in join order and must return
+// the same entries, reordered.
+type PriorityPolicy interface {
+	Name() string
+	Order(waiting []QueueEntry) []QueueEntry
+}
+
+// FIFOPolicy calls customers in join order, ignoring their class
+type FIFOPolicy struct{}
+
+func (FIFOPolicy) Name() string { return "fifo" }
+
+func (FIFOPolicy) Order(waiting []QueueEntry) []QueueEntry { return waiting }
+
+// StrictPriorityPolicy always calls the highest class waiting first, in join
+// order within a class. Classes lists the classes highest first; unlisted
+// classes rank below all listed ones.
+type StrictPriorityPolicy struct {
+	Classes []string
+}
+
+func (p StrictPriorityPolicy) Name() string { return "strict:" + strings.Join(p.Classes, ">") }
+
+func (p StrictPriorityPolicy) Order(waiting []QueueEntry) []QueueEntry {
+	rank := func(entry QueueEntry) int {
+		for i, class := range p.Classes {
+			if class == entry.PriorityClass() {
+				return i
+			}
+		}
+		return len(p.Classes)
+	}
+
+	ordered := append([]QueueEntry(nil), waiting...)
+	sort.SliceStable(ordered, func(i, j int) bool { return rank(ordered[i]) < rank(ordered[j]) })
+	return ordered
+}
+
+// WeightedFairPolicy interleaves the classes in proportion to their weights,
+// so lower classes keep moving while priority customers wait: with weights
+// appointment=3, standard=1, three appointment holders are called for every
+// standard customer while both are waiting. Within a class customers go in
+// join order. Unlisted classes have weight 1.
+//
+// Positions are predicted afresh at every poll from the customers waiting
+// then, using smooth weighted round robin.
+type WeightedFairPolicy struct {
+	Weights map[string]int
+}
+
+func (p WeightedFairPolicy) Name() string {
+	classes := make([]string, 0, len(p.Weights))
+	for class := range p.Weights {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	parts := make([]string, len(classes))
+	for i, class := range classes {
+		parts[i] = fmt.Sprintf("%s=%d", class, p.Weights[class])
+	}
+	return "weighted:" + strings.Join(parts, ",")
+}
+
+func (p WeightedFairPolicy) Order(waiting []QueueEntry) []QueueEntry {
+	// Classes in order of their longest-waiting customer, which breaks ties
+	var classes []string
+	lanes := make(map[string][]QueueEntry)
+	for _, entry := range waiting {
+		class := entry.PriorityClass()
+		if _, ok := lanes[class]; !ok {
+			classes = append(classes, class)
+		}
+		lanes[class] = append(lanes[class], entry)
+	}
+
+	weight := func(class string) int {
+		if w, ok := p.Weights[class]; ok {
+			return w
+		}
+		return 1
+	}
+
+	ordered := make([]QueueEntry, 0, len(waiting))
+	credit := make(map[string]int, len(classes))
+	for len(ordered) < len(waiting) {
+		best, total := "", 0
+		for _, class := range classes {
+			if len(lanes[class]) == 0 {
+				continue
+			}
+			credit[class] += weight(class)
+			total += weight(class)
+			if best == "" || credit[class] > credit[best] {
+				best = class
+			}
+		}
+
+		ordered = append(ordered, lanes[best][0])
+		lanes[best] = lanes[best][1:]
+		credit[best] -= total
+	}
+	return ordered
+}
+
+// ParsePriorityPolicy parses "fifo", "strict[:class>class>...]" or
+// "weighted[:class=weight,...]". Without a parameter strict ranks
+// appointment > assisted > standard and weighted uses 3:2:1.
+func ParsePriorityPolicy(spec string) (PriorityPolicy, error) {
+	name, param, hasParam := strings.Cut(strings.TrimSpace(spec), ":")
+
+	switch name {
+	case "fifo":
+		return FIFOPolicy{}, nil
+
+	case "strict":
+		if !hasParam {
+			return StrictPriorityPolicy{Classes: defaultPriorityRanks}, nil
+		}
+		var classes []string
+		for _, class := range strings.Split(param, ">") {
+			class = strings.TrimSpace(class)
+			if class == "" {
+				return nil, fmt.Errorf("invalid strict priority order %q", param)
+			}
+			classes = append(classes, class)
+		}
+		return StrictPriorityPolicy{Classes: classes}, nil
+
+	case "weighted":
+		if !hasParam {
+			return WeightedFairPolicy{Weights: defaultPriorityWeights}, nil
+		}
+		weights := make(map[string]int)
+		for _, pair := range strings.Split(param, ",") {
+			class, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			weight, err := strconv.Atoi(value)
+			if !ok || class == "" || err != nil || weight < 1 {
+				return nil, fmt.Errorf("invalid priority weight %q", pair)
+			}
+			weights[class] = weight
+		}
+		return WeightedFairPolicy{Weights: weights}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown priority policy %q", spec)
+	}
+}
+
+// priorityAhead counts, for each waiting customer in call order, the
+// customers ahead of them in a priority class who joined after them
+func priorityAhead(ordered []QueueEntry) map[string]int {
+	counts := make(map[string]int)
+	for i, entry := range ordered {
+		for _, ahead := range ordered[:i] {
+			if ahead.PriorityClass() != priorityStandard && ahead.JoinedAt.After(entry.JoinedAt) {
+				counts[entry.Key()]++
+			}
+		}
+	}
+	return counts
+}
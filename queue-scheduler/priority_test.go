@@ -0,0 +1,150 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// classEntry builds a waiting entry of the given class that joined joinedAgo
+// before testNow
+func classEntry(id, class string, joinedAgo time.Duration) QueueEntry {
+	entry := waitingEntry(id, joinedAgo)
+	entry.Priority = class
+	return entry
+}
+
+func entryIDs(entries []QueueEntry) []string {
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	return ids
+}
+
+func TestPriorityPolicies(t *testing.T) {
+	// In join order: S1 A1 S2 P1 S3 A2 (S standard, A assisted, P appointment)
+	waiting := []QueueEntry{
+		classEntry("S1", "", 30*time.Minute), // older payload: standard
+		classEntry("A1", priorityAssisted, 25*time.Minute),
+		classEntry("S2", priorityStandard, 20*time.Minute),
+		classEntry("P1", priorityAppointment, 15*time.Minute),
+		classEntry("S3", priorityStandard, 10*time.Minute),
+		classEntry("A2", priorityAssisted, 5*time.Minute),
+	}
+
+	tests := []struct {
+		spec string
+		name string
+		want []string
+	}{
+		{"fifo", "fifo", []string{"S1", "A1", "S2", "P1", "S3", "A2"}},
+		{"strict", "strict:appointment>assisted>standard", []string{"P1", "A1", "A2", "S1", "S2", "S3"}},
+		{"strict:assisted>appointment", "strict:assisted>appointment", []string{"A1", "A2", "P1", "S1", "S2", "S3"}},
+		// Equal weights alternate between the lanes, longest waiting first
+		{"weighted:standard=1,assisted=1,appointment=1", "weighted:appointment=1,assisted=1,standard=1",
+			[]string{"S1", "A1", "P1", "S2", "A2", "S3"}},
+		{"weighted:assisted=2", "weighted:assisted=2", []string{"A1", "S1", "P1", "A2", "S2", "S3"}},
+	}
+
+	for _, tt := range tests {
+		policy, err := ParsePriorityPolicy(tt.spec)
+		if err != nil {
+			t.Fatalf("ParsePriorityPolicy(%q): %v", tt.spec, err)
+		}
+		if policy.Name() != tt.name {
+			t.Errorf("%s: name = %q, want %q", tt.spec, policy.Name(), tt.name)
+		}
+		if got := entryIDs(policy.Order(waiting)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: order = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"lifo", "strict:", "strict:a>>b", "weighted:vip", "weighted:vip=0", "weighted:=2"} {
+		if _, err := ParsePriorityPolicy(spec); err == nil {
+			t.Errorf("ParsePriorityPolicy(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestWeightedFairShares(t *testing.T) {
+	var waiting []QueueEntry
+	for i := 0; i < 8; i++ {
+		waiting = append(waiting,
+			classEntry("S"+string(rune('a'+i)), priorityStandard, time.Duration(60-i)*time.Minute),
+			classEntry("P"+string(rune('a'+i)), priorityAppointment, time.Duration(50-i)*time.Minute))
+	}
+
+	policy := WeightedFairPolicy{Weights: map[string]int{priorityAppointment: 3, priorityStandard: 1}}
+	ordered := policy.Order(waiting)
+
+	// Of the first eight called, six are appointment holders
+	appointments := 0
+	for _, entry := range ordered[:8] {
+		if entry.Priority == priorityAppointment {
+			appointments++
+		}
+	}
+	if appointments != 6 {
+		t.Errorf("first 8 called %v, want 6 appointment holders", entryIDs(ordered[:8]))
+	}
+	if len(ordered) != len(waiting) {
+		t.Errorf("ordered %d entries, want %d", len(ordered), len(waiting))
+	}
+}
+
+func TestEstimateQueueFollowsPriorityPolicy(t *testing.T) {
+	calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+	calc.SetServerCount("Q1", 1)
+	calc.SetPriorityPolicy(StrictPriorityPolicy{Classes: defaultPriorityRanks})
+
+	queue := Queue{QueueID: "Q1", Entries: []QueueEntry{
+		servedEntry("S0", time.Minute, 4*time.Minute),
+		classEntry("W1", priorityStandard, 20*time.Minute),
+		classEntry("W2", priorityStandard, 15*time.Minute),
+		classEntry("V1", priorityAssisted, 2*time.Minute),
+	}}
+	estimate := calc.EstimateQueue(queue)
+
+	wantPositions := map[string]int{"V1": 1, "W1": 2, "W2": 3}
+	if !reflect.DeepEqual(estimate.Positions, wantPositions) {
+		t.Errorf("positions = %v, want %v", estimate.Positions, wantPositions)
+	}
+	wantWaits := map[string]time.Duration{"V1": 0, "W1": 4 * time.Minute, "W2": 8 * time.Minute}
+	if !reflect.DeepEqual(estimate.Waits, wantWaits) {
+		t.Errorf("waits = %v, want %v", estimate.Waits, wantWaits)
+	}
+	wantAhead := map[string]int{"W1": 1, "W2": 1}
+	if !reflect.DeepEqual(estimate.PriorityAhead, wantAhead) {
+		t.Errorf("priority ahead = %v, want %v", estimate.PriorityAhead, wantAhead)
+	}
+	if got := calc.findPositionInQueue(queue, "W2"); got != 3 {
+		t.Errorf("findPositionInQueue(W2) = %d, want 3", got)
+	}
+}
+
+func TestPriorityMessages(t *testing.T) {
+	queue := Queue{QueueID: "Q1", Name: "Clinic", Entries: []QueueEntry{
+		classEntry("W1", priorityStandard, 20*time.Minute),
+		classEntry("W2", priorityStandard, 15*time.Minute),
+		classEntry("W3", priorityStandard, 10*time.Minute),
+		classEntry("V1", priorityAppointment, time.Minute),
+	}}
+	graph := newFakeGraphAPI(t)
+	scheduler, _ := newTestScheduler(t, newFakeDjango(t, queue), graph)
+	scheduler.calculator.SetPriorityPolicy(StrictPriorityPolicy{Classes: defaultPriorityRanks})
+
+	scheduler.processQueues()
+
+	got := messagesTo(graph)
+	for msisdn, want := range map[string]string{
+		"2760V1": "You're NEXT",
+		"2760W1": "Position #2 - You're almost up!",
+		"2760W3": "Position #4 in Clinic (1 priority ahead of you).",
+	} {
+		if len(got[msisdn]) != 1 || !strings.Contains(got[msisdn][0], want) {
+			t.Errorf("messages to %s = %q, want one containing %q", msisdn, got[msisdn], want)
+		}
+	}
+}
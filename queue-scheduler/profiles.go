@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// profileMinSamples is how many services a weekday/hour slot needs
+	// before it is trusted
+	profileMinSamples = 10
+
+	// profileBlendSamples is how many live services it takes for the
+	// profile to stop counting. With fewer, the profile makes up the rest.
+	profileBlendSamples = 5
+
+	// profileMaxSamples caps a slot's count so it keeps following change
+	profileMaxSamples = 500
+
+	// profileOutlierFactor drops services this many times longer than a
+	// trusted slot's mean, so forgotten tickets don't skew it
+	profileOutlierFactor = 4
+
+	profileFileVersion = 1
+)
+
+// ProfileSlot is the running mean service time in one weekday/hour slot
+type ProfileSlot struct {
+	Count float64       `json:"count"`
+	Mean  time.Duration `json:"mean"`
+}
+
+// ServiceProfile holds one queue's service times by weekday and hour of day
+type ServiceProfile struct {
+	Slots [7][24]ProfileSlot `json:"slots"` // indexed by time.Weekday, hour
+}
+
+// ServiceProfiles are historical service time profiles for every queue,
+// bucketed by weekday and hour in a fixed time zone
+type ServiceProfiles struct {
+	location *time.Location
+	queues   map[string]*ServiceProfile
+	dirty    bool
+}
+
+// NewServiceProfiles creates empty profiles bucketed in location
+func NewServiceProfiles(location *time.Location) *ServiceProfiles {
+	return &ServiceProfiles{
+		location: location,
+		queues:   make(map[string]*ServiceProfile),
+	}
+}
+
+// slot returns the slot a time falls into, creating the queue's profile
+func (p *ServiceProfiles) slot(queueID string, at time.Time) *ProfileSlot {
+	profile, ok := p.queues[queueID]
+	if !ok {
+		profile = &ServiceProfile{}
+		p.queues[queueID] = profile
+	}
+	local := at.In(p.location)
+	return &profile.Slots[local.Weekday()][local.Hour()]
+}
+
+// observe adds a service that started at the given time
+func (p *ServiceProfiles) observe(queueID string, startedAt time.Time, service time.Duration) {
+	if service <= 0 {
+		return
+	}
+
+	slot := p.slot(queueID, startedAt)
+	if slot.Count >= profileMinSamples && service > profileOutlierFactor*slot.Mean {
+		return
+	}
+
+	slot.Count++
+	slot.Mean += time.Duration(float64(service-slot.Mean) / slot.Count)
+	if slot.Count > profileMaxSamples {
+		slot.Count = profileMaxSamples
+	}
+	p.dirty = true
+}
+
+// expected returns the profile's mean service time for the slot containing
+// at, if that slot has enough history. It is safe to call on nil profiles.
+func (p *ServiceProfiles) expected(queueID string, at time.Time) (time.Duration, bool) {
+	if p == nil {
+		return 0, false
+	}
+	profile, ok := p.queues[queueID]
+	if !ok {
+		return 0, false
+	}
+
+	local := at.In(p.location)
+	slot := profile.Slots[local.Weekday()][local.Hour()]
+	if slot.Count < profileMinSamples {
+		return 0, false
+	}
+	return slot.Mean, true
+}
+
+// observeHistory adds every served entry in the snapshot not already in
+// seen, keyed by queue and entry
+func (p *ServiceProfiles) observeHistory(response *APIResponse, seen map[string]bool) {
+	for _, queue := range response.Queues {
+		for _, entry := range queue.Entries {
+			if entry.Status != "served" || entry.StartedAt == nil || entry.ServedAt == nil {
+				continue
+			}
+			key := queue.QueueID + "/" + entry.Key()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			p.observe(queue.QueueID, *entry.StartedAt, entry.ServedAt.Sub(*entry.StartedAt))
+		}
+	}
+}
+
+// RebuildServiceProfiles builds profiles from scratch out of every served
+// entry in the snapshots. Entries seen in several snapshots count once.
+func RebuildServiceProfiles(location *time.Location, snapshots []*APIResponse) *ServiceProfiles {
+	profiles := NewServiceProfiles(location)
+	seen := make(map[string]bool)
+
+	for _, snapshot := range snapshots {
+		profiles.observeHistory(snapshot, seen)
+	}
+
+	return profiles
+}
+
+// profileFile is the on-disk layout of ServiceProfiles
+type profileFile struct {
+	Version  int                        `json:"version"`
+	Location string                     `json:"location"`
+	SavedAt  time.Time                  `json:"saved_at"`
+	Queues   map[string]*ServiceProfile `json:"queues"`
+}
+
+// ProfileStore persists service profiles as a JSON file on local disk
+type ProfileStore struct {
+	path string
+}
+
+// NewProfileStore creates a store backed by the file at path
+func NewProfileStore(path string) *ProfileStore {
+	return &ProfileStore{path: path}
+}
+
+// Load reads saved profiles, bucketed in location. It returns nil without
+// error when nothing has been saved yet. Profiles saved in another time zone
+// are rejected, as their slots would be shifted; rebuild them instead.
+func (s *ProfileStore) Load(location *time.Location) (*ServiceProfiles, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile file: %w", err)
+	}
+
+	var file profileFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode profile file: %w", err)
+	}
+	if file.Version != profileFileVersion {
+		return nil, fmt.Errorf("unsupported profile file version %d", file.Version)
+	}
+	if file.Location != location.String() {
+		return nil, fmt.Errorf("profile file is bucketed in %s, not %s", file.Location, location)
+	}
+
+	profiles := NewServiceProfiles(location)
+	for queueID, profile := range file.Queues {
+		if profile != nil {
+			profiles.queues[queueID] = profile
+		}
+	}
+	return profiles, nil
+}
+
+// Save writes the profiles atomically
+func (s *ProfileStore) Save(profiles *ServiceProfiles, savedAt time.Time) error {
+	data, err := json.Marshal(profileFile{
+		Version:  profileFileVersion,
+		Location: profiles.location.String(),
+		SavedAt:  savedAt,
+		Queues:   profiles.queues,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode profiles: %w", err)
+	}
+
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return err
+	}
+	profiles.dirty = false
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sast is a fixed UTC+2 zone, so testNow (Sunday 12:00 UTC) is 14:00 local
+var sast = time.FixedZone("SAST", 2*60*60)
+
+// profileWith returns profiles where queueID's slot at testNow has count
+// services of the given length
+func profileWith(queueID string, count int, service time.Duration) *ServiceProfiles {
+	profiles := NewServiceProfiles(sast)
+	for i := 0; i < count; i++ {
+		profiles.observe(queueID, testNow, service)
+	}
+	return profiles
+}
+
+func TestServiceProfileSlots(t *testing.T) {
+	profiles := profileWith("Q1", profileMinSamples-1, 8*time.Minute)
+	if _, ok := profiles.expected("Q1", testNow); ok {
+		t.Errorf("slot trusted with %d services, want %d", profileMinSamples-1, profileMinSamples)
+	}
+
+	profiles.observe("Q1", testNow.Add(30*time.Minute), 10*time.Minute) // same local hour
+	mean, ok := profiles.expected("Q1", testNow)
+	if !ok || mean != 8*time.Minute+12*time.Second {
+		t.Errorf("expected = %v, %v; want 8m12s", mean, ok)
+	}
+
+	// A forgotten ticket doesn't move a trusted slot
+	profiles.observe("Q1", testNow, 3*time.Hour)
+	if again, _ := profiles.expected("Q1", testNow); again != mean {
+		t.Errorf("outlier moved slot mean from %v to %v", mean, again)
+	}
+
+	// Next hour, next week's Sunday and other queues are separate slots
+	if _, ok := profiles.expected("Q1", testNow.Add(time.Hour)); ok {
+		t.Error("15:00 slot has history, want none")
+	}
+	if _, ok := profiles.expected("Q1", testNow.AddDate(0, 0, 7)); !ok {
+		t.Error("same hour next Sunday has no history, want the shared slot")
+	}
+	if _, ok := profiles.expected("Q2", testNow); ok {
+		t.Error("Q2 has history, want none")
+	}
+
+	var none *ServiceProfiles
+	if _, ok := none.expected("Q1", testNow); ok {
+		t.Error("nil profiles report history")
+	}
+}
+
+func TestProfileBlendsWithSparseLiveWindow(t *testing.T) {
+	calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+	calc.SetProfiles(profileWith("Q1", profileMinSamples, 10*time.Minute))
+
+	live := func(n int) Queue {
+		queue := Queue{QueueID: "Q1"}
+		for i := 0; i < n; i++ {
+			queue.Entries = append(queue.Entries, servedEntry(string(rune('A'+i)), time.Duration(i+1)*time.Minute, 5*time.Minute))
+		}
+		return queue
+	}
+
+	tests := []struct {
+		live int
+		want time.Duration
+	}{
+		{0, 10 * time.Minute},
+		{2, 8 * time.Minute}, // (3×10 + 2×5) / 5
+		{profileBlendSamples, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		stats := calc.CalculateQueueStats(live(tt.live))
+		if stats.AverageProcessTime != tt.want {
+			t.Errorf("%d live services: AverageProcessTime = %v, want %v", tt.live, stats.AverageProcessTime, tt.want)
+		}
+		if stats.ProfileServiceTime != 10*time.Minute {
+			t.Errorf("ProfileServiceTime = %v, want 10m", stats.ProfileServiceTime)
+		}
+	}
+
+	// Outside the profiled hour the configured prior applies again
+	calc.clock.(*VirtualClock).Advance(time.Hour)
+	if got := calc.CalculateQueueStats(live(0)).AverageProcessTime; got != defaultServicePrior.Mean {
+		t.Errorf("unprofiled hour = %v, want default prior", got)
+	}
+}
+
+func TestObserveTransitionsFeedsProfiles(t *testing.T) {
+	calc := NewQueueCalculator(NewVirtualClock(testNow), DefaultConfig())
+	profiles := NewServiceProfiles(sast)
+	calc.SetProfiles(profiles)
+
+	serving := inProgressEntry("E1", 6*time.Minute)
+	served := servedEntry("E1", time.Minute, 5*time.Minute)
+	previous := Queue{QueueID: "Q1", Entries: []QueueEntry{serving}}
+	current := Queue{QueueID: "Q1", Entries: []QueueEntry{served}}
+
+	calc.ObserveTransitions(previous, current)
+	calc.ObserveTransitions(current, current) // already served: not counted again
+
+	slot := profiles.queues["Q1"].Slots[time.Sunday][13]
+	if slot.Count != 1 || slot.Mean != 5*time.Minute {
+		t.Errorf("13:00 slot = %+v, want one 5 minute service", slot)
+	}
+	if !profiles.dirty {
+		t.Error("profiles not marked for saving")
+	}
+}
+
+func TestRebuildAndStoreProfiles(t *testing.T) {
+	snapshot := &APIResponse{Queues: []Queue{{QueueID: "Q1", Entries: []QueueEntry{
+		servedEntry("A", 10*time.Minute, 4*time.Minute),
+		servedEntry("B", 5*time.Minute, 6*time.Minute),
+		waitingEntry("C", time.Minute),
+	}}}}
+
+	// The same entries in two snapshots count once
+	profiles := RebuildServiceProfiles(sast, []*APIResponse{snapshot, snapshot})
+	slot := profiles.queues["Q1"].Slots[time.Sunday][13]
+	if slot.Count != 2 || slot.Mean != 5*time.Minute {
+		t.Fatalf("13:00 slot = %+v, want two services averaging 5m", slot)
+	}
+
+	store := NewProfileStore(filepath.Join(t.TempDir(), "profiles.json"))
+	if loaded, err := store.Load(sast); loaded != nil || err != nil {
+		t.Fatalf("Load before save = %v, %v; want nil, nil", loaded, err)
+	}
+	if err := store.Save(profiles, testNow); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded, err := store.Load(sast)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := loaded.queues["Q1"].Slots[time.Sunday][13]; got != slot {
+		t.Errorf("loaded slot = %+v, want %+v", got, slot)
+	}
+
+	if _, err := store.Load(time.UTC); err == nil {
+		t.Error("Load in another time zone succeeded, want error")
+	}
+}
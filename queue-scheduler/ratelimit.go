@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dailyCapWindow is the window of the per-customer daily message cap
+const dailyCapWindow = 24 * time.Hour
+
+// rateLimitTimeout bounds a shared rate limiter check, after which the alert
+// system falls back to its in-memory limiter
+const rateLimitTimeout = 2 * time.Second
+
+// RateLimit allows at most Max messages under Key in any Window: a sliding
+// window over the times messages were sent
+type RateLimit struct {
+	Key    string
+	Window time.Duration
+	Max    int
+}
+
+// RateLimiter decides whether a message may go out
+type RateLimiter interface {
+	// Allow reports whether a message sent at now stays within every limit
+	// and, if so, counts it against each of them. A denied message counts
+	// against none.
+	Allow(ctx context.Context, now time.Time, limits ...RateLimit) (bool, error)
+
+	Name() string
+}
+
+// dailyCapKey is the rate limit key of a customer's daily message cap
+func dailyCapKey(msisdn string) string {
+	return "daily:" + msisdn
+}
+
+// MemoryRateLimiter keeps the send times of each key in memory, so it only
+// limits the messages of one process
+type MemoryRateLimiter struct {
+	mu      sync.Mutex
+	events  map[string][]time.Time
+	windows map[string]time.Duration
+}
+
+// NewMemoryRateLimiter creates an empty in-memory limiter
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{
+		events:  make(map[string][]time.Time),
+		windows: make(map[string]time.Duration),
+	}
+}
+
+// Name describes the limiter for logs
+func (m *MemoryRateLimiter) Name() string {
+	return "memory"
+}
+
+// Allow checks and counts a message against the limits
+func (m *MemoryRateLimiter) Allow(ctx context.Context, now time.Time, limits ...RateLimit) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, limit := range limits {
+		if countWithin(m.events[limit.Key], now, limit.Window) >= limit.Max {
+			return false, nil
+		}
+	}
+	for _, limit := range limits {
+		m.events[limit.Key] = append(m.events[limit.Key], now)
+		m.windows[limit.Key] = limit.Window
+	}
+
+	// Drop expired send times (prevent memory leak)
+	m.cleanup(now)
+	return true, nil
+}
+
+// Record counts a message against the limits without checking them, to
+// mirror a send another limiter allowed
+func (m *MemoryRateLimiter) Record(now time.Time, limits ...RateLimit) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, limit := range limits {
+		m.events[limit.Key] = append(m.events[limit.Key], now)
+		m.windows[limit.Key] = limit.Window
+	}
+	m.cleanup(now)
+}
+
+// countWithin counts the times less than window before now
+func countWithin(times []time.Time, now time.Time, window time.Duration) int {
+	count := 0
+	for _, t := range times {
+		if now.Sub(t) < window {
+			count++
+		}
+	}
+	return count
+}
+
+// Restore adds persisted send times under key, counted for window
+func (m *MemoryRateLimiter) Restore(key string, times []time.Time, window time.Duration, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.events[key] = append(m.events[key], times...)
+	m.windows[key] = max(m.windows[key], window)
+	m.cleanup(now)
+}
+
+// Records returns a copy of the send times still within their windows, by
+// key
+func (m *MemoryRateLimiter) Records() map[string][]time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records := make(map[string][]time.Time, len(m.events))
+	for key, times := range m.events {
+		records[key] = append([]time.Time(nil), times...)
+	}
+	return records
+}
+
+// cleanup drops send times that have left their key's window
+func (m *MemoryRateLimiter) cleanup(now time.Time) {
+	for key, times := range m.events {
+		kept := times[:0]
+		for _, t := range times {
+			if now.Sub(t) < m.windows[key] {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == 0 {
+			delete(m.events, key)
+			delete(m.windows, key)
+		} else {
+			m.events[key] = kept
+		}
+	}
+}
+
+// redisKeyPrefix namespaces the rate limit keys in a shared Redis
+const redisKeyPrefix = "queue-scheduler:ratelimit:"
+
+// RedisRateLimiter keeps send times in a Redis-compatible store shared by
+// every scheduler process, one sorted set per key scored by send time in
+// milliseconds. It speaks RESP over a single connection, reconnecting after
+// errors.
+type RedisRateLimiter struct {
+	addr     string
+	password string
+	db       int
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisRateLimiter creates a limiter for a redis://[:password@]host:port[/db]
+// URL. It connects on first use.
+func NewRedisRateLimiter(redisURL string) (*RedisRateLimiter, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil || u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("invalid Redis URL %q, want redis://[:password@]host:port[/db]", redisURL)
+	}
+
+	limiter := &RedisRateLimiter{addr: u.Host}
+	if u.Port() == "" {
+		limiter.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if password, ok := u.User.Password(); ok {
+		limiter.password = password
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if limiter.db, err = strconv.Atoi(db); err != nil || limiter.db < 0 {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+	return limiter, nil
+}
+
+// Name describes the limiter for logs, without the password
+func (r *RedisRateLimiter) Name() string {
+	return fmt.Sprintf("redis %s/%d", r.addr, r.db)
+}
+
+// Allow adds the message to every key's sorted set and counts what is left
+// in the window, all in one transaction, then takes it out again if any
+// limit is exceeded. Two processes racing for the last slot may both be
+// denied, but never both allowed.
+func (r *RedisRateLimiter) Allow(ctx context.Context, now time.Time, limits ...RateLimit) (bool, error) {
+	if len(limits) == 0 {
+		return true, nil
+	}
+
+	member, err := redisMember(now)
+	if err != nil {
+		return false, err
+	}
+	score := strconv.FormatInt(now.UnixMilli(), 10)
+
+	commands := [][]string{{"MULTI"}}
+	for _, limit := range limits {
+		key := redisKeyPrefix + limit.Key
+		cutoff := strconv.FormatInt(now.Add(-limit.Window).UnixMilli(), 10)
+		commands = append(commands,
+			[]string{"ZREMRANGEBYSCORE", key, "-inf", cutoff},
+			[]string{"ZADD", key, score, member},
+			[]string{"ZCARD", key},
+			[]string{"PEXPIRE", key, strconv.FormatInt(limit.Window.Milliseconds(), 10)},
+		)
+	}
+	commands = append(commands, []string{"EXEC"})
+
+	replies, err := r.do(ctx, commands...)
+	if err != nil {
+		return false, err
+	}
+	results, ok := replies[len(replies)-1].([]any)
+	if !ok || len(results) != 4*len(limits) {
+		return false, fmt.Errorf("failed to check rate limits: unexpected EXEC reply %v", replies[len(replies)-1])
+	}
+
+	allowed := true
+	for i, limit := range limits {
+		count, ok := results[4*i+2].(int64)
+		if !ok {
+			return false, fmt.Errorf("failed to check rate limits: unexpected ZCARD reply %v", results[4*i+2])
+		}
+		if count > int64(limit.Max) {
+			allowed = false
+		}
+	}
+	if allowed {
+		return true, nil
+	}
+
+	var undo [][]string
+	for _, limit := range limits {
+		undo = append(undo, []string{"ZREM", redisKeyPrefix + limit.Key, member})
+	}
+	if _, err := r.do(ctx, undo...); err != nil {
+		// Still denied: the leftover members only make the limit stricter
+		// until they leave the window
+		log.Printf("Error undoing denied rate limit check: %v", err)
+	}
+	return false, nil
+}
+
+// Close closes the connection, if open
+func (r *RedisRateLimiter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
+
+// redisMember makes a unique sorted set member for a message sent at now
+func redisMember(now time.Time) (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate rate limit member: %w", err)
+	}
+	return strconv.FormatInt(now.UnixMilli(), 10) + "-" + hex.EncodeToString(random), nil
+}
+
+// do sends commands in one pipeline and returns their replies. Redis error
+// replies are returned as errors. Any failure drops the connection.
+func (r *RedisRateLimiter) do(ctx context.Context, commands ...[]string) ([]any, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	replies, err := r.roundTrip(ctx, commands)
+	if err != nil && r.conn != nil {
+		r.conn.Close()
+		r.conn = nil
+	}
+	return replies, err
+}
+
+// roundTrip writes commands and reads their replies, connecting first if
+// needed. Only do may call it.
+func (r *RedisRateLimiter) roundTrip(ctx context.Context, commands [][]string) ([]any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(rateLimitTimeout)
+	}
+	if r.conn == nil {
+		if err := r.connect(ctx, deadline); err != nil {
+			return nil, err
+		}
+	}
+	r.conn.SetDeadline(deadline)
+
+	var request []byte
+	for _, command := range commands {
+		request = appendRESPCommand(request, command)
+	}
+	if _, err := r.conn.Write(request); err != nil {
+		return nil, fmt.Errorf("failed to write to Redis: %w", err)
+	}
+
+	replies := make([]any, len(commands))
+	for i := range commands {
+		reply, err := readRESP(r.reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from Redis: %w", err)
+		}
+		if replyErr, ok := reply.(redisError); ok {
+			return nil, fmt.Errorf("redis %s: %w", commands[i][0], replyErr)
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// connect dials Redis, authenticating and selecting the database if
+// configured
+func (r *RedisRateLimiter) connect(ctx context.Context, deadline time.Time) error {
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", r.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	r.conn = conn
+	r.reader = bufio.NewReader(conn)
+
+	var setup [][]string
+	if r.password != "" {
+		setup = append(setup, []string{"AUTH", r.password})
+	}
+	if r.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(r.db)})
+	}
+	if len(setup) == 0 {
+		return nil
+	}
+	_, err = r.roundTrip(ctx, setup)
+	return err
+}
+
+// redisError is an error reply from Redis
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// appendRESPCommand encodes a command as a RESP array of bulk strings
+func appendRESPCommand(buf []byte, args []string) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+// readRESP reads one RESP reply: a string for simple and bulk strings, an
+// int64, a redisError, a []any for arrays, or nil for null replies
+func readRESP(reader *bufio.Reader) (any, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty RESP line")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		items := make([]any, count)
+		for i := range items {
+			if items[i], err = readRESP(reader); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unexpected RESP reply %q", line)
+	}
+}
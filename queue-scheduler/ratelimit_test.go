@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a Redis stand-in speaking RESP with just the commands
+// RedisRateLimiter uses: sorted sets, transactions, AUTH and SELECT
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mu    sync.Mutex
+	dbs   map[int]map[string]map[string]float64 // db, key, member -> score
+	conns []net.Conn
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	fake := &fakeRedis{listener: listener, password: password, dbs: make(map[int]map[string]map[string]float64)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			fake.mu.Lock()
+			fake.conns = append(fake.conns, conn)
+			fake.mu.Unlock()
+			go fake.serve(conn)
+		}
+	}()
+	t.Cleanup(fake.stop)
+
+	return fake
+}
+
+// url is the address to connect to, with the password and database
+func (f *fakeRedis) url(db int) string {
+	if f.password == "" {
+		return fmt.Sprintf("redis://%s/%d", f.listener.Addr(), db)
+	}
+	return fmt.Sprintf("redis://:%s@%s/%d", f.password, f.listener.Addr(), db)
+}
+
+// stop takes the server down, dropping every connection
+func (f *fakeRedis) stop() {
+	f.listener.Close()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+}
+
+// keys lists the keys holding members in a database
+func (f *fakeRedis) keys(db int) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var keys []string
+	for key, set := range f.dbs[db] {
+		if len(set) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// fakeRedisSession is the state of one client connection
+type fakeRedisSession struct {
+	authed bool
+	db     int
+	queued [][]string
+	multi  bool
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	session := &fakeRedisSession{authed: f.password == ""}
+	for {
+		request, err := readRESP(reader)
+		if err != nil {
+			return
+		}
+		items, _ := request.([]any)
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		if _, err := conn.Write([]byte(f.handle(session, args))); err != nil {
+			return
+		}
+	}
+}
+
+// handle runs a command and returns its encoded reply
+func (f *fakeRedis) handle(session *fakeRedisSession, args []string) string {
+	if len(args) == 0 {
+		return "-ERR empty command\r\n"
+	}
+	command := strings.ToUpper(args[0])
+
+	switch {
+	case command == "AUTH":
+		if len(args) != 2 || args[1] != f.password {
+			return "-WRONGPASS invalid username-password pair\r\n"
+		}
+		session.authed = true
+		return "+OK\r\n"
+	case !session.authed:
+		return "-NOAUTH Authentication required.\r\n"
+	case command == "SELECT":
+		session.db, _ = strconv.Atoi(args[1])
+		return "+OK\r\n"
+	case command == "MULTI":
+		session.multi = true
+		return "+OK\r\n"
+	case command == "EXEC":
+		replies := fmt.Sprintf("*%d\r\n", len(session.queued))
+		for _, queued := range session.queued {
+			replies += f.run(session.db, queued)
+		}
+		session.queued, session.multi = nil, false
+		return replies
+	case session.multi:
+		session.queued = append(session.queued, args)
+		return "+QUEUED\r\n"
+	default:
+		return f.run(session.db, args)
+	}
+}
+
+// run executes a data command
+func (f *fakeRedis) run(db int, args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.dbs[db] == nil {
+		f.dbs[db] = make(map[string]map[string]float64)
+	}
+	set := f.dbs[db][args[1]]
+	if set == nil {
+		set = make(map[string]float64)
+		f.dbs[db][args[1]] = set
+	}
+	score := func(value string) float64 {
+		if value == "-inf" {
+			return math.Inf(-1)
+		}
+		parsed, _ := strconv.ParseFloat(value, 64)
+		return parsed
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "ZADD":
+		_, exists := set[args[3]]
+		set[args[3]] = score(args[2])
+		if exists {
+			return ":0\r\n"
+		}
+		return ":1\r\n"
+	case "ZREMRANGEBYSCORE":
+		removed := 0
+		for member, s := range set {
+			if s >= score(args[2]) && s <= score(args[3]) {
+				delete(set, member)
+				removed++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", removed)
+	case "ZREM":
+		if _, ok := set[args[2]]; !ok {
+			return ":0\r\n"
+		}
+		delete(set, args[2])
+		return ":1\r\n"
+	case "ZCARD":
+		return fmt.Sprintf(":%d\r\n", len(set))
+	case "PEXPIRE":
+		return ":1\r\n" // keys outlive their expiry here; the windows still apply
+	default:
+		return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+// testSlidingWindow checks a limiter's sliding windows and that a denied
+// message counts against none of its limits
+func testSlidingWindow(t *testing.T, limiter RateLimiter) {
+	t.Helper()
+	ctx := context.Background()
+
+	steps := []struct {
+		at   time.Duration
+		want bool
+	}{
+		{0, true},
+		{3 * time.Minute, true},
+		{6 * time.Minute, false}, // two in the last 10 minutes
+		{10 * time.Minute, true}, // the first has left the window
+		{12 * time.Minute, false},
+		{13 * time.Minute, true},
+	}
+	for _, step := range steps {
+		got, err := limiter.Allow(ctx, testNow.Add(step.at), RateLimit{Key: "window", Window: 10 * time.Minute, Max: 2})
+		if err != nil {
+			t.Fatalf("Allow: %v", err)
+		}
+		if got != step.want {
+			t.Errorf("%s: Allow at +%v = %v, want %v", limiter.Name(), step.at, got, step.want)
+		}
+	}
+
+	strict := RateLimit{Key: "strict", Window: time.Hour, Max: 1}
+	loose := RateLimit{Key: "loose", Window: time.Hour, Max: 2}
+	for i, want := range []bool{true, false} {
+		got, err := limiter.Allow(ctx, testNow, strict, loose)
+		if err != nil {
+			t.Fatalf("Allow: %v", err)
+		}
+		if got != want {
+			t.Errorf("%s: Allow under both limits #%d = %v, want %v", limiter.Name(), i+1, got, want)
+		}
+	}
+	if got, err := limiter.Allow(ctx, testNow, loose); !got || err != nil {
+		t.Errorf("%s: Allow under the loose limit = %v, %v, want the denied message not counted", limiter.Name(), got, err)
+	}
+}
+
+func TestMemoryRateLimiter(t *testing.T) {
+	testSlidingWindow(t, NewMemoryRateLimiter())
+}
+
+func TestRedisRateLimiter(t *testing.T) {
+	fake := newFakeRedis(t, "s3cret")
+	limiter, err := NewRedisRateLimiter(fake.url(2))
+	if err != nil {
+		t.Fatalf("NewRedisRateLimiter: %v", err)
+	}
+	defer limiter.Close()
+
+	testSlidingWindow(t, limiter)
+	if keys := fake.keys(2); len(keys) == 0 || !strings.HasPrefix(keys[0], redisKeyPrefix) {
+		t.Errorf("keys in database 2 = %v, want prefixed rate limit keys", keys)
+	}
+	if strings.Contains(limiter.Name(), "s3cret") {
+		t.Errorf("Name %q shows the password", limiter.Name())
+	}
+
+	// Another process sharing the store sees the same limits
+	other, err := NewRedisRateLimiter(fake.url(2))
+	if err != nil {
+		t.Fatalf("NewRedisRateLimiter: %v", err)
+	}
+	defer other.Close()
+	if got, err := other.Allow(context.Background(), testNow, RateLimit{Key: "strict", Window: time.Hour, Max: 1}); got || err != nil {
+		t.Errorf("other process Allow = %v, %v, want denied", got, err)
+	}
+
+	wrong, _ := NewRedisRateLimiter(strings.Replace(fake.url(2), "s3cret", "guess", 1))
+	defer wrong.Close()
+	if _, err := wrong.Allow(context.Background(), testNow, RateLimit{Key: "k", Window: time.Hour, Max: 1}); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("Allow with the wrong password = %v, want WRONGPASS", err)
+	}
+
+	for _, bad := range []string{"http://localhost:6379", "redis://", "redis://localhost/x"} {
+		if _, err := NewRedisRateLimiter(bad); err == nil {
+			t.Errorf("NewRedisRateLimiter(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestSharedRateLimitsAcrossProcesses(t *testing.T) {
+	fake := newFakeRedis(t, "")
+	graph := newFakeGraphAPI(t)
+	clock := NewVirtualClock(testNow)
+
+	newAlertSystem := func() *AlertSystem {
+		limiter, err := NewRedisRateLimiter(fake.url(0))
+		if err != nil {
+			t.Fatalf("NewRedisRateLimiter: %v", err)
+		}
+		t.Cleanup(func() { limiter.Close() })
+		alerts := NewAlertSystem(clock, graph.config(), DefaultConfig())
+		alerts.limiter = limiter
+		return alerts
+	}
+	a, b := newAlertSystem(), newAlertSystem()
+
+	alert := AlertRequest{EntryID: "E1", MSISDN: "27601234567", Channel: "whatsapp", QueueID: "Q1", Message: "Position #2", Timestamp: testNow}
+	if outcome := a.SendAlert(alert); outcome != alertOutcomeSent {
+		t.Errorf("first process: %s, want sent", outcome)
+	}
+	if outcome := b.SendAlert(alert); outcome != alertOutcomeRateLimited {
+		t.Errorf("second process: %s, want rate limited", outcome)
+	}
+
+	// With the store down each process limits on its own
+	fake.stop()
+	alert.EntryID = "E2"
+	if outcome := a.SendAlert(alert); outcome != alertOutcomeSent {
+		t.Errorf("during the outage: %s, want sent", outcome)
+	}
+	if outcome := a.SendAlert(alert); outcome != alertOutcomeRateLimited {
+		t.Errorf("repeat during the outage: %s, want rate limited in memory", outcome)
+	}
+	if got := len(graph.sent()); got != 2 {
+		t.Errorf("sent %d messages, want 2", got)
+	}
+}
+
+func TestSharedLimiterOutageKeepsLimits(t *testing.T) {
+	fake := newFakeRedis(t, "")
+	graph := newFakeGraphAPI(t)
+	clock := NewVirtualClock(testNow)
+	config := DefaultConfig()
+	config.DailyMessageCap = 2
+
+	limiter, err := NewRedisRateLimiter(fake.url(0))
+	if err != nil {
+		t.Fatalf("NewRedisRateLimiter: %v", err)
+	}
+	t.Cleanup(func() { limiter.Close() })
+	alerts := NewAlertSystem(clock, graph.config(), config)
+	alerts.limiter = limiter
+
+	alert := AlertRequest{EntryID: "E1", MSISDN: "27601234567", Channel: "whatsapp", QueueID: "Q1", Message: "Position #2", Timestamp: testNow}
+	if outcome := alerts.SendAlert(alert); outcome != alertOutcomeSent {
+		t.Errorf("before the outage: %s, want sent", outcome)
+	}
+	if _, ok := alerts.SentAlerts()[rateLimitKey(alert)]; !ok {
+		t.Errorf("SentAlerts = %v, want the shared send mirrored", alerts.SentAlerts())
+	}
+
+	// The store dies mid-window: what it allowed still counts in memory
+	fake.stop()
+	clock.Advance(time.Minute)
+	if outcome := alerts.SendAlert(alert); outcome != alertOutcomeRateLimited {
+		t.Errorf("same entry during the outage: %s, want rate limited", outcome)
+	}
+	alert.EntryID = "E2"
+	if outcome := alerts.SendAlert(alert); outcome != alertOutcomeSent {
+		t.Errorf("second message of the day: %s, want sent", outcome)
+	}
+	alert.EntryID = "E3"
+	if outcome := alerts.SendAlert(alert); outcome != alertOutcomeRateLimited {
+		t.Errorf("third message of the day: %s, want rate limited by the cap", outcome)
+	}
+	if got := len(graph.sent()); got != 2 {
+		t.Errorf("sent %d messages, want 2", got)
+	}
+}
+
+func TestDailyMessageCap(t *testing.T) {
+	config := DefaultConfig()
+	config.DailyMessageCap = 2
+	graph := newFakeGraphAPI(t)
+	clock := NewVirtualClock(testNow)
+	alerts := NewAlertSystem(clock, graph.config(), config)
+
+	send := func(entryID string) string {
+		return alerts.SendAlert(AlertRequest{EntryID: entryID, MSISDN: "27601234567", Channel: "whatsapp", QueueID: "Q1", Timestamp: clock.Now()})
+	}
+
+	// An alert that can't be routed doesn't use up the cap
+	if outcome := alerts.SendAlert(AlertRequest{EntryID: "E0", MSISDN: "27601234567", Channel: "pigeon", QueueID: "Q1", Timestamp: clock.Now()}); outcome != alertOutcomeUnknownChannel {
+		t.Errorf("unknown channel: %s, want %s", outcome, alertOutcomeUnknownChannel)
+	}
+
+	// Separate entries aren't limited by the window, only by the cap
+	send("E1")
+	clock.Advance(time.Hour)
+	send("E2")
+	clock.Advance(time.Hour)
+	if outcome := send("E3"); outcome != alertOutcomeRateLimited {
+		t.Errorf("third message of the day: %s, want rate limited", outcome)
+	}
+
+	// Urgent messages still go out over the cap
+	for _, kind := range []string{messageAlmost, messageNext, messageServing} {
+		alert := AlertRequest{EntryID: "E3", MSISDN: "27601234567", Channel: "whatsapp", QueueID: "Q1", Kind: kind, Timestamp: clock.Now()}
+		if outcome := alerts.SendAlert(alert); outcome != alertOutcomeSent {
+			t.Errorf("%s message over the cap: %s, want sent", kind, outcome)
+		}
+		clock.Advance(10 * time.Minute)
+	}
+
+	// The cap survives a restart
+	restarted := NewAlertSystem(clock, graph.config(), config)
+	restarted.RestoreSentAlerts(alerts.SentAlerts())
+	restarted.RestoreDailyMessages(alerts.DailyMessages())
+	alerts = restarted
+	if outcome := send("E4"); outcome != alertOutcomeRateLimited {
+		t.Errorf("after a restart: %s, want rate limited", outcome)
+	}
+
+	// A day after the first message there's room for one more
+	clock.Set(testNow.Add(24 * time.Hour))
+	if outcome := send("E5"); outcome != alertOutcomeSent {
+		t.Errorf("a day later: %s, want sent", outcome)
+	}
+	if got := len(graph.sent()); got != 6 {
+		t.Errorf("sent %d messages, want 6", got)
+	}
+
+	t.Setenv("DAILY_MESSAGE_CAP", "-1")
+	if _, err := LoadConfig(nil); err == nil || !strings.Contains(err.Error(), "daily_message_cap -1 is negative") {
+		t.Errorf("LoadConfig error = %v, want the negative cap reported", err)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record kinds written by the Recorder
+const (
+	recordKindSnapshot = "snapshot"
+	recordKindAlert    = "alert"
+)
+
+// Alert outcomes captured alongside recorded alerts
+const (
+	alertOutcomeSent           = "sent"
+	alertOutcomeFailed         = "failed"
+	alertOutcomeRateLimited    = "rate_limited"
+	alertOutcomeDryRun         = "dry_run"
+	alertOutcomeUnknownChannel = "unknown_channel"
+)
+
+// recordEntry is one line of a recording. Replays only use snapshot lines;
+// alert lines are there for diffing what was sent against a replay.
+type recordEntry struct {
+	Kind      string        `json:"kind,omitempty"`
+	Timestamp time.Time     `json:"timestamp"`
+	Response  *APIResponse  `json:"response,omitempty"`
+	Alert     *AlertRequest `json:"alert,omitempty"`
+	Outcome   string        `json:"outcome,omitempty"`
+}
+
+// Recorder writes snapshots and alerts to gzip-compressed JSONL files,
+// starting a new file when the current one gets too big or too old
+type Recorder struct {
+	mu    sync.Mutex
+	clock Clock
+
+	basePath string
+	maxBytes int64
+	maxAge   time.Duration
+
+	file    *os.File
+	gz      *gzip.Writer
+	written int64
+	opened  time.Time
+}
+
+// NewRecorder creates a recorder writing files named
+// <basePath>-<UTC timestamp>.jsonl.gz, timestamped and aged by clock. A zero
+// maxBytes or maxAge disables that rotation trigger.
+func NewRecorder(clock Clock, basePath string, maxBytes int64, maxAge time.Duration) (*Recorder, error) {
+	basePath = strings.TrimSuffix(strings.TrimSuffix(basePath, ".gz"), ".jsonl")
+
+	if dir := filepath.Dir(basePath); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create recording directory: %w", err)
+		}
+	}
+
+	return &Recorder{
+		clock:    clock,
+		basePath: basePath,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+	}, nil
+}
+
+// RecordSnapshot records a queue snapshot fetched at timestamp
+func (r *Recorder) RecordSnapshot(timestamp time.Time, response *APIResponse) {
+	r.write(recordEntry{
+		Kind:      recordKindSnapshot,
+		Timestamp: timestamp,
+		Response:  response,
+	})
+}
+
+// RecordAlert records an alert and what happened to it
+func (r *Recorder) RecordAlert(alert AlertRequest, outcome string) {
+	r.write(recordEntry{
+		Kind:      recordKindAlert,
+		Timestamp: alert.Timestamp,
+		Alert:     &alert,
+		Outcome:   outcome,
+	})
+}
+
+// Close flushes and closes the current file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closeFile()
+}
+
+// write appends one record, rotating first if needed. Recording is best
+// effort: failures are logged and never stop the scheduler.
+func (r *Recorder) write(entry recordEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Error encoding recording entry: %v", err)
+		return
+	}
+	data = append(data, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.needsRotation() {
+		if err := r.rotate(); err != nil {
+			log.Printf("Error rotating recording file: %v", err)
+			return
+		}
+	}
+
+	if _, err := r.gz.Write(data); err != nil {
+		log.Printf("Error writing recording: %v", err)
+		return
+	}
+	// Flush per record so a crash loses at most the line being written
+	if err := r.gz.Flush(); err != nil {
+		log.Printf("Error flushing recording: %v", err)
+		return
+	}
+	r.written += int64(len(data))
+}
+
+// needsRotation reports whether a new file should be started
+func (r *Recorder) needsRotation() bool {
+	if r.file == nil {
+		return true
+	}
+	if r.maxBytes > 0 && r.written >= r.maxBytes {
+		return true
+	}
+	return r.maxAge > 0 && r.clock.Now().Sub(r.opened) >= r.maxAge
+}
+
+// rotate closes the current file and opens a fresh one
+func (r *Recorder) rotate() error {
+	if err := r.closeFile(); err != nil {
+		log.Printf("Error closing recording file: %v", err)
+	}
+
+	now := r.clock.Now().UTC()
+	name := fmt.Sprintf("%s-%s", r.basePath, now.Format("20060102T150405.000Z"))
+	path := name + ".jsonl.gz"
+
+	// Files opened within the same millisecond, e.g. on a virtual clock, are
+	// numbered rather than overwritten
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	for i := 1; errors.Is(err, os.ErrExist) && i < 100; i++ {
+		path = fmt.Sprintf("%s.%d.jsonl.gz", name, i)
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create recording file: %w", err)
+	}
+
+	r.file = file
+	r.gz = gzip.NewWriter(file)
+	r.written = 0
+	r.opened = now
+
+	log.Printf("📼 Recording to %s", path)
+	return nil
+}
+
+// closeFile finishes the gzip stream and closes the current file, if any
+func (r *Recorder) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+
+	gzErr := r.gz.Close()
+	fileErr := r.file.Close()
+	r.file, r.gz = nil, nil
+
+	if gzErr != nil {
+		return gzErr
+	}
+	return fileErr
+}
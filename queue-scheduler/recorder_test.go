@@ -0,0 +1,147 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// recordingFiles returns the files a recorder wrote under dir, by name
+func recordingFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "rec-*.jsonl.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestRecorderWritesSnapshotsAndAlerts(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewVirtualClock(testNow)
+	recorder, err := NewRecorder(clock, filepath.Join(dir, "rec.jsonl.gz"), 0, 0)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+
+	recorder.RecordSnapshot(clock.Now(), &APIResponse{Queues: []Queue{sampleQueue()}})
+	recorder.RecordAlert(AlertRequest{EntryID: "WAIT1", MSISDN: "2760WAIT1", Kind: messagePosition, Timestamp: clock.Now()}, alertOutcomeSent)
+	clock.Advance(time.Minute)
+	recorder.RecordSnapshot(clock.Now(), &APIResponse{Queues: []Queue{sampleQueue()}})
+	recorder.RecordAlert(AlertRequest{EntryID: "WAIT1", MSISDN: "2760WAIT1", Kind: messagePosition, Timestamp: clock.Now()}, alertOutcomeRateLimited)
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// The ".jsonl.gz" given is not doubled, and the file is named after the
+	// clock rather than the wall clock
+	files := recordingFiles(t, dir)
+	if len(files) != 1 || filepath.Base(files[0]) != "rec-20250817T120000.000Z.jsonl.gz" {
+		t.Fatalf("recording files = %v, want one named after the virtual clock", files)
+	}
+
+	// A closed file is a complete gzip stream
+	file, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	if _, err := io.ReadAll(gz); err != nil {
+		t.Errorf("reading the closed recording: %v", err)
+	}
+
+	records, err := readRecordFile(files[0])
+	if err != nil {
+		t.Fatalf("readRecordFile: %v", err)
+	}
+	var outcomes []string
+	for _, record := range records {
+		if record.Kind == recordKindAlert {
+			outcomes = append(outcomes, record.Outcome)
+		}
+	}
+	if len(records) != 4 || len(outcomes) != 2 || outcomes[0] != alertOutcomeSent || outcomes[1] != alertOutcomeRateLimited {
+		t.Errorf("read %d records with alert outcomes %v, want 4 with sent, rate_limited", len(records), outcomes)
+	}
+
+	replay, err := LoadReplaySource(NewVirtualClock(time.Time{}), files...)
+	if err != nil {
+		t.Fatalf("LoadReplaySource: %v", err)
+	}
+	if replay.Len() != 2 {
+		t.Errorf("replay has %d snapshots, want 2", replay.Len())
+	}
+}
+
+func TestRecorderRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewVirtualClock(testNow)
+	recorder, err := NewRecorder(clock, filepath.Join(dir, "rec"), 1, 0)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+
+	// Every record fills a file, all within the same virtual millisecond
+	for i := 0; i < 3; i++ {
+		recorder.RecordSnapshot(clock.Now(), &APIResponse{Queues: []Queue{sampleQueue()}})
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	files := recordingFiles(t, dir)
+	if len(files) != 3 {
+		t.Fatalf("recording files = %v, want 3", files)
+	}
+	replay, err := LoadReplaySource(NewVirtualClock(time.Time{}), files...)
+	if err != nil {
+		t.Fatalf("LoadReplaySource: %v", err)
+	}
+	if replay.Len() != 3 {
+		t.Errorf("replay has %d snapshots, want one per file", replay.Len())
+	}
+}
+
+func TestRecorderRotatesByAge(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewVirtualClock(testNow)
+	recorder, err := NewRecorder(clock, filepath.Join(dir, "rec"), 0, time.Hour)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+
+	for _, after := range []time.Duration{0, 30 * time.Minute, 31 * time.Minute} {
+		clock.Advance(after)
+		recorder.RecordSnapshot(clock.Now(), &APIResponse{})
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	files := recordingFiles(t, dir)
+	want := []string{"rec-20250817T120000.000Z.jsonl.gz", "rec-20250817T130100.000Z.jsonl.gz"}
+	if len(files) != len(want) {
+		t.Fatalf("recording files = %v, want %v", files, want)
+	}
+	for i, file := range files {
+		if filepath.Base(file) != want[i] {
+			t.Errorf("file %d = %s, want %s", i, filepath.Base(file), want[i])
+		}
+	}
+	records, err := readRecordFile(files[0])
+	if err != nil {
+		t.Fatalf("readRecordFile: %v", err)
+	}
+	if len(records) != 2 {
+		t.Errorf("first file has %d records, want the 2 within the hour", len(records))
+	}
+}
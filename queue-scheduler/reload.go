@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// defaultConfigWatchInterval is how often the config file is checked for
+// changes
+const defaultConfigWatchInterval = 5 * time.Second
+
+// reloadRequest asks the polling loop to switch to a new configuration and
+// reports back whether it did
+type reloadRequest struct {
+	config Config
+	done   chan error
+}
+
+// Reload hands a new configuration to the running polling loop. The loop
+// applies it between polls, so no delivery is cut short and previous
+// queues, sent alerts and learned curves are all kept. It returns once the
+// configuration is in use, or why it was rejected.
+func (s *Scheduler) Reload(ctx context.Context, config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	request := reloadRequest{config: config, done: make(chan error, 1)}
+	select {
+	case s.reloads <- request:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-request.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// applyConfig switches the scheduler, calculator and alert system over to a
+// new configuration all at once, logging what changed. Only the polling
+// loop may call it.
+func (s *Scheduler) applyConfig(config Config) error {
+	templates, err := NewMessageTemplates(config.Templates)
+	if err != nil {
+		return err
+	}
+
+	changes := config.Changes(s.config)
+
+	s.config = config
+	s.templates = templates
+	s.appointmentReminder = config.AppointmentReminder
+	s.switchMinSaving = config.SwitchMinSaving
+	s.calculator.config = config
+	s.calculator.appointmentGrace = config.AppointmentGrace
+	s.alerter.config = config
+
+	if len(changes) == 0 {
+		log.Println("🔄 Configuration reloaded, nothing changed")
+		return nil
+	}
+	log.Printf("🔄 Configuration reloaded, %d changes:", len(changes))
+	for _, change := range changes {
+		log.Printf("   %s", change)
+	}
+	return nil
+}
+
+// WatchConfigFile calls onChange whenever the file's content changes,
+// checking every interval until ctx is done. Checks while the file can't be
+// read, e.g. while an editor replaces it, are skipped.
+func WatchConfigFile(ctx context.Context, path string, interval time.Duration, onChange func()) {
+	last, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("⚠️  Warning: Could not read config file %s: %v", path, err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			content, err := os.ReadFile(path)
+			if err != nil || bytes.Equal(content, last) {
+				continue
+			}
+			last = content
+			log.Printf("Config file %s changed", path)
+			onChange()
+		}
+	}
+}
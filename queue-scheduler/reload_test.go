@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigChanges(t *testing.T) {
+	old := DefaultConfig()
+	old.Queues = map[string]QueueConfig{"ABC123": {PollInterval: 30 * time.Second, LookbackWindow: time.Hour}}
+
+	config := DefaultConfig()
+	config.RateLimitWindow = 10 * time.Minute
+	config.Queues = map[string]QueueConfig{"ABC123": {PollInterval: 15 * time.Second}}
+	config.Templates = map[string]string{messageNext: "Next up: {{.QueueName}}"}
+
+	want := []string{
+		"queues.ABC123.lookback_window: 1h0m0s -> (unset)",
+		"queues.ABC123.poll_interval: 30s -> 15s",
+		"rate_limit_window: 5m0s -> 10m0s",
+		`templates.next: (unset) -> "Next up: {{.QueueName}}"`,
+	}
+	if got := config.Changes(old); !reflect.DeepEqual(got, want) {
+		t.Errorf("Changes = %q, want %q", got, want)
+	}
+	if got := config.Changes(config); len(got) != 0 {
+		t.Errorf("Changes against itself = %q, want none", got)
+	}
+}
+
+func TestReloadKeepsState(t *testing.T) {
+	queue := sampleQueue()
+	django := newFakeDjango(t, queue)
+	graph := newFakeGraphAPI(t)
+	scheduler, clock := newTestScheduler(t, django, graph)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		scheduler.Start(ctx)
+		close(stopped)
+	}()
+
+	invalid := DefaultConfig()
+	invalid.Templates = map[string]string{messagePosition: "{{.Nope"}
+	if err := scheduler.Reload(ctx, invalid); err == nil {
+		t.Error("Reload of a broken template succeeded")
+	}
+
+	config := DefaultConfig()
+	config.RateLimitWindow = 20 * time.Minute
+	config.SwitchMinSaving = 0
+	config.Templates = map[string]string{messagePosition: "#{{.Position}} at {{.QueueName}}, about {{.Wait}}"}
+	if err := scheduler.Reload(ctx, config); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	cancel()
+	<-stopped
+
+	if scheduler.calculator.config.RateLimitWindow != 20*time.Minute || scheduler.alerter.config.RateLimitWindow != 20*time.Minute {
+		t.Error("rate limit window not reloaded everywhere")
+	}
+	if scheduler.switchMinSaving != 0 {
+		t.Errorf("switchMinSaving = %v, want 0", scheduler.switchMinSaving)
+	}
+
+	// Ten minutes on, past the old five minute window but not the new one,
+	// nobody is messaged again: the served customer is remembered and the
+	// sent alerts kept
+	sent := len(graph.sent())
+	clock.Advance(10 * time.Minute)
+	scheduler.processQueues()
+	if got := graph.sent()[sent:]; len(got) != 0 {
+		t.Errorf("sent %v after the reload, want nothing", got)
+	}
+
+	// A new customer gets the new template
+	queue.Entries = append(queue.Entries, waitingEntry("WAIT4", 0))
+	django.setQueues(queue)
+	clock.Advance(time.Minute)
+	scheduler.processQueues()
+	got := messagesTo(graph)["2760WAIT4"]
+	if len(got) != 1 || !strings.HasPrefix(got[0], "#4 at Test Queue, about ") {
+		t.Errorf("messages to WAIT4 = %q, want the reloaded template", got)
+	}
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	path := writeConfigFile(t, "poll_interval: 30s\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 10)
+	go WatchConfigFile(ctx, path, 10*time.Millisecond, func() { changed <- struct{}{} })
+
+	// Rewriting the same content is not a change
+	time.Sleep(50 * time.Millisecond)
+	if err := os.WriteFile(path, []byte("poll_interval: 30s\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-changed:
+		t.Fatal("unchanged content reported as a change")
+	default:
+	}
+
+	if err := os.WriteFile(path, []byte("poll_interval: 15s\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("change not noticed")
+	}
+}
+
+func TestReloadRotatesWhatsAppToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "whatsapp-token")
+	if err := os.WriteFile(tokenFile, []byte("old-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("WHATSAPP_ACCESS_TOKEN", "env-token")
+	t.Setenv("WHATSAPP_ACCESS_TOKEN_FILE", tokenFile)
+	config, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	django := newFakeDjango(t, Queue{QueueID: "Q1", Name: "Clinic", Entries: []QueueEntry{waitingEntry("W1", 0)}})
+	graph := newFakeGraphAPI(t)
+	scheduler, clock := newConfiguredTestScheduler(t, django, graph, config)
+	scheduler.processQueues()
+
+	if err := os.WriteFile(tokenFile, []byte("new-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	changes := rotated.Changes(config)
+	if len(changes) != 1 || !strings.HasPrefix(changes[0], "whatsapp_access_token: (redacted") ||
+		strings.Contains(changes[0], "old-token") || strings.Contains(changes[0], "new-token") {
+		t.Errorf("Changes = %q, want one redacted token change", changes)
+	}
+	if err := scheduler.applyConfig(rotated); err != nil {
+		t.Fatalf("applyConfig: %v", err)
+	}
+
+	clock.Advance(10 * time.Minute)
+	scheduler.processQueues()
+	graph.mu.Lock()
+	auth := append([]string(nil), graph.auth...)
+	graph.mu.Unlock()
+	if want := []string{"Bearer old-token", "Bearer new-token"}; !reflect.DeepEqual(auth, want) {
+		t.Errorf("Authorization headers = %q, want %q", auth, want)
+	}
+
+	// The token itself can't be put in the config file
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "whatsapp_access_token: secret\n"))
+	if _, err := LoadConfig(nil); err == nil {
+		t.Error("LoadConfig accepted a token in the config file")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReplaySource serves recorded snapshots in order, moving a virtual clock to
+// each snapshot's timestamp as it goes
+type ReplaySource struct {
+	records []recordEntry
+	next    int
+	current *APIResponse
+	clock   *VirtualClock
+}
+
+// LoadReplaySource reads JSONL files of timestamped snapshots, plain or
+// gzip-compressed (as written by the Recorder). Lines without a snapshot,
+// such as recorded alerts, are skipped.
+func LoadReplaySource(clock *VirtualClock, paths ...string) (*ReplaySource, error) {
+	var records []recordEntry
+
+	for _, path := range paths {
+		fileRecords, err := readRecordFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range fileRecords {
+			if record.Response != nil {
+				records = append(records, record)
+			}
+		}
+	}
+
+	// Snapshots must be served in time order for the clock to move forward
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Timestamp.Before(records[j].Timestamp)
+	})
+
+	return &ReplaySource{records: records, clock: clock}, nil
+}
+
+// readRecordFile decodes every line of a recording file. A compressed file
+// whose recorder crashed ends without a gzip trailer; its complete lines are
+// kept and a cut-off last line is dropped.
+func readRecordFile(path string) ([]recordEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replay file: %w", err)
+	}
+	defer file.Close()
+
+	reader := &truncatedReader{reader: file}
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if errors.Is(err, io.EOF) {
+			return nil, nil // the recorder crashed before writing anything
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open compressed replay file %s: %w", path, err)
+		}
+		defer gz.Close()
+		reader.reader = gz
+	}
+
+	var records []recordEntry
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	// A line that doesn't decode is only forgiven if it is the last line of
+	// a truncated file
+	var badLine error
+	for line := 1; scanner.Scan(); line++ {
+		if badLine != nil {
+			return nil, badLine
+		}
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record recordEntry
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			badLine = fmt.Errorf("%s line %d: %w", path, line, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read replay file %s: %w", path, err)
+	}
+	if badLine != nil && !reader.truncated {
+		return nil, badLine
+	}
+	if reader.truncated {
+		log.Printf("⚠️  Warning: %s was not closed cleanly, replaying its %d complete lines", path, len(records))
+	}
+
+	return records, nil
+}
+
+// truncatedReader turns a compressed stream that stops short into a clean
+// end of data, remembering that it did
+type truncatedReader struct {
+	reader    io.Reader
+	truncated bool
+}
+
+func (r *truncatedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		r.truncated = true
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Advance moves to the next snapshot and sets the clock to its timestamp.
+// It returns false once every snapshot has been served.
+func (r *ReplaySource) Advance() bool {
+	if r.next >= len(r.records) {
+		return false
+	}
+
+	record := r.records[r.next]
+	r.next++
+	r.current = record.Response
+	r.clock.Set(record.Timestamp)
+	return true
+}
+
+// GetAllQueues returns the snapshot selected by the last Advance
+func (r *ReplaySource) GetAllQueues() (*APIResponse, error) {
+	if r.current == nil {
+		return nil, fmt.Errorf("replay has not started")
+	}
+	return r.current, nil
+}
+
+// Len returns the number of snapshots in the replay
+func (r *ReplaySource) Len() int {
+	return len(r.records)
+}
+
+// RunReplay feeds every snapshot to the scheduler as one poll, as fast as
+// possible. The scheduler and its calculator and alert system should share
+// the replay's virtual clock.
+func RunReplay(scheduler *Scheduler, replay *ReplaySource) {
+	log.Printf("Replaying %d snapshots", replay.Len())
+	for replay.Advance() {
+		log.Printf("⏩ Replay clock: %s", replay.clock.Now().Format(time.RFC3339))
+		scheduler.processQueues()
+	}
+	log.Println("Replay finished")
+}
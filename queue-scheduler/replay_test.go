@@ -0,0 +1,224 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordThenReplay(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewRecorder(NewVirtualClock(testNow), filepath.Join(dir, "rec"), 0, 0)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+
+	// Record out of order to check the replay sorts by timestamp
+	later := &APIResponse{Queues: []Queue{{QueueID: "LATER"}}}
+	earlier := &APIResponse{Queues: []Queue{{QueueID: "EARLIER"}}}
+	recorder.RecordSnapshot(testNow.Add(time.Minute), later)
+	recorder.RecordAlert(AlertRequest{EntryID: "E1", Timestamp: testNow}, alertOutcomeSent)
+	recorder.RecordSnapshot(testNow, earlier)
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "rec-*.jsonl.gz"))
+	clock := NewVirtualClock(time.Time{})
+	replay, err := LoadReplaySource(clock, files...)
+	if err != nil {
+		t.Fatalf("LoadReplaySource: %v", err)
+	}
+
+	if _, err := replay.GetAllQueues(); err == nil {
+		t.Error("GetAllQueues before Advance succeeded, want error")
+	}
+
+	var order []string
+	for replay.Advance() {
+		resp, err := replay.GetAllQueues()
+		if err != nil {
+			t.Fatalf("GetAllQueues: %v", err)
+		}
+		order = append(order, resp.Queues[0].QueueID+"@"+clock.Now().Format("15:04"))
+	}
+
+	if got, want := strings.Join(order, ","), "EARLIER@12:00,LATER@12:01"; got != want {
+		t.Errorf("replay order = %s, want %s", got, want)
+	}
+}
+
+func TestReplaySourceMergesFiles(t *testing.T) {
+	dir := t.TempDir()
+	morning := filepath.Join(dir, "morning.jsonl")
+	afternoon := filepath.Join(dir, "afternoon.jsonl")
+	files := map[string]string{
+		morning: `{"timestamp":"2025-08-17T09:00:00Z","response":{"queues":[{"queue_id":"A"}]}}
+{"kind":"alert","timestamp":"2025-08-17T09:00:01Z","alert":{"entry_id":"E1"},"outcome":"sent"}
+{"timestamp":"2025-08-17T15:00:00Z","response":{"queues":[{"queue_id":"C"}]}}
+`,
+		afternoon: `{"timestamp":"2025-08-17T12:00:00Z","response":{"queues":[{"queue_id":"B"}]}}
+`,
+	}
+	for path, contents := range files {
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clock := NewVirtualClock(time.Time{})
+	replay, err := LoadReplaySource(clock, morning, afternoon)
+	if err != nil {
+		t.Fatalf("LoadReplaySource: %v", err)
+	}
+	if replay.Len() != 3 {
+		t.Errorf("Len = %d, want the 3 snapshots without the alert", replay.Len())
+	}
+
+	// Snapshots from both files are interleaved by time, and the clock
+	// follows them
+	var order []string
+	for replay.Advance() {
+		resp, err := replay.GetAllQueues()
+		if err != nil {
+			t.Fatalf("GetAllQueues: %v", err)
+		}
+		order = append(order, resp.Queues[0].QueueID+"@"+clock.Now().Format("15:04"))
+	}
+	if got, want := strings.Join(order, ","), "A@09:00,B@12:00,C@15:00"; got != want {
+		t.Errorf("replay order = %s, want %s", got, want)
+	}
+	if replay.Advance() {
+		t.Error("Advance after the last snapshot succeeded")
+	}
+
+	if _, err := LoadReplaySource(clock, filepath.Join(dir, "missing.jsonl")); err == nil {
+		t.Error("LoadReplaySource of a missing file succeeded")
+	}
+}
+
+func TestRunReplayDrivesScheduler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "incident.jsonl")
+	contents := `{"timestamp":"2025-08-17T12:00:00Z","response":{"queues":[{"queue_id":"Q1","name":"Clinic","entries":[{"id":"E1","msisdn":"2760","joined_at":"2025-08-17T11:59:00Z","status":"waiting"}]}]}}
+{"timestamp":"2025-08-17T12:02:00Z","response":{"queues":[{"queue_id":"Q1","name":"Clinic","entries":[{"id":"E1","msisdn":"2760","joined_at":"2025-08-17T11:59:00Z","status":"waiting"}]}]}}
+{"timestamp":"2025-08-17T12:06:00Z","response":{"queues":[{"queue_id":"Q1","name":"Clinic","entries":[{"id":"E1","msisdn":"2760","joined_at":"2025-08-17T11:59:00Z","status":"waiting"}]}]}}
+`
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	clock := NewVirtualClock(time.Time{})
+	replay, err := LoadReplaySource(clock, path)
+	if err != nil {
+		t.Fatalf("LoadReplaySource: %v", err)
+	}
+
+	graph := newFakeGraphAPI(t)
+	scheduler := mustNewScheduler(t, replay, NewQueueCalculator(clock, DefaultConfig()), NewAlertSystem(clock, graph.config(), DefaultConfig()), clock, DefaultConfig())
+	RunReplay(scheduler, replay)
+
+	// 12:00 sent, 12:02 rate limited, 12:06 sent again
+	if got := len(graph.sent()); got != 2 {
+		t.Errorf("replay sent %d messages, want 2", got)
+	}
+}
+
+func TestReplayReproducesLiveRun(t *testing.T) {
+	// A live run on a virtual clock, recorded as it goes
+	django := newFakeDjango(t, sampleQueue())
+	live := newFakeGraphAPI(t)
+	scheduler, clock := newTestScheduler(t, django, live)
+	dir := t.TempDir()
+	recorder, err := NewRecorder(clock, filepath.Join(dir, "rec"), 0, 0)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+	scheduler.recorder = recorder
+	scheduler.processQueues()
+	clock.Advance(6 * time.Minute)
+	scheduler.processQueues()
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "rec-*.jsonl.gz"))
+	if len(live.sent()) == 0 {
+		t.Fatal("live run sent nothing")
+	}
+
+	// Replays know nothing of the wall clock, so each sends exactly what
+	// the live run sent, wait estimates included
+	for run := 1; run <= 2; run++ {
+		replayClock := NewVirtualClock(time.Time{})
+		replay, err := LoadReplaySource(replayClock, files...)
+		if err != nil {
+			t.Fatalf("LoadReplaySource: %v", err)
+		}
+		graph := newFakeGraphAPI(t)
+		RunReplay(mustNewScheduler(t, replay, NewQueueCalculator(replayClock, DefaultConfig()), NewAlertSystem(replayClock, graph.config(), DefaultConfig()), replayClock, DefaultConfig()), replay)
+
+		got, want := messagesTo(graph), messagesTo(live)
+		if len(got) != len(want) {
+			t.Errorf("replay %d messaged %d customers, want %d", run, len(got), len(want))
+		}
+		for msisdn, messages := range want {
+			if strings.Join(got[msisdn], "|") != strings.Join(messages, "|") {
+				t.Errorf("replay %d messages to %s = %q, want %q", run, msisdn, got[msisdn], messages)
+			}
+		}
+	}
+}
+
+func TestReplayRecordingOfCrashedRecorder(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewRecorder(NewVirtualClock(testNow), filepath.Join(dir, "rec"), 0, 0)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+	var sizes []int64
+	for i := 0; i < 3; i++ {
+		recorder.RecordSnapshot(testNow.Add(time.Duration(i)*time.Minute), &APIResponse{Queues: []Queue{sampleQueue()}})
+		info, err := recorder.file.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, info.Size())
+	}
+	// The recorder is never closed, as if the process had crashed
+	files, _ := filepath.Glob(filepath.Join(dir, "rec-*.jsonl.gz"))
+
+	replay, err := LoadReplaySource(NewVirtualClock(time.Time{}), files...)
+	if err != nil {
+		t.Fatalf("LoadReplaySource: %v", err)
+	}
+	if replay.Len() != 3 {
+		t.Errorf("replay has %d snapshots, want the 3 flushed", replay.Len())
+	}
+
+	// Cut off in the middle of the last line, only that line is lost
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	cut := filepath.Join(dir, "cut.jsonl.gz")
+	if err := os.WriteFile(cut, data[:(sizes[1]+sizes[2])/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	replay, err = LoadReplaySource(NewVirtualClock(time.Time{}), cut)
+	if err != nil {
+		t.Fatalf("LoadReplaySource of a cut file: %v", err)
+	}
+	if replay.Len() != 2 {
+		t.Errorf("cut replay has %d snapshots, want 2", replay.Len())
+	}
+
+	// Corruption in the middle of a complete file is still an error
+	plain := filepath.Join(dir, "bad.jsonl")
+	if err := os.WriteFile(plain, []byte("{\"timestamp\":\"2025-08-17T12:00:00Z\"}\nnot json\n{}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadReplaySource(NewVirtualClock(time.Time{}), plain); err == nil {
+		t.Error("LoadReplaySource of a corrupt file succeeded")
+	}
+}
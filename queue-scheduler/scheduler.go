@@ -4,38 +4,140 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math"
+	"sort"
+	"sync"
 	"time"
 )
 
 // Scheduler handles periodic polling and processing of queue data
 type Scheduler struct {
-	client     *APIClient
+	source     QueueSource
 	calculator *QueueCalculator
 	alerter    *AlertSystem
-	interval   time.Duration
+	clock      Clock
+
+	// config holds the poll interval, per queue
+	config Config
 	
 	// Store previous state to detect changes
 	previousQueues map[string]Queue
+
+	// lastPolled is when each queue was last processed, to poll queues with
+	// longer intervals less often
+	lastPolled map[string]time.Time
+
+	// closed records which queues were outside their opening hours at the
+	// last poll
+	closed map[string]bool
+
+	// lastFetched is when the source was last fetched, to look for new
+	// queues while no known queue needs polling
+	lastFetched time.Time
+
+	// Optional on-disk persistence of previousQueues and alert state
+	store *StateStore
+
+	// Optional on-disk persistence of the calculator's service profiles
+	profileStore *ProfileStore
+
+	// Optional recorder capturing every fetched snapshot
+	recorder *Recorder
+
+	templates *MessageTemplates
+
+	// appointmentReminder is how long before an appointment its reminder
+	// goes out; appointment times are shown in location
+	appointmentReminder time.Duration
+	location            *time.Location
+
+	// appointmentAlerts records which reminder and no-show messages were
+	// sent, keyed by appointment key and message kind
+	appointmentAlerts map[string]time.Time
+
+	// Optional write-back of no-shows to Django
+	appointments AppointmentUpdater
+
+	// switchMinSaving is how much sooner another queue of the same counter
+	// pool must be before customers are told about it; zero never suggests
+	switchMinSaving time.Duration
+
+	// reloads hands new configurations to the polling loop
+	reloads chan reloadRequest
+
+	// Optional leader election between replicas: only the leader polls,
+	// sends and saves state. wasLeader is the leadership at the last poll.
+	elector   *LeaderElector
+	wasLeader bool
+
+	// Optional sharding of the queues between instances: only the queues
+	// this instance owns are processed. owned is the ownership at the last
+	// poll.
+	sharder *Sharder
+	owned   map[string]bool
+
+	// Optional tracking of prediction accuracy
+	accuracy *AccuracyTracker
+
+	// Optional forecaster. The latest forecasts, and the adaptive poll
+	// intervals, are read by the status server from other goroutines,
+	// hence the lock.
+	forecaster    *ArrivalForecaster
+	mu            sync.RWMutex
+	forecasts     map[string]QueueForecast
+	pollIntervals map[string]time.Duration
 }
 
-// NewScheduler creates a new scheduler with default 60-second interval
-func NewScheduler(apiClient *APIClient, calculator *QueueCalculator, alerter *AlertSystem) *Scheduler {
-	return &Scheduler{
-		client:         apiClient,
-		calculator:     calculator,
-		alerter:        alerter,
-		interval:       60 * time.Second,
-		previousQueues: make(map[string]Queue),
+// NewScheduler creates a new scheduler polling at config's intervals with its
+// templates and thresholds. It fails if the message templates don't parse.
+func NewScheduler(source QueueSource, calculator *QueueCalculator, alerter *AlertSystem, clock Clock, config Config) (*Scheduler, error) {
+	templates, err := NewMessageTemplates(config.Templates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message templates: %w", err)
 	}
+
+	return &Scheduler{
+		source:              source,
+		calculator:          calculator,
+		alerter:             alerter,
+		clock:               clock,
+		config:              config,
+		templates:           templates,
+		previousQueues:      make(map[string]Queue),
+		lastPolled:          make(map[string]time.Time),
+		closed:              make(map[string]bool),
+		owned:               make(map[string]bool),
+		appointmentReminder: config.AppointmentReminder,
+		location:            time.Local,
+		appointmentAlerts:   make(map[string]time.Time),
+		switchMinSaving:     config.SwitchMinSaving,
+		reloads:             make(chan reloadRequest),
+		forecasts:           make(map[string]QueueForecast),
+		pollIntervals:       make(map[string]time.Duration),
+	}, nil
 }
 
 // Start begins the periodic polling loop
 func (s *Scheduler) Start(ctx context.Context) {
-	log.Printf("Starting queue scheduler with %v interval", s.interval)
+	log.Printf("Starting queue scheduler with %v interval", s.config.MinPollInterval())
 	
-	ticker := time.NewTicker(s.interval)
+	ticker := time.NewTicker(s.config.MinPollInterval())
 	defer ticker.Stop()
 
+	// Sources that support change notifications trigger an immediate poll;
+	// a nil channel never fires, leaving the ticker in charge
+	var changes <-chan struct{}
+	if notifier, ok := s.source.(ChangeNotifier); ok {
+		changes = notifier.Changes()
+		log.Println("Queue source supports change notifications")
+	}
+
+	// Becoming the leader triggers a poll too, so failover is prompt
+	var elected <-chan struct{}
+	if s.elector != nil {
+		elected = s.elector.Elected()
+	}
+
 	// Initial run
 	s.processQueues()
 
@@ -43,39 +145,174 @@ func (s *Scheduler) Start(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			log.Println("Scheduler stopping...")
+			s.saveState()
 			return
 		case <-ticker.C:
 			s.processQueues()
+		case <-changes:
+			log.Println("Queue change notification received")
+			s.pollQueues(true)
+		case <-elected:
+			s.processQueues()
+		case reload := <-s.reloads:
+			interval := s.config.MinPollInterval()
+			reload.done <- s.applyConfig(reload.config)
+			if s.config.MinPollInterval() != interval {
+				log.Printf("Now polling every %v", s.config.MinPollInterval())
+				ticker.Reset(s.config.MinPollInterval())
+			}
 		}
 	}
 }
 
-// processQueues fetches queue data and processes alerts
+// processQueues fetches queue data and processes alerts for the queues due
+// a poll
 func (s *Scheduler) processQueues() {
+	s.pollQueues(false)
+}
+
+// pollQueues fetches queue data and processes alerts for the queues whose
+// poll interval has passed, or every queue when all is set
+func (s *Scheduler) pollQueues(all bool) {
+	if !s.leading() {
+		return // another replica is the leader
+	}
+
+	// New queues only show up in a fetch, so one is made at least every
+	// longest poll interval, even with every known queue closed or idle
+	now := s.clock.Now()
+	lookForNew := now.Sub(s.lastFetched) >= s.config.MaxPollInterval()
+	if s.allClosed(now) && !lookForNew {
+		return // every queue is outside its opening hours
+	}
+	if !all && !s.anyPollDue(now) && !lookForNew {
+		return // the ticker runs at the shortest interval, most ticks have nothing due
+	}
+
 	log.Println("Fetching queue data...")
-	
-	response, err := s.client.GetAllQueues()
+	s.lastFetched = now
+
+	response, err := s.source.GetAllQueues()
 	if err != nil {
 		log.Printf("Error fetching queues: %v", err)
 		return
 	}
 
-	log.Printf("Processing %d queues", len(response.Queues))
+	if s.recorder != nil {
+		s.recorder.RecordSnapshot(s.clock.Now(), response)
+	}
+
+	var due []Queue
+	open := s.openQueues(response.Queues, now)
+	owned := make(map[string]bool, len(open))
+	for _, queue := range open {
+		if !s.ownsQueue(queue.QueueID) {
+			// Another instance processes it. Its latest snapshot is kept so
+			// that customers already being served aren't told again if the
+			// queue moves here.
+			s.previousQueues[queue.QueueID] = queue
+			continue
+		}
+		owned[queue.QueueID] = true
+		if !all && !s.pollDue(queue.QueueID, now) {
+			continue
+		}
+		if previous, ok := s.previousQueues[queue.QueueID]; ok {
+			s.calculator.ObserveTransitions(previous, queue)
+		}
+		due = append(due, queue)
+	}
+
+	log.Printf("Processing %d of %d queues", len(due), len(response.Queues))
 
-	for _, queue := range response.Queues {
-		s.processQueue(queue)
+	// Queues not due still count for counter pools and faster queue
+	// suggestions; closed ones don't
+	poll := pollResult{queues: make(map[string]Queue, len(open))}
+	estimated := s.estimatedQueues(open, owned)
+	for _, queue := range estimated {
+		poll.queues[queue.QueueID] = queue
+	}
+	poll.estimates = s.calculator.EstimateQueues(estimated)
+
+	for _, queue := range due {
+		if !s.stillLeading() {
+			log.Printf("🪑 Lost leadership mid-poll, leaving %s and later queues to the new leader", queue.QueueID)
+			break
+		}
+		s.processQueue(queue, poll)
+		if s.config.AdaptivePolling.Enabled() {
+			s.updatePollInterval(queue, poll.estimates[queue.QueueID])
+		}
 		
 		// Update previous state
 		s.previousQueues[queue.QueueID] = queue
+		s.lastPolled[queue.QueueID] = now
+	}
+
+	s.pruneAppointmentAlerts()
+	s.saveState()
+}
+
+// pollDue reports whether a queue's poll interval has passed since it was
+// last processed. Half a tick of slack keeps ticker jitter from making a
+// queue skip a whole tick.
+func (s *Scheduler) pollDue(queueID string, now time.Time) bool {
+	last, ok := s.lastPolled[queueID]
+	if !ok {
+		return true
+	}
+	slack := s.config.MinPollInterval() / 2
+	return now.Sub(last)+slack >= s.pollInterval(queueID)
+}
+
+// anyPollDue reports whether any open queue this instance processes is due
+// for a poll, so the source is worth fetching. Before the first fetch every
+// queue is.
+func (s *Scheduler) anyPollDue(now time.Time) bool {
+	if len(s.closed) == 0 {
+		return true
+	}
+
+	for queueID := range s.closed {
+		if s.ownsQueue(queueID) && s.config.Queue(queueID).isOpen(now) && s.pollDue(queueID, now) {
+			return true
+		}
 	}
+	return false
+}
+
+// pollResult is what one poll saw: every queue estimated and its estimate,
+// by queue ID
+type pollResult struct {
+	queues    map[string]Queue
+	estimates map[string]QueueEstimate
 }
 
 // processQueue handles a single queue's processing and alerts
-func (s *Scheduler) processQueue(queue Queue) {
-	stats := s.calculator.CalculateQueueStats(queue)
+func (s *Scheduler) processQueue(queue Queue, poll pollResult) {
+	estimate := poll.estimates[queue.QueueID]
+	stats := estimate.Stats
 	
-	log.Printf("Queue %s (%s): %d active, avg process time: %v", 
-		queue.QueueID, queue.Name, stats.ActiveEntries, stats.AverageProcessTime)
+	log.Printf("Queue %s (%s): %d active, %d/%d counters busy, avg process time: %v", 
+		queue.QueueID, queue.Name, stats.ActiveEntries, stats.BusyServers, stats.Servers, stats.AverageProcessTime)
+	if stats.ReservedSlots > 0 {
+		log.Printf("Queue %s: %d appointment slots reserved", queue.QueueID, stats.ReservedSlots)
+	}
+	if stats.ExpectedAbandonments > 0 {
+		log.Printf("Queue %s: %.1f of the waiting customers expected to leave, effective length %.1f",
+			queue.QueueID, stats.ExpectedAbandonments, stats.EffectiveLength)
+	}
+
+	if s.accuracy != nil {
+		s.accuracy.Observe(queue, estimate)
+	}
+
+	if s.forecaster != nil {
+		forecast := s.forecaster.Forecast(queue, estimate, forecastHorizon)
+		s.mu.Lock()
+		s.forecasts[queue.QueueID] = forecast
+		s.mu.Unlock()
+	}
 
 	// Process each active entry for potential alerts
 	for _, entry := range queue.Entries {
@@ -83,61 +320,186 @@ func (s *Scheduler) processQueue(queue Queue) {
 			continue // Skip inactive entries
 		}
 
-		s.checkForAlerts(queue, entry, stats)
+		s.checkForAlerts(queue, entry, poll)
 	}
+
+	s.checkAppointments(queue, estimate)
 }
 
 // checkForAlerts determines if alerts should be sent for a queue entry
-func (s *Scheduler) checkForAlerts(queue Queue, entry QueueEntry, stats QueueStats) {
-	waitTime := s.calculator.EstimateWaitTimeForPosition(queue, entry.MSISDN)
-	position := s.calculator.findPositionInQueue(queue, entry.MSISDN)
+func (s *Scheduler) checkForAlerts(queue Queue, entry QueueEntry, poll pollResult) {
+	estimate := poll.estimates[queue.QueueID]
+	waitTime := estimate.Waits[entry.Key()]
+	position := estimate.Positions[entry.Key()]
 
-	shouldAlert := false
-	var message string
+	var kind string
 
-	if entry.Status == "in_progress" && !s.wasInProgress(queue.QueueID, entry.MSISDN) {
-		shouldAlert = true
-		message = "🔔 You're now being served! Please proceed to the counter."
+	if entry.Status == "in_progress" && !s.wasInProgress(queue.QueueID, entry.Key()) {
+		kind = messageServing
 	} else if entry.Status == "waiting" {
 		// Send update to ALL waiting customers with their position and wait time
-		shouldAlert = true
-		
-		if position == 1 {
-			message = "⏰ You're NEXT! Please be ready. Estimated wait: 0-2 minutes"
-		} else if position == 2 {
-			message = fmt.Sprintf("📍 Position #%d - You're almost up! Estimated wait: %d minutes", 
-				position, int(waitTime.Minutes()))
-		} else {
-			message = fmt.Sprintf("📋 Position #%d in %s. Estimated wait: %d minutes", 
-				position, queue.Name, int(waitTime.Minutes()))
+		switch position {
+		case 1:
+			kind = messageNext
+		case 2:
+			kind = messageAlmost
+		default:
+			kind = messagePosition
 		}
 	}
 
-	if shouldAlert {
+	if kind != "" && !s.holdForQuietHours(queue.QueueID, kind, entry.Key()) {
+		data := newMessageData(queue.Name, position, waitTime, estimate.Ranges[entry.Key()])
+		data.EffectivePosition = int(math.Round(estimate.EffectivePositions[entry.Key()]))
+		data.Priority = entry.PriorityClass()
+		data.PriorityAhead = estimate.PriorityAhead[entry.Key()]
+		if kind == messagePosition {
+			if faster, fasterWait, ok := s.fasterQueue(queue.QueueID, waitTime, poll.queues, poll.estimates); ok {
+				data.FasterQueue = faster.Name
+				data.FasterWaitMinutes = int(fasterWait.Minutes())
+			}
+		}
+		message, err := s.templates.Render(kind, data)
+		if err != nil {
+			log.Printf("Error rendering message for %s: %v", entry.Key(), err)
+			return
+		}
+
 		alert := AlertRequest{
+			EntryID:   entry.Key(),
 			MSISDN:    entry.MSISDN,
 			Message:   message,
 			Channel:   "whatsapp", // Default channel
 			QueueID:   queue.QueueID,
-			Timestamp: time.Now(),
+			Kind:      kind,
+			Timestamp: s.clock.Now(),
+		}
+
+		if !s.stillLeading() {
+			log.Printf("🪑 Not sending %s message to %s: no longer the leader", kind, entry.Key())
+			return
+		}
+		outcome := s.alerter.SendAlert(alert)
+
+		// Score the wait the customer was actually told
+		told := outcome == alertOutcomeSent || outcome == alertOutcomeDryRun
+		if s.accuracy != nil && kind != messageServing && told {
+			s.accuracy.RecordAlert(queue.QueueID, entry.Key(), waitTime)
 		}
+	}
+}
 
-		s.alerter.SendAlert(alert)
+// Forecasts returns the latest forecast of every queue, ordered by queue ID.
+// It is empty unless a forecaster is set.
+func (s *Scheduler) Forecasts() []QueueForecast {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	forecasts := make([]QueueForecast, 0, len(s.forecasts))
+	for _, forecast := range s.forecasts {
+		forecasts = append(forecasts, forecast)
 	}
+	sort.Slice(forecasts, func(i, j int) bool { return forecasts[i].QueueID < forecasts[j].QueueID })
+	return forecasts
 }
 
-// wasInProgress checks if a customer was already in progress in previous state
-func (s *Scheduler) wasInProgress(queueID, msisdn string) bool {
+// wasInProgress checks if an entry was already in progress in previous state
+func (s *Scheduler) wasInProgress(queueID, entryKey string) bool {
 	prevQueue, exists := s.previousQueues[queueID]
 	if !exists {
 		return false
 	}
 
 	for _, entry := range prevQueue.Entries {
-		if entry.MSISDN == msisdn && entry.Status == "in_progress" {
+		if entry.Key() == entryKey && entry.Status == "in_progress" {
 			return true
 		}
 	}
 	
 	return false
-}
\ No newline at end of file
+}
+
+// RestoreState loads persisted state from store and keeps saving to it after
+// every poll, so a restart doesn't re-notify customers already in progress
+func (s *Scheduler) RestoreState(store *StateStore) error {
+	snapshot, err := store.Load()
+	if err != nil {
+		// Leave persistence disabled rather than overwrite a file we can't read
+		return err
+	}
+	s.store = store
+
+	if snapshot == nil {
+		log.Println("No saved scheduler state found, starting fresh")
+		return nil
+	}
+
+	s.previousQueues = snapshot.PreviousQueues
+	s.alerter.RestoreSentAlerts(snapshot.SentAlerts)
+	s.alerter.RestoreDailyMessages(snapshot.DailyMessages)
+	s.calculator.RestoreAbandonmentCurves(snapshot.Abandonment)
+	if snapshot.AppointmentAlerts != nil {
+		s.appointmentAlerts = snapshot.AppointmentAlerts
+	}
+	if s.accuracy != nil {
+		s.accuracy.RestoreTotals(snapshot.Accuracy)
+	}
+
+	log.Printf("Restored scheduler state from %v: %d queues, %d alert records",
+		snapshot.SavedAt.Format(time.RFC3339), len(snapshot.PreviousQueues), len(snapshot.SentAlerts))
+	return nil
+}
+
+// RestoreProfiles loads service time profiles bucketed in location from
+// store into the calculator and saves them back whenever they have learned
+// something new. Without a saved file the calculator starts empty profiles.
+func (s *Scheduler) RestoreProfiles(store *ProfileStore, location *time.Location) error {
+	profiles, err := store.Load(location)
+	if err != nil {
+		return err
+	}
+	s.profileStore = store
+
+	if profiles == nil {
+		log.Println("No saved service profiles found, learning from scratch")
+		profiles = NewServiceProfiles(location)
+	} else {
+		log.Printf("Restored service profiles for %d queues", len(profiles.queues))
+	}
+	s.calculator.SetProfiles(profiles)
+	return nil
+}
+
+// saveState writes the current state to the store, if one is configured
+func (s *Scheduler) saveState() {
+	if s.elector != nil && !s.elector.IsLeader() {
+		return // the leader's state is the one to keep
+	}
+
+	if s.profileStore != nil && s.calculator.profiles != nil && s.calculator.profiles.dirty {
+		if err := s.profileStore.Save(s.calculator.profiles, s.clock.Now()); err != nil {
+			log.Printf("Error saving service profiles: %v", err)
+		}
+	}
+
+	if s.store == nil {
+		return
+	}
+
+	snapshot := StateSnapshot{
+		SavedAt:        s.clock.Now(),
+		PreviousQueues: s.previousQueues,
+		SentAlerts:     s.alerter.SentAlerts(),
+		Abandonment:    s.calculator.AbandonmentCurves(),
+
+		AppointmentAlerts: s.appointmentAlerts,
+		DailyMessages:     s.alerter.DailyMessages(),
+	}
+	if s.accuracy != nil {
+		snapshot.Accuracy = s.accuracy.Totals()
+	}
+
+	if err := s.store.Save(snapshot); err != nil {
+		log.Printf("Error saving scheduler state: %v", err)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDjango serves /queues/all/ from whatever queues the test sets
+type fakeDjango struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	queues  []Queue
+	fetched int
+}
+
+func newFakeDjango(t *testing.T, queues ...Queue) *fakeDjango {
+	t.Helper()
+
+	fake := &fakeDjango{queues: queues}
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/queues/all/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.fetched++
+		json.NewEncoder(w).Encode(APIResponse{Queues: fake.queues})
+	}))
+	t.Cleanup(fake.Close)
+
+	return fake
+}
+
+func (f *fakeDjango) setQueues(queues ...Queue) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queues = queues
+}
+
+// fetches returns how many times the queues were fetched
+func (f *fakeDjango) fetches() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fetched
+}
+
+// newTestScheduler wires a scheduler to the fakes on a virtual clock
+func newTestScheduler(t *testing.T, django *fakeDjango, graph *fakeGraphAPI) (*Scheduler, *VirtualClock) {
+	t.Helper()
+	return newConfiguredTestScheduler(t, django, graph, DefaultConfig())
+}
+
+// newConfiguredTestScheduler is newTestScheduler with the given settings
+func newConfiguredTestScheduler(t *testing.T, django *fakeDjango, graph *fakeGraphAPI, config Config) (*Scheduler, *VirtualClock) {
+	t.Helper()
+
+	clock := NewVirtualClock(testNow)
+	scheduler := mustNewScheduler(t,
+		NewAPIClient(django.URL),
+		NewQueueCalculator(clock, config),
+		NewAlertSystem(clock, graph.config(), config),
+		clock,
+		config,
+	)
+	return scheduler, clock
+}
+
+// mustNewScheduler is NewScheduler, failing the test on an error
+func mustNewScheduler(t *testing.T, source QueueSource, calculator *QueueCalculator, alerter *AlertSystem, clock Clock, config Config) *Scheduler {
+	t.Helper()
+
+	scheduler, err := NewScheduler(source, calculator, alerter, clock, config)
+	if err != nil {
+		t.Fatalf("NewScheduler: %v", err)
+	}
+	return scheduler
+}
+
+// messagesTo groups received message bodies by recipient
+func messagesTo(graph *fakeGraphAPI) map[string][]string {
+	byRecipient := make(map[string][]string)
+	for _, message := range graph.sent() {
+		byRecipient[message.To] = append(byRecipient[message.To], message.Text.Body)
+	}
+	return byRecipient
+}
+
+func TestProcessQueuesMessages(t *testing.T) {
+	django := newFakeDjango(t, sampleQueue())
+	graph := newFakeGraphAPI(t)
+	scheduler, _ := newTestScheduler(t, django, graph)
+
+	scheduler.processQueues()
+
+	got := messagesTo(graph)
+	tests := []struct {
+		msisdn string
+		want   string
+	}{
+		{"2760CURR1", "You're now being served"},
+		{"2760WAIT1", "You're NEXT"},
+		// Two counters: WAIT1 takes the idle one, WAIT2 waits for CURR1 to
+		// finish (only the 5 minute service outlasted CURR1's 3 minutes so
+		// far), WAIT3 for WAIT1's 2-5 minute service
+		{"2760WAIT2", "Position #2 - You're almost up! Estimated wait: 2 minutes"},
+		{"2760WAIT3", "Position #3 in Test Queue. Estimated wait: 3–5 minutes"},
+	}
+	for _, tt := range tests {
+		messages := got[tt.msisdn]
+		if len(messages) != 1 || !strings.Contains(messages[0], tt.want) {
+			t.Errorf("messages to %s = %q, want one containing %q", tt.msisdn, messages, tt.want)
+		}
+	}
+	if len(got) != len(tests) {
+		t.Errorf("messaged %d recipients, want %d: %v", len(got), len(tests), got)
+	}
+}
+
+func TestNextMessageUsesEstimate(t *testing.T) {
+	// One counter, 8 minute services, current customer 2 minutes in
+	queue := Queue{QueueID: "Q1", Name: "Clinic", Entries: []QueueEntry{
+		servedEntry("S1", 3*time.Minute, 8*time.Minute),
+		inProgressEntry("B1", 2*time.Minute),
+		waitingEntry("W1", time.Minute),
+	}}
+
+	django := newFakeDjango(t, queue)
+	graph := newFakeGraphAPI(t)
+	scheduler, _ := newTestScheduler(t, django, graph)
+	scheduler.calculator.SetServerCount("Q1", 1)
+
+	scheduler.processQueues()
+
+	messages := messagesTo(graph)["2760W1"]
+	if len(messages) != 1 || !strings.Contains(messages[0], "You're NEXT! Please be ready. Estimated wait: 6 minutes") {
+		t.Errorf("messages = %q, want next-in-line with 6 minute wait", messages)
+	}
+}
+
+func TestInProgressNotifiedOnce(t *testing.T) {
+	waiting := Queue{QueueID: "Q1", Name: "Clinic", Entries: []QueueEntry{waitingEntry("E1", time.Minute)}}
+	serving := Queue{QueueID: "Q1", Name: "Clinic", Entries: []QueueEntry{inProgressEntry("E1", 0)}}
+
+	django := newFakeDjango(t, waiting)
+	graph := newFakeGraphAPI(t)
+	scheduler, clock := newTestScheduler(t, django, graph)
+
+	scheduler.processQueues()
+
+	// Status change is announced even inside the rate limit window
+	django.setQueues(serving)
+	clock.Advance(2 * time.Minute)
+	scheduler.processQueues()
+
+	// Still in progress after the window: no repeat announcement
+	clock.Advance(10 * time.Minute)
+	scheduler.processQueues()
+
+	messages := messagesTo(graph)["2760E1"]
+	if len(messages) != 2 {
+		t.Fatalf("messages = %q, want position update then serving notice", messages)
+	}
+	if !strings.Contains(messages[1], "now being served") {
+		t.Errorf("second message = %q, want serving notice", messages[1])
+	}
+}
+
+func TestRejoinedCustomerIsTrackedSeparately(t *testing.T) {
+	// Same phone number: earlier entry in progress, new entry waiting
+	first := inProgressEntry("E1", time.Minute)
+	first.MSISDN = "27601234567"
+	rejoin := waitingEntry("E2", 0)
+	rejoin.MSISDN = "27601234567"
+	queue := Queue{QueueID: "Q1", Name: "Clinic", Entries: []QueueEntry{first, rejoin}}
+
+	django := newFakeDjango(t, queue)
+	graph := newFakeGraphAPI(t)
+	scheduler, _ := newTestScheduler(t, django, graph)
+
+	scheduler.processQueues()
+
+	messages := messagesTo(graph)["27601234567"]
+	if len(messages) != 2 {
+		t.Fatalf("messages = %q, want one per entry", messages)
+	}
+}
+
+func TestSharedMSISDNEntriesAreTrackedByKey(t *testing.T) {
+	for _, withIDs := range []bool{true, false} {
+		t.Run(fmt.Sprintf("with IDs %v", withIDs), func(t *testing.T) {
+			// Two entries on one phone, e.g. a parent booking for two children
+			first := inProgressEntry("E1", time.Minute)
+			second := waitingEntry("E2", 0)
+			first.MSISDN, second.MSISDN = "27601234567", "27601234567"
+			if !withIDs {
+				first.ID, second.ID = "", ""
+			}
+			django := newFakeDjango(t, Queue{QueueID: "Q1", Name: "Clinic", Entries: []QueueEntry{first, second}})
+			graph := newFakeGraphAPI(t)
+			scheduler, clock := newTestScheduler(t, django, graph)
+			scheduler.processQueues()
+
+			// The second is called as the first finishes: the phone number was
+			// already in progress, but this entry wasn't
+			first.Status = "served"
+			served := clock.Now()
+			first.ServedAt = &served
+			second.Status = "in_progress"
+			second.StartedAt = &served
+			django.setQueues(Queue{QueueID: "Q1", Name: "Clinic", Entries: []QueueEntry{first, second}})
+			clock.Advance(time.Minute)
+			scheduler.processQueues()
+
+			serving := 0
+			for _, message := range messagesTo(graph)["27601234567"] {
+				if strings.Contains(message, "being served") {
+					serving++
+				}
+			}
+			if serving != 2 {
+				t.Errorf("sent %d serving notices, want one per entry: %q", serving, messagesTo(graph)["27601234567"])
+			}
+		})
+	}
+}
+
+func TestRestoredStatePreventsRenotification(t *testing.T) {
+	queue := Queue{QueueID: "Q1", Name: "Clinic", Entries: []QueueEntry{inProgressEntry("E1", time.Minute)}}
+	django := newFakeDjango(t, queue)
+	store := NewStateStore(filepath.Join(t.TempDir(), "state.json"))
+
+	graph := newFakeGraphAPI(t)
+	scheduler, _ := newTestScheduler(t, django, graph)
+	if err := scheduler.RestoreState(store); err != nil {
+		t.Fatalf("RestoreState: %v", err)
+	}
+	scheduler.processQueues()
+	if got := len(graph.sent()); got != 1 {
+		t.Fatalf("first run sent %d messages, want 1", got)
+	}
+
+	// Simulated restart
+	restartedGraph := newFakeGraphAPI(t)
+	restarted, _ := newTestScheduler(t, django, restartedGraph)
+	if err := restarted.RestoreState(store); err != nil {
+		t.Fatalf("RestoreState after restart: %v", err)
+	}
+	restarted.processQueues()
+
+	if got := len(restartedGraph.sent()); got != 0 {
+		t.Errorf("restart re-sent %d messages: %v", got, messagesTo(restartedGraph))
+	}
+}
+
+func TestRestoredStateKeepsRateLimits(t *testing.T) {
+	queue := Queue{QueueID: "Q1", Name: "Clinic", Entries: []QueueEntry{waitingEntry("W1", time.Minute)}}
+	django := newFakeDjango(t, queue)
+	store := NewStateStore(filepath.Join(t.TempDir(), "state.json"))
+
+	graph := newFakeGraphAPI(t)
+	scheduler, _ := newTestScheduler(t, django, graph)
+	if err := scheduler.RestoreState(store); err != nil {
+		t.Fatalf("RestoreState: %v", err)
+	}
+	scheduler.processQueues()
+
+	// Restarted two minutes later, still within the rate limit window
+	restartedGraph := newFakeGraphAPI(t)
+	restarted, clock := newTestScheduler(t, django, restartedGraph)
+	if err := restarted.RestoreState(store); err != nil {
+		t.Fatalf("RestoreState after restart: %v", err)
+	}
+	clock.Advance(2 * time.Minute)
+	restarted.processQueues()
+	if got := len(restartedGraph.sent()); got != 0 {
+		t.Errorf("restart sent %d messages within the window, want 0", got)
+	}
+
+	// Once the window has passed the customer hears from us again
+	clock.Advance(4 * time.Minute)
+	restarted.processQueues()
+	if got := len(restartedGraph.sent()); got != 1 {
+		t.Errorf("sent %d messages after the window, want 1", got)
+	}
+}
+
+func TestProcessQueuesSourceError(t *testing.T) {
+	graph := newFakeGraphAPI(t)
+	clock := NewVirtualClock(testNow)
+	scheduler := mustNewScheduler(t, NewAPIClient("http://127.0.0.1:0"), NewQueueCalculator(clock, DefaultConfig()),
+		NewAlertSystem(clock, graph.config(), DefaultConfig()), clock, DefaultConfig())
+
+	scheduler.processQueues()
+
+	if got := len(graph.sent()); got != 0 {
+		t.Errorf("sent %d messages despite fetch error", got)
+	}
+}
+
+func TestNewSchedulerRejectsBadTemplates(t *testing.T) {
+	config := DefaultConfig()
+	config.Templates = map[string]string{messageNext: "{{.Wait"}
+	clock := NewVirtualClock(testNow)
+	_, err := NewScheduler(NewAPIClient("http://127.0.0.1:0"), NewQueueCalculator(clock, config),
+		NewAlertSystem(clock, WhatsAppConfig{}, config), clock, config)
+	if err == nil || !strings.Contains(err.Error(), "invalid next message template") {
+		t.Errorf("NewScheduler error = %v, want the bad template reported", err)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// StatusServer exposes what the scheduler knows over HTTP for dashboards
+// and staff planning
+type StatusServer struct {
+	server    *http.Server
+	scheduler *Scheduler
+}
+
+// NewStatusServer creates a server for scheduler listening on addr
+func NewStatusServer(addr string, scheduler *Scheduler) *StatusServer {
+	s := &StatusServer{scheduler: scheduler}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /forecast", s.handleForecast)
+	mux.HandleFunc("GET /accuracy", s.handleAccuracy)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	mux.HandleFunc("GET /leader", s.handleLeader)
+
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Start serves in the background until Shutdown
+func (s *StatusServer) Start() {
+	log.Printf("Status server listening on %s", s.server.Addr)
+
+	go func() {
+		if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Status server error: %v", err)
+		}
+	}()
+}
+
+// Shutdown stops the server, waiting for requests in flight
+func (s *StatusServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// handleForecast serves the latest forecasts as JSON, optionally for one
+// queue (?queue=ID) and fewer hours (?hours=N)
+func (s *StatusServer) handleForecast(w http.ResponseWriter, r *http.Request) {
+	queueID := r.URL.Query().Get("queue")
+
+	hours := forecastHorizon
+	if value := r.URL.Query().Get("hours"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > forecastHorizon {
+			http.Error(w, "hours must be between 1 and "+strconv.Itoa(forecastHorizon), http.StatusBadRequest)
+			return
+		}
+		hours = n
+	}
+
+	forecasts := []QueueForecast{}
+	for _, forecast := range s.scheduler.Forecasts() {
+		if queueID != "" && forecast.QueueID != queueID {
+			continue
+		}
+		if len(forecast.Hours) > hours {
+			forecast.Hours = forecast.Hours[:hours]
+		}
+		forecasts = append(forecasts, forecast)
+	}
+
+	if queueID != "" && len(forecasts) == 0 {
+		http.Error(w, "no forecast for queue "+queueID, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"forecasts": forecasts})
+}
+
+// handleAccuracy serves the prediction accuracy report as JSON. Durations
+// are in seconds.
+func (s *StatusServer) handleAccuracy(w http.ResponseWriter, r *http.Request) {
+	type row struct {
+		QueueID     string  `json:"queue_id"`
+		Estimator   string  `json:"estimator"`
+		Kind        string  `json:"kind"`
+		Count       int     `json:"count"`
+		MAESeconds  float64 `json:"mae_seconds"`
+		BiasSeconds float64 `json:"bias_seconds"`
+	}
+
+	rows := []row{}
+	if s.scheduler.accuracy != nil {
+		for _, stats := range s.scheduler.accuracy.Report() {
+			rows = append(rows, row{stats.QueueID, stats.Estimator, stats.Kind, stats.Count,
+				stats.MAE.Seconds(), stats.Bias.Seconds()})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"accuracy": rows})
+}
+
+// handleMetrics serves metrics in the Prometheus text format
+func (s *StatusServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if s.scheduler.accuracy != nil {
+		s.scheduler.accuracy.WriteMetrics(w)
+	}
+	s.scheduler.WritePollMetrics(w)
+	if s.scheduler.elector != nil {
+		s.scheduler.elector.WriteMetrics(w)
+	}
+	if s.scheduler.sharder != nil {
+		s.scheduler.sharder.WriteMetrics(w)
+	}
+}
+
+// handleLeader serves this replica's leadership as JSON, 404 without leader
+// election
+func (s *StatusServer) handleLeader(w http.ResponseWriter, r *http.Request) {
+	if s.scheduler.elector == nil {
+		http.Error(w, "leader election is off", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.scheduler.elector.Status())
+}
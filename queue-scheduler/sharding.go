@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ringReplicas is how many points each member gets on the hash ring. More
+// points spread queues more evenly.
+const ringReplicas = 100
+
+// memberFileSuffix marks heartbeat files in a membership directory
+const memberFileSuffix = ".member"
+
+// HashRing assigns keys to members by consistent hashing, so a member
+// joining or leaving only moves the keys it takes or gives up
+type HashRing struct {
+	members []string
+	points  []uint64
+	owners  map[uint64]string
+}
+
+// NewHashRing builds a ring of the members
+func NewHashRing(members []string) *HashRing {
+	ring := &HashRing{owners: make(map[uint64]string)}
+	for _, member := range members {
+		ring.members = append(ring.members, member)
+		for i := 0; i < ringReplicas; i++ {
+			point := ringHash(member + "#" + strconv.Itoa(i))
+			ring.owners[point] = member
+			ring.points = append(ring.points, point)
+		}
+	}
+	slices.Sort(ring.points)
+	sort.Strings(ring.members)
+	return ring
+}
+
+// ringHash places a string on the ring. Queue IDs are short and similar,
+// which FNV spreads poorly, so a cryptographic hash is used.
+func ringHash(value string) uint64 {
+	sum := sha256.Sum256([]byte(value))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// Owner returns the member a key belongs to: the first point at or after
+// the key's hash, wrapping around. It is empty for an empty ring.
+func (r *HashRing) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	hash := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// Members returns the ring's members in order
+func (r *HashRing) Members() []string {
+	return r.members
+}
+
+// Membership discovers the scheduler instances sharing the queues
+type Membership interface {
+	// Heartbeat announces that identity is alive and returns every live
+	// member
+	Heartbeat(ctx context.Context, identity string) ([]string, error)
+
+	// Leave announces that identity is going away
+	Leave(identity string) error
+
+	Name() string
+}
+
+// NewMembership builds the membership from a fixed, comma-separated list of
+// members, or from heartbeats in a shared directory. Both empty means no
+// sharding.
+func NewMembership(static, directory string, ttl time.Duration, clock Clock) (Membership, error) {
+	switch {
+	case static != "" && directory != "":
+		return nil, fmt.Errorf("set only one of SHARD_MEMBERS and SHARD_DIRECTORY")
+	case static != "":
+		var members StaticMembership
+		for _, member := range strings.Split(static, ",") {
+			if member = strings.TrimSpace(member); member != "" {
+				members = append(members, member)
+			}
+		}
+		return members, nil
+	case directory != "":
+		if err := os.MkdirAll(directory, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create shard directory: %w", err)
+		}
+		return NewDirectoryMembership(directory, ttl, clock), nil
+	default:
+		return nil, nil
+	}
+}
+
+// StaticMembership is a fixed list of members, e.g. one per replica of a
+// StatefulSet. Every member is assumed alive, so there is no failover: the
+// queues of a member that is down wait until it is back. Use a
+// DirectoryMembership where another instance should take them over.
+type StaticMembership []string
+
+// Heartbeat returns the listed members
+func (m StaticMembership) Heartbeat(ctx context.Context, identity string) ([]string, error) {
+	if !slices.Contains(m, identity) {
+		return nil, fmt.Errorf("instance %s is not one of the shard members %s", identity, strings.Join(m, ", "))
+	}
+	return m, nil
+}
+
+// Leave does nothing: static members are always counted
+func (m StaticMembership) Leave(identity string) error {
+	return nil
+}
+
+// Name describes the membership for logs
+func (m StaticMembership) Name() string {
+	return "static members " + strings.Join(m, ", ")
+}
+
+// memberRecord is the content of a heartbeat file
+type memberRecord struct {
+	ID          string    `json:"id"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+}
+
+// DirectoryMembership finds members by heartbeat files in a directory on
+// storage shared by the instances. Each instance rewrites
+// "<identity>.member" on every heartbeat; one not rewritten within the TTL
+// counts as gone.
+type DirectoryMembership struct {
+	dir   string
+	ttl   time.Duration
+	clock Clock
+}
+
+// NewDirectoryMembership creates a membership in dir, where heartbeats
+// older than ttl are ignored
+func NewDirectoryMembership(dir string, ttl time.Duration, clock Clock) *DirectoryMembership {
+	return &DirectoryMembership{dir: dir, ttl: ttl, clock: clock}
+}
+
+// Name describes the membership for logs
+func (m *DirectoryMembership) Name() string {
+	return "shard directory " + m.dir
+}
+
+// Heartbeat writes identity's heartbeat file and lists the members whose
+// heartbeats are recent
+func (m *DirectoryMembership) Heartbeat(ctx context.Context, identity string) ([]string, error) {
+	now := m.clock.Now()
+	data, err := json.Marshal(memberRecord{ID: identity, HeartbeatAt: now})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode heartbeat: %w", err)
+	}
+	if err := writeFileAtomic(m.memberPath(identity), data); err != nil {
+		return nil, fmt.Errorf("failed to write heartbeat: %w", err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(m.dir, "*"+memberFileSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members: %w", err)
+	}
+	var members []string
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue // removed while listing
+		}
+		var record memberRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			log.Printf("⚠️  Warning: ignoring unreadable heartbeat %s: %v", path, err)
+			continue
+		}
+		if now.Sub(record.HeartbeatAt) <= m.ttl {
+			members = append(members, record.ID)
+		}
+	}
+	return members, nil
+}
+
+// Leave removes identity's heartbeat file so the others rebalance at once
+func (m *DirectoryMembership) Leave(identity string) error {
+	if err := os.Remove(m.memberPath(identity)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove heartbeat: %w", err)
+	}
+	return nil
+}
+
+// memberPath is where identity's heartbeat is written
+func (m *DirectoryMembership) memberPath(identity string) string {
+	return filepath.Join(m.dir, identity+memberFileSuffix)
+}
+
+// Sharder splits the queues between scheduler instances: each queue ID is
+// hashed onto a ring of the live members and only its owner processes it.
+//
+// A joining instance owns nothing until one refresh interval after its
+// first heartbeat. By then the others have seen it and dropped the queues
+// it takes over, so no queue is processed twice.
+type Sharder struct {
+	identity   string
+	membership Membership
+	interval   time.Duration
+	clock      Clock
+
+	mu       sync.RWMutex
+	ring     *HashRing
+	joinedAt time.Time
+}
+
+// NewSharder creates a sharder for the instance named identity, refreshing
+// the membership every interval
+func NewSharder(identity string, membership Membership, interval time.Duration, clock Clock) *Sharder {
+	return &Sharder{
+		identity:   identity,
+		membership: membership,
+		interval:   interval,
+		clock:      clock,
+		ring:       NewHashRing(nil),
+	}
+}
+
+// Run keeps the membership fresh until ctx is done, then leaves it
+func (s *Sharder) Run(ctx context.Context) {
+	log.Printf("🧩 Sharding queues as %s using %s", s.identity, s.membership.Name())
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.Refresh(ctx)
+
+		select {
+		case <-ctx.Done():
+			if err := s.membership.Leave(s.identity); err != nil {
+				log.Printf("Error leaving shard membership: %v", err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh heartbeats and rebuilds the ring if the members changed. On an
+// error the previous ring is kept.
+func (s *Sharder) Refresh(ctx context.Context) {
+	members, err := s.membership.Heartbeat(ctx, s.identity)
+	if err != nil {
+		log.Printf("Shard membership error: %v", err)
+		return
+	}
+	if !slices.Contains(members, s.identity) {
+		members = append(members, s.identity)
+	}
+	ring := NewHashRing(members)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.joinedAt.IsZero() {
+		s.joinedAt = s.clock.Now()
+		log.Printf("🧩 Joined the shard members, taking over queues in %v", s.interval)
+	}
+	if slices.Equal(ring.Members(), s.ring.Members()) {
+		return
+	}
+	log.Printf("🧩 Shard members changed: %s", strings.Join(ring.Members(), ", "))
+	s.ring = ring
+}
+
+// Owner returns the instance a queue belongs to
+func (s *Sharder) Owner(queueID string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ring.Owner(queueID)
+}
+
+// Owns reports whether this instance processes a queue, never while it is
+// still joining
+func (s *Sharder) Owns(queueID string) bool {
+	s.mu.RLock()
+	joining := s.joinedAt.IsZero() || s.clock.Now().Sub(s.joinedAt) < s.interval
+	s.mu.RUnlock()
+	return !joining && s.Owner(queueID) == s.identity
+}
+
+// estimatedQueues returns the queues an instance estimates: the ones it owns
+// and those sharing a counter pool with them, whose customers take the same
+// counters. The rest are estimated by their owners.
+func (s *Scheduler) estimatedQueues(queues []Queue, owned map[string]bool) []Queue {
+	var estimated []Queue
+	for _, queue := range queues {
+		shared := slices.ContainsFunc(s.calculator.alternatives(queue.QueueID), func(other string) bool {
+			return owned[other]
+		})
+		if owned[queue.QueueID] || shared {
+			estimated = append(estimated, queue)
+		}
+	}
+	return estimated
+}
+
+// WriteMetrics writes the shard membership in the Prometheus text format
+func (s *Sharder) WriteMetrics(w io.Writer) {
+	s.mu.RLock()
+	members := len(s.ring.Members())
+	s.mu.RUnlock()
+
+	fmt.Fprintln(w, "# HELP queue_scheduler_shard_members Scheduler instances sharing the queues.")
+	fmt.Fprintln(w, "# TYPE queue_scheduler_shard_members gauge")
+	fmt.Fprintf(w, "queue_scheduler_shard_members{instance=%q} %d\n", s.identity, members)
+}
+
+// ownsQueue reports whether this instance processes a queue: always without
+// sharding. Queues moving to or away from this instance are logged.
+func (s *Scheduler) ownsQueue(queueID string) bool {
+	if s.sharder == nil {
+		return true
+	}
+
+	owns := s.sharder.Owns(queueID)
+	owned, seen := s.owned[queueID]
+	switch owner := s.sharder.Owner(queueID); {
+	case owns && !owned:
+		log.Printf("➕ Queue %s is now processed by this instance", queueID)
+	case !owns && (owned || !seen) && owner != s.sharder.identity:
+		log.Printf("➖ Queue %s is processed by %s", queueID, owner)
+	}
+	s.owned[queueID] = owns
+	return owns
+}
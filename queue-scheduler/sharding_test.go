@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashRing(t *testing.T) {
+	three := NewHashRing([]string{"c", "a", "b"})
+	two := NewHashRing([]string{"a", "b"})
+
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("Q%03d", i)
+		owner := three.Owner(key)
+		counts[owner]++
+
+		// Only c's queues move when c leaves
+		if owner != "c" && two.Owner(key) != owner {
+			t.Errorf("%s moved from %s to %s when c left", key, owner, two.Owner(key))
+		}
+	}
+	for _, member := range three.Members() {
+		if counts[member] < 60 || counts[member] > 140 {
+			t.Errorf("%s owns %d of 300 queues, want a fairer share: %v", member, counts[member], counts)
+		}
+	}
+
+	if got := NewHashRing(nil).Owner("Q001"); got != "" {
+		t.Errorf("empty ring owner = %q, want none", got)
+	}
+}
+
+func TestMembership(t *testing.T) {
+	ctx := context.Background()
+	clock := NewVirtualClock(testNow)
+	membership := NewDirectoryMembership(t.TempDir(), 15*time.Second, clock)
+
+	heartbeat := func(identity string, want ...string) {
+		t.Helper()
+		got, err := membership.Heartbeat(ctx, identity)
+		if err != nil {
+			t.Fatalf("Heartbeat: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s's heartbeat at +%v: members %v, want %v", identity, clock.Now().Sub(testNow), got, want)
+		}
+	}
+
+	heartbeat("a", "a")
+	heartbeat("b", "a", "b")
+	clock.Advance(10 * time.Second)
+	heartbeat("b", "a", "b")
+
+	// a stops heartbeating and drops out once its heartbeat is stale; c
+	// leaves cleanly and drops out at once
+	clock.Advance(10 * time.Second)
+	heartbeat("c", "b", "c")
+	if err := membership.Leave("c"); err != nil {
+		t.Fatalf("Leave: %v", err)
+	}
+	heartbeat("b", "b")
+
+	static, err := NewMembership("a, b", "", time.Minute, clock)
+	if err != nil {
+		t.Fatalf("NewMembership: %v", err)
+	}
+	if _, err := static.Heartbeat(ctx, "x"); err == nil {
+		t.Error("heartbeat of an instance that isn't a static member succeeded")
+	}
+	if _, err := NewMembership("a,b", t.TempDir(), time.Minute, clock); err == nil {
+		t.Error("both static and directory membership accepted")
+	}
+}
+
+func TestShardedScheduling(t *testing.T) {
+	var queues []Queue
+	for i := 1; i <= 6; i++ {
+		queue := sampleQueue()
+		queue.QueueID = fmt.Sprintf("Q%d", i)
+		for j := range queue.Entries {
+			queue.Entries[j].MSISDN = fmt.Sprintf("276%d%s", i, queue.Entries[j].ID)
+		}
+		queues = append(queues, queue)
+	}
+	django := newFakeDjango(t, queues...)
+	graph := newFakeGraphAPI(t)
+	clock := NewVirtualClock(testNow)
+	dir := t.TempDir()
+
+	newInstance := func(identity string) *Scheduler {
+		config := DefaultConfig()
+		scheduler := mustNewScheduler(t,
+			NewAPIClient(django.URL),
+			NewQueueCalculator(clock, config),
+			NewAlertSystem(clock, graph.config(), config),
+			clock,
+			config,
+		)
+		membership := NewDirectoryMembership(dir, 15*time.Second, clock)
+		scheduler.sharder = NewSharder(identity, membership, 5*time.Second, clock)
+		return scheduler
+	}
+	a, b := newInstance("a"), newInstance("b")
+
+	ctx := context.Background()
+	a.sharder.Refresh(ctx)
+	b.sharder.Refresh(ctx)
+	for _, queue := range queues {
+		if a.sharder.Owns(queue.QueueID) || b.sharder.Owns(queue.QueueID) {
+			t.Errorf("%s owned while joining", queue.QueueID)
+		}
+	}
+
+	// Once joined every queue is processed by exactly one of them
+	clock.Advance(5 * time.Second)
+	a.sharder.Refresh(ctx)
+	b.sharder.Refresh(ctx)
+	a.processQueues()
+	b.processQueues()
+	got := messagesTo(graph)
+	for _, queue := range queues {
+		for _, entry := range queue.Entries {
+			if entry.Status != "served" && len(got[entry.MSISDN]) != 1 {
+				t.Errorf("messages to %s = %q, want one", entry.MSISDN, got[entry.MSISDN])
+			}
+		}
+	}
+	var owners []string
+	for _, queue := range queues {
+		owners = append(owners, a.sharder.Owner(queue.QueueID))
+	}
+	if !strings.Contains(strings.Join(owners, ""), "a") || !strings.Contains(strings.Join(owners, ""), "b") {
+		t.Errorf("queue owners %v, want both instances used", owners)
+	}
+
+	// b leaves; a takes over its queues without telling anyone at a
+	// counter they're being served again
+	if err := b.sharder.membership.Leave("b"); err != nil {
+		t.Fatalf("Leave: %v", err)
+	}
+	clock.Advance(time.Minute)
+	a.sharder.Refresh(ctx)
+	a.processQueues()
+	for _, queue := range queues {
+		if !a.sharder.Owns(queue.QueueID) {
+			t.Errorf("a doesn't own %s after b left", queue.QueueID)
+		}
+		current := queue.Entries[3].MSISDN // CURR1, being served
+		if messages := messagesTo(graph)[current]; len(messages) != 1 {
+			t.Errorf("messages to %s = %q, want only the first", current, messages)
+		}
+	}
+
+	// Only owned queues and their counter pool mates are estimated
+	a.calculator.SetCounterPool(CounterPool{Name: "hall", Counters: 2, Queues: []string{"Q1", "Q2"}})
+	var ids []string
+	for _, queue := range a.estimatedQueues(queues, map[string]bool{"Q1": true, "Q3": true}) {
+		ids = append(ids, queue.QueueID)
+	}
+	if got := strings.Join(ids, ","); got != "Q1,Q2,Q3" {
+		t.Errorf("estimated queues = %s, want Q1,Q2,Q3", got)
+	}
+
+	recorder := httptest.NewRecorder()
+	NewStatusServer("", a).server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if want := `queue_scheduler_shard_members{instance="a"} 1`; !strings.Contains(recorder.Body.String(), want) {
+		t.Errorf("metrics missing %q:\n%s", want, recorder.Body.String())
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"time"
+)
+
+// simulationRuns is the number of Monte Carlo runs behind each percentile
+const simulationRuns = 500
+
+// WaitRange is a wait estimate as a range of likely values
+type WaitRange struct {
+	P50 time.Duration // half of customers wait less than this
+	P90 time.Duration // nine in ten customers wait less than this
+}
+
+// serviceSampler draws service times from the recent empirical distribution,
+// or from an exponential distribution around the fallback average when there
+// is no history yet
+type serviceSampler struct {
+	history []time.Duration
+	average time.Duration
+	rng     *rand.Rand
+}
+
+// service draws a full service time
+func (s *serviceSampler) service() time.Duration {
+	if len(s.history) == 0 {
+		return time.Duration(s.rng.ExpFloat64() * float64(s.average))
+	}
+	return s.history[s.rng.IntN(len(s.history))]
+}
+
+// residual draws the remaining service of a customer who has been at the
+// counter for elapsed, conditioning on services that lasted longer
+func (s *serviceSampler) residual(elapsed time.Duration) time.Duration {
+	var longer []time.Duration
+	for _, service := range s.history {
+		if service > elapsed {
+			longer = append(longer, service)
+		}
+	}
+
+	if len(longer) == 0 {
+		// Nothing in history lasted this long: fall back to a fresh service,
+		// matching residualServiceTime
+		return time.Duration(s.rng.ExpFloat64() * float64(s.average))
+	}
+	return longer[s.rng.IntN(len(longer))] - elapsed
+}
+
+// busyCounter is a counter serving a customer of a lane, who has been
+// there for elapsed
+type busyCounter struct {
+	lane    int
+	elapsed time.Duration
+}
+
+// simulationPass is one run through the counters in a given order. join is
+// the lane whose customer joining now is in the order, or -1 for none.
+type simulationPass struct {
+	order []laneSlot
+	join  int
+}
+
+// simulateWaitRanges replays the counter simulation many times with service
+// times drawn by each lane's sampler (all sharing one generator), and
+// returns per lane the p50/p90 waits of each waiting customer followed by
+// one extra slot for a customer joining now. elapsed lists the busy
+// counters, and leave each waiting customer's chance of giving up before
+// reaching a counter; in runs where they do, they don't hold up anyone
+// behind them. reserved holds when booked appointments are due (see
+// serveReserved).
+//
+// Waiting customers are served in order; joinOrders[l] is the same order
+// with a customer joining lane l now. With a single lane that customer comes
+// last, so one pass per run covers everyone.
+func simulateWaitRanges(samplers []*serviceSampler, servers int, elapsed []busyCounter, leave [][]float64,
+	reserved []reservedSlot, order []laneSlot, joinOrders [][]laneSlot) [][]WaitRange {
+	samples := make([][][]time.Duration, len(samplers))
+	for l := range samples {
+		samples[l] = make([][]time.Duration, len(leave[l])+1)
+		for i := range samples[l] {
+			samples[l][i] = make([]time.Duration, simulationRuns)
+		}
+	}
+
+	passes := []simulationPass{{order: joinOrders[0], join: 0}}
+	if len(samplers) > 1 {
+		passes = []simulationPass{{order: order, join: -1}}
+		for l, joinOrder := range joinOrders {
+			passes = append(passes, simulationPass{order: joinOrder, join: l})
+		}
+	}
+	recordsWaiting := func(pass simulationPass) bool { return pass.join < 0 || len(samplers) == 1 }
+	service := func(lane int) time.Duration { return samplers[lane].service() }
+
+	initial := make([]time.Duration, servers)
+	counters := make([]time.Duration, servers)
+	for run := 0; run < simulationRuns; run++ {
+		for i := range initial {
+			initial[i] = 0
+		}
+		for i, busy := range elapsed {
+			initial[i] = samplers[busy.lane].residual(busy.elapsed)
+		}
+
+		for _, pass := range passes {
+			copy(counters, initial)
+			pending := reserved
+
+			for _, slot := range pass.order {
+				pending = serveReserved(counters, pending, service)
+				joining := slot.index == len(leave[slot.lane])
+
+				var wait time.Duration
+				if !joining && leave[slot.lane][slot.index] > 0 && samplers[slot.lane].rng.Float64() < leave[slot.lane][slot.index] {
+					wait = counters[earliestCounter(counters)]
+				} else {
+					wait = nextCounter(counters, samplers[slot.lane].service())
+				}
+
+				if (joining && slot.lane == pass.join) || (!joining && recordsWaiting(pass)) {
+					samples[slot.lane][slot.index][run] = wait
+				}
+			}
+		}
+	}
+
+	ranges := make([][]WaitRange, len(samplers))
+	for l := range samples {
+		ranges[l] = make([]WaitRange, len(samples[l]))
+		for i, waits := range samples[l] {
+			sort.Slice(waits, func(a, b int) bool { return waits[a] < waits[b] })
+			ranges[l][i] = WaitRange{
+				P50: percentile(waits, 0.5),
+				P90: percentile(waits, 0.9),
+			}
+		}
+	}
+
+	return ranges
+}
+
+// percentile returns the nearest-rank percentile p (0-1) of sorted values
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// simulationSeed derives a generator seed from the queue and the current
+// time, so estimates are reproducible in tests and replays
+func simulationSeed(queueID string, now time.Time) (uint64, uint64) {
+	var h uint64 = 14695981039346656037 // FNV-1a offset basis
+	for i := 0; i < len(queueID); i++ {
+		h ^= uint64(queueID[i])
+		h *= 1099511628211
+	}
+	return h, uint64(now.UnixNano())
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// QueueSource provides snapshots of all queues and their entries
+type QueueSource interface {
+	GetAllQueues() (*APIResponse, error)
+}
+
+// ChangeNotifier is implemented by sources that can signal when queue data
+// has changed, letting the scheduler poll immediately instead of waiting for
+// the next tick
+type ChangeNotifier interface {
+	Changes() <-chan struct{}
+}
+
+// NewQueueSource builds the source selected by kind ("http" or "postgres")
+func NewQueueSource(kind, djangoBaseURL, databaseURL string) (QueueSource, error) {
+	switch strings.ToLower(kind) {
+	case "", "http":
+		return NewAPIClient(djangoBaseURL), nil
+	case "postgres":
+		if databaseURL == "" {
+			return nil, fmt.Errorf("DATABASE_URL is required for the postgres queue source")
+		}
+		return NewPostgresSource(databaseURL)
+	default:
+		return nil, fmt.Errorf("unknown queue source %q", kind)
+	}
+}
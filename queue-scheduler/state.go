@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stateSchemaVersion is bumped whenever the on-disk snapshot layout changes.
+// Older snapshots are upgraded in migrateState; newer ones are rejected.
+//
+// Version history:
+//
+//	1: sent alerts keyed by "msisdn:queue:channel"
+//	2: sent alerts keyed by "entryKey:queue:channel" (see QueueEntry.Key)
+//	3: adds learned abandonment curves; older files start without any
+//	4: adds sent appointment reminders and no-show messages
+//	5: adds the messages per phone number counted by the daily cap
+//	6: adds the prediction accuracy totals
+const stateSchemaVersion = 6
+
+// StateSnapshot is the scheduler and alert state persisted between restarts
+type StateSnapshot struct {
+	Version        int                  `json:"version"`
+	SavedAt        time.Time            `json:"saved_at"`
+	PreviousQueues map[string]Queue     `json:"previous_queues"`
+	SentAlerts     map[string]time.Time `json:"sent_alerts"`
+
+	Abandonment map[string]*AbandonmentCurve `json:"abandonment,omitempty"`
+
+	AppointmentAlerts map[string]time.Time `json:"appointment_alerts,omitempty"`
+
+	DailyMessages map[string][]time.Time `json:"daily_messages,omitempty"`
+
+	Accuracy []AccuracyTotals `json:"accuracy,omitempty"`
+}
+
+// StateStore persists snapshots as a JSON file on local disk
+type StateStore struct {
+	path string
+}
+
+// NewStateStore creates a store backed by the file at path
+func NewStateStore(path string) *StateStore {
+	return &StateStore{path: path}
+}
+
+// Load reads the last saved snapshot. It returns nil without error when no
+// snapshot has been written yet.
+func (s *StateStore) Load() (*StateSnapshot, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var snapshot StateSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode state file: %w", err)
+	}
+
+	if err := migrateState(&snapshot); err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// Save writes the snapshot atomically so a crash mid-write never leaves a
+// truncated state file behind
+func (s *StateStore) Save(snapshot StateSnapshot) error {
+	snapshot.Version = stateSchemaVersion
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	return writeFileAtomic(s.path, data)
+}
+
+// writeFileAtomic replaces the file at path with data via a synced temp file
+// in the same directory and a rename
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", path, err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// migrateState upgrades older snapshot layouts to the current version
+func migrateState(snapshot *StateSnapshot) error {
+	if snapshot.Version > stateSchemaVersion {
+		return fmt.Errorf("state file version %d is newer than supported version %d",
+			snapshot.Version, stateSchemaVersion)
+	}
+	if snapshot.Version < 1 {
+		return fmt.Errorf("unknown state file version %d", snapshot.Version)
+	}
+
+	if snapshot.PreviousQueues == nil {
+		snapshot.PreviousQueues = make(map[string]Queue)
+	}
+	if snapshot.SentAlerts == nil {
+		snapshot.SentAlerts = make(map[string]time.Time)
+	}
+
+	if snapshot.Version == 1 {
+		migrateStateV1(snapshot)
+	}
+
+	snapshot.Version = stateSchemaVersion
+	return nil
+}
+
+// migrateStateV1 rewrites MSISDN rate limit keys to entry keys by looking the
+// number up among the active entries of the saved queue. Keys that can't be
+// resolved are dropped; at worst that customer gets one extra update.
+func migrateStateV1(snapshot *StateSnapshot) {
+	migrated := make(map[string]time.Time, len(snapshot.SentAlerts))
+
+	for key, timestamp := range snapshot.SentAlerts {
+		parts := strings.SplitN(key, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		msisdn, queueID, channel := parts[0], parts[1], parts[2]
+
+		for _, entry := range snapshot.PreviousQueues[queueID].Entries {
+			if entry.MSISDN == msisdn && !entry.Left && entry.Status != "served" {
+				migrated[fmt.Sprintf("%s:%s:%s", entry.Key(), queueID, channel)] = timestamp
+				break
+			}
+		}
+	}
+
+	snapshot.SentAlerts = migrated
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateStoreRoundTrip(t *testing.T) {
+	store := NewStateStore(filepath.Join(t.TempDir(), "state.json"))
+
+	snapshot, err := store.Load()
+	if err != nil || snapshot != nil {
+		t.Fatalf("Load() on missing file = %v, %v; want nil, nil", snapshot, err)
+	}
+
+	saved := StateSnapshot{
+		SavedAt:        testNow,
+		PreviousQueues: map[string]Queue{"Q1": sampleQueue()},
+		SentAlerts:     map[string]time.Time{"WAIT1:Q1:whatsapp": testNow},
+	}
+	if err := store.Save(saved); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if loaded.Version != stateSchemaVersion {
+		t.Errorf("Version = %d, want %d", loaded.Version, stateSchemaVersion)
+	}
+	if got := len(loaded.PreviousQueues["Q1"].Entries); got != len(sampleQueue().Entries) {
+		t.Errorf("restored %d entries, want %d", got, len(sampleQueue().Entries))
+	}
+	if !loaded.SentAlerts["WAIT1:Q1:whatsapp"].Equal(testNow) {
+		t.Errorf("SentAlerts = %v", loaded.SentAlerts)
+	}
+}
+
+func TestStateStoreSaveReplacesFile(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStateStore(filepath.Join(dir, "state.json"))
+
+	for _, at := range []time.Time{testNow, testNow.Add(time.Minute)} {
+		if err := store.Save(StateSnapshot{SavedAt: at}); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !loaded.SavedAt.Equal(testNow.Add(time.Minute)) {
+		t.Errorf("SavedAt = %v, want the latest save", loaded.SavedAt)
+	}
+
+	// Temp files are renamed over the state file, never left behind
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "state.json" {
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		t.Errorf("directory holds %v, want only state.json", names)
+	}
+}
+
+func TestStateStoreLoadVersions(t *testing.T) {
+	tests := []struct {
+		name       string
+		contents   string
+		wantErr    bool
+		wantAlerts map[string]bool
+	}{
+		{
+			name:     "newer version is rejected",
+			contents: `{"version": 99}`,
+			wantErr:  true,
+		},
+		{
+			name:     "missing version is rejected",
+			contents: `{}`,
+			wantErr:  true,
+		},
+		{
+			name:     "corrupt file is rejected",
+			contents: `{"version": `,
+			wantErr:  true,
+		},
+		{
+			name: "v1 MSISDN keys are migrated to entry keys",
+			contents: `{
+				"version": 1,
+				"previous_queues": {"Q1": {"queue_id": "Q1", "entries": [
+					{"id": "OLD", "msisdn": "2760", "status": "served"},
+					{"id": "NEW", "msisdn": "2760", "status": "waiting"}
+				]}},
+				"sent_alerts": {
+					"2760:Q1:whatsapp": "2025-08-17T12:00:00Z",
+					"2799:Q1:whatsapp": "2025-08-17T12:00:00Z"
+				}
+			}`,
+			wantAlerts: map[string]bool{"NEW:Q1:whatsapp": true},
+		},
+		{
+			name: "v2 loads without abandonment curves",
+			contents: `{
+				"version": 2,
+				"sent_alerts": {"E1:Q1:whatsapp": "2025-08-17T12:00:00Z"}
+			}`,
+			wantAlerts: map[string]bool{"E1:Q1:whatsapp": true},
+		},
+		{
+			name: "v3 loads without appointment messages",
+			contents: `{
+				"version": 3,
+				"sent_alerts": {"E1:Q1:whatsapp": "2025-08-17T12:00:00Z"},
+				"abandonment": {}
+			}`,
+			wantAlerts: map[string]bool{"E1:Q1:whatsapp": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			if err := os.WriteFile(path, []byte(tt.contents), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			snapshot, err := NewStateStore(path).Load()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Load() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+
+			if snapshot.Version != stateSchemaVersion {
+				t.Errorf("Version = %d, want %d", snapshot.Version, stateSchemaVersion)
+			}
+			if len(snapshot.SentAlerts) != len(tt.wantAlerts) {
+				t.Errorf("SentAlerts = %v, want keys %v", snapshot.SentAlerts, tt.wantAlerts)
+			}
+			for key := range tt.wantAlerts {
+				if _, ok := snapshot.SentAlerts[key]; !ok {
+					t.Errorf("missing migrated key %q in %v", key, snapshot.SentAlerts)
+				}
+			}
+		})
+	}
+}